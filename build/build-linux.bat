@@ -7,6 +7,7 @@ SET GOOS=linux
 SET GOARCH=amd64
 go build -v -o code-generator ./cmd/code-generator/
 go build -v -o config-generator ./cmd/config-generator/
+go build -v -o dashboard-generator ./cmd/dashboard-generator/
 go build -v -o e2etest ./cmd/e2etest/
 go build -v -o v2 ./cmd/v2/
 echo Build OK
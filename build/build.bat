@@ -6,5 +6,7 @@ SET GOOS=windows
 SET CGO_ENABLED=0
 SET GOARCH=amd64
 go build -v -o code-generator.exe ./cmd/code-generator/
+go build -v -o config-generator.exe ./cmd/config-generator/
+go build -v -o dashboard-generator.exe ./cmd/dashboard-generator/
 go build -v -o v2.exe ./cmd/v2/
 echo Build OK
@@ -0,0 +1,74 @@
+// config-generator 根据注册的模块生成带注释的默认配置，或者检查已有的配置文件
+//
+//	config-generator -o configs/modules.yaml
+//	config-generator -check configs/modules.yaml
+package main
+
+import (
+	"bytes"
+	_ "espresso/modules" // 注册全部模块
+	"espresso/pkg/config"
+	"espresso/pkg/modules"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "modules.yaml", "生成的配置文件路径，- 输出到标准输出")
+	check := flag.String("check", "", "检查已有的配置文件，报告未知的和缺少的key")
+	flag.Parse()
+
+	sections := moduleSections()
+
+	if *check != "" {
+		problems, err := config.Check(*check, sections)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) != 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := config.Generate(&buf, config.DefaultAppConfig(), sections); err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "-" {
+		_, _ = os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("generate %s, %d modules\n", *output, len(sections))
+}
+
+// moduleSections 有配置的模块
+func moduleSections() []config.ModuleSection {
+	var sections []config.ModuleSection
+	for _, module := range modules.Registered() {
+		configurable, ok := module.(modules.Configurable)
+		if !ok {
+			continue
+		}
+
+		moduleConfig := configurable.ModuleConfig()
+		if moduleConfig == nil {
+			continue
+		}
+
+		sections = append(sections, config.ModuleSection{UID: module.ModuleUID(), Config: moduleConfig})
+	}
+
+	return sections
+}
@@ -0,0 +1,33 @@
+// dashboard-generator 生成metric模块的grafana面板，在grafana里导入生成的json
+//
+//	dashboard-generator -o build/grafana/espresso.json
+package main
+
+import (
+	"espresso/modules/metric"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "espresso.json", "生成的面板文件路径，- 输出到标准输出")
+	title := flag.String("title", "espresso", "面板标题")
+	flag.Parse()
+
+	data, err := metric.Dashboard(*title)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+
+	if *output == "-" {
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("generate %s\n", *output)
+}
@@ -0,0 +1,7 @@
+//go:build example
+
+package main
+
+import (
+	_ "espresso/examples/echo/modules" // go build -tags example 时带上例子模块
+)
@@ -0,0 +1,219 @@
+// e2etest 压测 /daydream/:module/:message，输出吞吐、错误码和延迟分布
+//
+//	e2etest -target http://127.0.0.1:8080 -route say::hello -payload '{"content":"hi {{.Seq}}"}' -c 100 -d 30s
+//	e2etest -route say::hello -payload '{"content":"{{randString 16}}"}' -rate 5000 -d 1m -json -o report.json
+//	e2etest -inprocess -config configs/modules.yaml -route say::hello -payload '{"content":"hi"}' -n 100000 -max-failure 0.001
+//
+// 进程内压测只有编译进来的模块：把e2etest复制到自己的项目里import自己的模块，
+// 或者 go build -tags example 带上echo例子的模块
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"espresso"
+	_ "espresso/modules" // 框架自带的模块
+	"espresso/pkg/config"
+	"espresso/pkg/loadtest"
+	"flag"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// multiFlag 可以重复的参数
+type multiFlag []string
+
+func (values *multiFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *multiFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run 压测并输出结果，返回退出码，退出前先停掉进程内的App
+func run() int {
+	target := flag.String("target", "http://127.0.0.1:8080", "服务地址")
+	route := flag.String("route", "", "压测的消息，格式 module::message")
+	method := flag.String("method", "POST", "请求方法，GET时请求内容是query，比如 content=hi {{.Seq}}，按key=value编码")
+	concurrency := flag.Int("c", 10, "并发数")
+	rate := flag.Float64("rate", 0, "每秒请求数，0不限速")
+	duration := flag.Duration("d", 10*time.Second, "压测时长，0只按请求数")
+	requests := flag.Int64("n", 0, "最多发送的请求数，0只按时长")
+	timeout := flag.Duration("timeout", 5*time.Second, "单个请求的超时")
+	payloadFile := flag.String("payload-file", "", "请求模板文件，一行一个")
+	asJSON := flag.Bool("json", false, "输出json")
+	output := flag.String("o", "-", "结果写到文件，- 输出到标准输出")
+	maxFailure := flag.Float64("max-failure", -1, "失败比例超过时退出码为1，比如 0.01，负数不检查")
+	inProcess := flag.Bool("inprocess", false, "在进程内启动App压测，安装编译进来的模块，不走网络")
+	configFile := flag.String("config", "", "进程内App的配置文件")
+	var payloads, headers multiFlag
+	flag.Var(&payloads, "payload", "请求模板，可以重复，轮流使用，比如 {\"content\":\"{{randString 8}}\"}")
+	flag.Var(&headers, "H", "请求header，可以重复，比如 \"Authorization: Bearer xxx\"")
+	flag.Parse()
+
+	if *payloadFile != "" {
+		lines, err := readLines(*payloadFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		payloads = append(payloads, lines...)
+	}
+
+	options := []loadtest.Option{
+		loadtest.Route(*route),
+		loadtest.Method(*method),
+		loadtest.Concurrency(*concurrency),
+		loadtest.Rate(*rate),
+		loadtest.Duration(*duration),
+		loadtest.Requests(*requests),
+		loadtest.Timeout(*timeout),
+		loadtest.Payload(payloads...),
+	}
+	for _, header := range headers {
+		key, value, ok := strings.Cut(header, ":")
+		if !ok {
+			log.Fatalf("bad header: %s", header)
+		}
+		options = append(options, loadtest.Header(strings.TrimSpace(key), strings.TrimSpace(value)))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *inProcess {
+		app, err := startApp(ctx, *configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_ = app.Shutdown(shutdownCtx)
+		}()
+		options = append(options, loadtest.Handler(app.Handler()))
+	} else {
+		options = append(options, loadtest.Target(*target))
+	}
+
+	runner, err := loadtest.New(options...)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	report := runner.Run(ctx)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if *asJSON {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if *maxFailure >= 0 && report.Requests > 0 {
+		if ratio := float64(report.Failures) / float64(report.Requests); ratio > *maxFailure {
+			log.Printf("failure ratio %.4f > %.4f", ratio, *maxFailure)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// startApp 进程内启动App，不监听端口，等到模块初始化好
+func startApp(ctx context.Context, configFile string) (*espresso.App, error) {
+	var configOptions []config.Option
+	if configFile != "" {
+		configOptions = append(configOptions, config.File(configFile))
+	}
+	cfg, err := config.Load(configOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 压测时只输出警告以上的日志，stderr是管道时Sync会失败，包一层去掉Sync
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), zapcore.AddSync(struct{ io.Writer }{os.Stderr}), zap.WarnLevel)
+	logger := zap.New(core)
+
+	ready := make(chan struct{})
+	app := espresso.New(
+		espresso.Logger(&minilog.MiniLog{Logger: logger}),
+		espresso.Config(cfg),
+		espresso.InProcess(),
+		espresso.RuntimeStat(""),
+		espresso.OnReady(func(ctx context.Context) error {
+			close(ready)
+			return nil
+		}),
+	)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- app.Run()
+	}()
+
+	select {
+	case <-ready:
+		return app, nil
+	case err := <-runErr:
+		if err == nil {
+			err = errors.New("app exit before ready")
+		}
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLines 读取模板文件，跳过空行和#开头的行
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return lines, nil
+}
@@ -3,9 +3,19 @@ package espresso
 import (
 	"context"
 	internal "espresso/internal"
-	_ "espresso/modules" // 注册全部模块到mvc
+	"espresso/pkg/admin"
+	"espresso/pkg/config"
+	"espresso/pkg/election"
+	"espresso/pkg/metricpush"
+	"espresso/pkg/network"
+	"espresso/pkg/panics"
+	"espresso/pkg/profiler"
+	"espresso/pkg/requestid"
+	"espresso/pkg/tasks"
+	"espresso/pkg/tracing"
 	"github.com/dan-and-dna/minilog"
 	"github.com/prometheus/client_golang/prometheus"
+	"time"
 )
 
 type App = internal.App
@@ -13,6 +23,8 @@ type App = internal.App
 type Option = internal.Option
 type Plugin = internal.Plugin
 type Handler = internal.Handler
+type ShutdownConfig = internal.ShutdownConfig
+type AccessLogConfig = network.AccessLogConfig
 
 func New(applyOptions ...Option) *App {
 	return internal.New(applyOptions...)
@@ -30,6 +42,11 @@ func Http(address string) Option {
 	return internal.Http(address)
 }
 
+// InProcess 只创建http处理，不监听端口，用 App.Handler() 在进程内调用，比如压测和测试
+func InProcess() Option {
+	return internal.InProcess()
+}
+
 func Grpc(address string) Option {
 	return internal.Grpc(address)
 }
@@ -49,3 +66,101 @@ func ModuleContext(f func(ctx context.Context) context.Context) Option {
 func ModulePlugin(plugin Plugin) Option {
 	return internal.ModulePlugin(plugin)
 }
+
+// Election 开启leader选举，mvc.Singleton()的定时任务只在leader上执行
+func Election(key string, locker election.Locker) Option {
+	return internal.Election(key, locker)
+}
+
+// CronAdmin 开启定时任务管理接口 /admin/cron，只挂在管理端口，没有设置 Admin 时Run返回错误
+func CronAdmin(enable bool) Option {
+	return internal.CronAdmin(enable)
+}
+
+// LogAdmin 日志级别管理接口，GET /admin/log/levels，POST|DELETE /admin/log/levels/:module，只挂在管理端口，没有设置 Admin 时Run返回错误
+func LogAdmin(enable bool) Option {
+	return internal.LogAdmin(enable)
+}
+
+// LogLevel 设置模块的日志级别 debug、info、warn、error
+func LogLevel(module, level string) Option {
+	return internal.LogLevel(module, level)
+}
+
+// AccessLog 访问日志，错误和慢请求总是记录，其他按采样率记录；Logger为空写到app日志，单独文件参考 network.NewAccessLogger
+func AccessLog(config AccessLogConfig) Option {
+	return internal.AccessLog(config)
+}
+
+// RequestId 请求id生成器，默认 requestid.ULID()，trustInbound为true时沿用上游传过来的X-Request-Id
+func RequestId(generator requestid.Generator, trustInbound bool) Option {
+	return internal.RequestId(generator, trustInbound)
+}
+
+// Tracing 开启链路追踪，http入口、mvc插件和处理函数、事件、定时任务和任务队列都会创建span，
+// 比如 espresso.Tracing(tracing.WithExporter(exporter), tracing.SampleRatio(0.1))
+func Tracing(options ...tracing.Option) Option {
+	return internal.Tracing(options...)
+}
+
+// Admin 单独的管理端口，metric、pprof、statsviz、健康检查、路由列表、定时任务和日志级别管理都挂在这里，
+// 可以用 admin.BasicAuth、admin.Token、admin.AllowIPs 保护
+func Admin(address string, options ...admin.Option) Option {
+	return internal.Admin(address, options...)
+}
+
+// Profiler 持续采集cpu、heap、goroutine和mutex写到本地目录或者推送到pyroscope，慢调用、崩溃或者超过阈值时马上采集一次
+func Profiler(options ...profiler.Option) Option {
+	return internal.Profiler(options...)
+}
+
+// PanicReporter 崩溃带上调用栈按指纹分组，上报到sentry类的http服务、webhook或者本地文件，同一分组第一次马上上报，之后按间隔
+func PanicReporter(reporters ...panics.Reporter) Option {
+	return internal.PanicReporter(reporters...)
+}
+
+// PanicReportInterval 同一分组上报的最短间隔，默认1分钟
+func PanicReportInterval(interval time.Duration) Option {
+	return internal.PanicReportInterval(interval)
+}
+
+// MetricPush 定时把metric推送到Pushgateway或者remote write，带上job和instance标签，需要打开metric
+func MetricPush(options ...metricpush.Option) Option {
+	return internal.MetricPush(options...)
+}
+
+// Tasks 任务队列配置，默认内存存储
+func Tasks(options ...tasks.Option) Option {
+	return internal.Tasks(options...)
+}
+
+// GracefulShutdown 优雅退出各阶段的超时时间
+func GracefulShutdown(config ShutdownConfig) Option {
+	return internal.GracefulShutdown(config)
+}
+
+// OnStart 模块初始化后、开始监听前调用，返回错误则退出
+func OnStart(hook func(ctx context.Context) error) Option {
+	return internal.OnStart(hook)
+}
+
+// OnReady 全部服务启动后调用，返回错误则退出
+func OnReady(hook func(ctx context.Context) error) Option {
+	return internal.OnReady(hook)
+}
+
+// OnStop 优雅退出的最后阶段调用
+func OnStop(hook func(ctx context.Context) error) Option {
+	return internal.OnStop(hook)
+}
+
+// Config 使用配置文件、环境变量和命令行，参考 config.Load；用 Logger 指定了日志时忽略配置里的app.log
+func Config(cfg *config.Config) Option {
+	return internal.Config(cfg)
+}
+
+// ConfigWatch 每隔interval轮询重新加载配置（不是文件变化通知），模块配置变化时调用 ModuleReconfigure 并发布 events.ModuleReconfigure；
+// 接受后 App.Config() 和 ctxhelper.FetchConfig(ctx) 拿到的都是新配置；app部分的变化需要重启才生效，只打印警告
+func ConfigWatch(interval time.Duration) Option {
+	return internal.ConfigWatch(interval)
+}
@@ -5,12 +5,15 @@ import (
 	"espresso"
 	_ "espresso/examples/echo/modules"
 	"espresso/examples/echo/pkg/ctxhelper"
+	_ "espresso/modules" // metric等框架自带模块
+	"espresso/pkg/config"
 	"espresso/pkg/ctxhelper"
 	"espresso/pkg/mvc"
 	"github.com/dan-and-dna/minilog"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"log"
+	"os"
 	"time"
 )
 
@@ -22,6 +25,12 @@ func main() {
 
 	registry := prometheus.NewRegistry()
 
+	// 配置，比如 go run ./examples/echo -config examples/echo/configs/modules.yaml -http :8090
+	cfg, err := config.Load(config.Args(os.Args[1:]))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	app := espresso.New(
 		// 启动日志
 		espresso.Logger(logger),
@@ -43,6 +52,10 @@ func main() {
 		}),
 		// 模块调用插件
 		espresso.ModulePlugin(plugin("d")),
+		// 每5秒检查配置变化
+		espresso.ConfigWatch(5*time.Second),
+		// 配置覆盖上面的默认值
+		espresso.Config(cfg),
 	)
 
 	if err := app.Run(); err != nil {
@@ -0,0 +1,9 @@
+package internal
+
+import "time"
+
+// Config 模块配置，对应配置文件里 modules.say_v0.1.0
+type Config struct {
+	Prefix   string        `yaml:"prefix" comment:"回复内容的前缀"`
+	SlowTime time.Duration `yaml:"slowTime" comment:"slow接口的耗时" binding:"required"`
+}
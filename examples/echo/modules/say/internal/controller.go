@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"espresso/pkg/ctxhelper"
+	"espresso/pkg/tracing"
 	"go.uber.org/zap"
 	"time"
 )
@@ -16,12 +17,11 @@ type HelloResponse struct {
 }
 
 func (module *Module) Hello(ctx context.Context, request *HelloRequest, response *HelloResponse) error {
-	defer ctxhelper.FetchTrace(ctx).Start().Stop()
-
 	logger := ctxhelper.FetchLogger(ctx)
 
-	logger.Info("received a new message", zap.String("clientIp", ctxhelper.FetchClientIP(ctx)), zap.String("content", request.Content))
-	response.Content = request.Content
+	logger.Info("received a new message", zap.String("content", request.Content))
+	module.hellos.Add(1)
+	response.Content = module.config.Load().Prefix + request.Content
 	return nil
 }
 
@@ -32,8 +32,6 @@ type TryPanicResponse struct {
 }
 
 func (module *Module) TryPanic(ctx context.Context, request *TryPanicRequest, response *TryPanicResponse) error {
-	defer ctxhelper.FetchTrace(ctx).Start().Stop()
-
 	panic("try panic")
 	return nil
 }
@@ -45,8 +43,27 @@ type SlowResponse struct {
 }
 
 func (module *Module) Slow(ctx context.Context, request *SlowRequest, response *SlowResponse) error {
-	defer ctxhelper.FetchTrace(ctx).Start().Stop()
+	// 处理函数已经有span，需要更细的span可以自己创建
+	_, end := tracing.Start(ctx, "sleep")
+	time.Sleep(module.config.Load().SlowTime)
+	end(nil)
 
-	time.Sleep(4 * time.Second)
 	return nil
 }
+
+// Report 每分钟打印一次收到的hello数
+func (module *Module) Report(ctx context.Context) error {
+	logger := ctxhelper.FetchLogger(ctx)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			logger.Info("hello report", zap.Int64("hellos", module.hellos.Swap(0)))
+		}
+	}
+}
@@ -4,14 +4,11 @@ package internal
 
 import (
 	"context"
+	"espresso/pkg/ctxhelper"
 	"espresso/pkg/modules"
 	"espresso/pkg/mvc"
-	"sync"
-)
-
-var (
-	metric *Module = nil
-	once   sync.Once
+	"sync/atomic"
+	"time"
 )
 
 // Say 接口定义
@@ -21,31 +18,50 @@ type Say interface {
 	// 对外接口
 }
 
-func GetSingleInst() Say {
-	if metric == nil {
-		once.Do(func() {
-			metric = new(Module)
-		})
-	}
+// UID 模块uid，登记到模块目录时不用构造实例
+const UID = "say_v0.1.0"
 
-	return metric
+// New 每个App安装时构造自己的实例
+func New() Say {
+	return new(Module)
 }
 
 // Module 模块
 type Module struct {
+	config atomic.Pointer[Config]
+	hellos atomic.Int64
 }
 
 // ModuleUID 模块uid
 func (module *Module) ModuleUID() string {
-	return "say_v0.1.0"
+	return UID
+}
+
+// ModuleConfig 模块配置的默认值
+func (module *Module) ModuleConfig() any {
+	return &Config{
+		SlowTime: 4 * time.Second,
+	}
+}
+
+// ModuleReconfigure 配置热更新
+func (module *Module) ModuleReconfigure(ctx context.Context, old, new any) error {
+	module.config.Store(new.(*Config))
+	return nil
 }
 
 // ModuleInit 模块初始化
 func (module *Module) ModuleInit(ctx context.Context) error {
-	// 注册消息处理函数
-	mvc.Register("say", "hello", module.Hello)
-	mvc.Register("say", "tryPanic", module.TryPanic)
-	mvc.Register("say", "slow", module.Slow)
+	module.config.Store(ctxhelper.FetchModuleConfig(ctx).(*Config))
+
+	// 注册消息处理函数，用安装模块的mvc，多个App或者测试里互不影响
+	m := mvc.FromContext(ctx)
+	m.Register("say", "hello", module.Hello)
+	m.Register("say", "tryPanic", module.TryPanic)
+	m.Register("say", "slow", module.Slow)
+
+	// 后台循环交给框架监督，崩溃后退避重启，模块退出前停掉
+	modules.FromContext(ctx).Supervise("report", module.Report, modules.Policy{Restart: modules.RestartOnFailure})
 
 	return nil
 }
@@ -5,19 +5,13 @@ import (
 	"espresso/pkg/ctxhelper"
 )
 
+var ConfigsKey = ctxhelper.NewKey[string]("configs")
+
 func InjectConfigs(ctx context.Context, configs string) context.Context {
-	const ConfigsKey = "configs_key"
-	ctx = ctxhelper.Set(ctx, ConfigsKey, configs)
-	return ctx
+	return ConfigsKey.Inject(ctx, configs)
 }
 
 func FetchConfigs(ctx context.Context) string {
-	const ConfigsKey = "configs_key"
-	ptr := ctxhelper.Get(ctx, ConfigsKey)
-	if ptr == nil {
-		return ""
-	}
-
-	ret, _ := ptr.(string)
+	ret, _ := ConfigsKey.Fetch(ctx)
 	return ret
 }
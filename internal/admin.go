@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"espresso/pkg/admin"
+	"espresso/pkg/modules"
+	"espresso/pkg/protocol"
+	"espresso/pkg/runtimestat"
+	"github.com/gin-contrib/pprof"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+type route struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// Admin 单独的管理端口，metric、pprof、statsviz和运行时快照、健康检查、路由列表、定时任务和日志级别管理都挂在这里，不再暴露在http端口
+func Admin(address string, adminOptions ...admin.Option) Option {
+	return func(opts *options) {
+		opts.adminAddress = address
+		opts.adminOptions = append(opts.adminOptions, adminOptions...)
+	}
+}
+
+// adminRoutes 管理端口的路由
+func (app *App) adminRoutes() []func(gin.IRouter) {
+	var routes []func(gin.IRouter)
+
+	// metric
+	if app.registry != nil {
+		handler := promhttp.HandlerFor(app.registry, promhttp.HandlerOpts{})
+		routes = append(routes, func(router gin.IRouter) {
+			router.GET("/metric", gin.WrapH(handler))
+		})
+	}
+
+	// pprof
+	if app.opts.enablePProf {
+		routes = append(routes, func(router gin.IRouter) {
+			pprof.RouteRegister(router.Group(""))
+		})
+	}
+
+	// 运行时统计和快照
+	routes = append(routes, runtimestat.Register)
+
+	// 健康检查
+	if app.network != nil {
+		routes = append(routes, app.network.RegisterHealth)
+	}
+
+	// 路由列表
+	routes = append(routes, func(router gin.IRouter) {
+		router.GET("/routes", app.listRoutes)
+	})
+
+	// 崩溃分组
+	routes = append(routes, func(router gin.IRouter) {
+		app.panics.RegisterAdmin(router.Group("/admin"))
+	})
+
+	// 被监督的循环
+	routes = append(routes, func(router gin.IRouter) {
+		router.GET("/admin/supervised", app.listSupervised)
+	})
+
+	// 管理接口
+	if app.opts.enableCronAdmin {
+		routes = append(routes, func(router gin.IRouter) {
+			app.mvc.RegisterTimerAdmin(router.Group("/admin"))
+		})
+	}
+	if app.opts.enableLogAdmin {
+		routes = append(routes, func(router gin.IRouter) {
+			app.logLevels.RegisterAdmin(router.Group("/admin"))
+		})
+	}
+
+	return routes
+}
+
+// listRoutes 列出http端口、管理端口的路由、全部模块消息和安装的模块
+func (app *App) listRoutes(c *gin.Context) {
+	toRoutes := func(infos gin.RoutesInfo) []route {
+		routes := make([]route, 0, len(infos))
+		for _, info := range infos {
+			routes = append(routes, route{Method: info.Method, Path: info.Path})
+		}
+		return routes
+	}
+
+	response := struct {
+		protocol.BaseResponse
+		Http     []route  `json:"http"`
+		Admin    []route  `json:"admin"`
+		Messages []string `json:"messages"`
+		Modules  []string `json:"modules"`
+	}{
+		BaseResponse: protocol.BaseResponse{Code: protocol.CodeOk},
+		Http:         []route{},
+		Admin:        toRoutes(app.admin.Routes()),
+		Messages:     app.mvc.Routes(),
+		Modules:      app.mvc.Modules().Installed(),
+	}
+	if app.network != nil {
+		response.Http = toRoutes(app.network.Routes())
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// listSupervised 列出模块的后台循环、重启次数和不健康的模块
+func (app *App) listSupervised(c *gin.Context) {
+	c.JSON(http.StatusOK, struct {
+		protocol.BaseResponse
+		Supervised []modules.Supervised `json:"supervised"`
+		Unhealthy  map[string]string    `json:"unhealthy"`
+	}{
+		BaseResponse: protocol.BaseResponse{Code: protocol.CodeOk},
+		Supervised:   app.mvc.Modules().Supervised(),
+		Unhealthy:    app.mvc.Modules().Unhealthy(),
+	})
+}
@@ -2,18 +2,42 @@ package internal
 
 import (
 	"context"
-	_ "espresso/modules" // 注册全部模块到mvc
+	"errors"
+	"espresso/pkg/admin"
+	"espresso/pkg/config"
 	"espresso/pkg/ctxhelper"
+	"espresso/pkg/election"
+	"espresso/pkg/events"
 	"espresso/pkg/gosafe"
+	"espresso/pkg/logging"
+	"espresso/pkg/metricpush"
+	"espresso/pkg/modules"
 	"espresso/pkg/mvc"
 	"espresso/pkg/network"
+	"espresso/pkg/panics"
+	"espresso/pkg/profiler"
+	"espresso/pkg/requestid"
 	"espresso/pkg/runtimestat"
+	"espresso/pkg/tasks"
+	"espresso/pkg/tracing"
+	"fmt"
 	"github.com/dan-and-dna/minilog"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"net/http"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+)
+
+var (
+	ErrorUnknownTraceExporter      = errors.New("unknown trace exporter")
+	ErrorUnknownRequestIdGenerator = errors.New("unknown request id generator")
+	ErrorAdminRequired             = errors.New("admin address required")
 )
 
 type Plugin = mvc.Plugin
@@ -23,12 +47,41 @@ type options struct {
 	logger             *minilog.MiniLog
 	registry           *prometheus.Registry
 	httpAddress        string
+	inProcess          bool
 	runtimeStatAddress string
+	adminAddress       string
+	adminOptions       []admin.Option
 	enablePProf        bool
 	app                *App
 	injects            map[string]any
 	moduleContext      []func(ctx context.Context) context.Context
 	modulePlugins      []Plugin
+	electionKey        string
+	electionLocker     election.Locker
+	enableCronAdmin    bool
+	enableLogAdmin     bool
+	logLevels          map[string]string
+	enableModules      []string
+	disableModules     []string
+	accessLog          *network.AccessLogConfig
+	tracingOptions     []tracing.Option
+	enableTracing      bool
+	metricPushOptions  []metricpush.Option
+	profilerOptions    []profiler.Option
+	panicReporters     []panics.Reporter
+	panicInterval      time.Duration
+	requestIdGenerator requestid.Generator
+	trustRequestId     bool
+	taskOptions        []tasks.Option
+	shutdown           ShutdownConfig
+	onStart            []func(ctx context.Context) error
+	onReady            []func(ctx context.Context) error
+	onStop             []func(ctx context.Context) error
+	config             *config.Config
+	configWatch        time.Duration
+
+	// 配置错误，Run时返回
+	err error
 }
 
 type Option func(opts *options)
@@ -51,6 +104,13 @@ func Http(httpAddress string) Option {
 	}
 }
 
+// InProcess 只创建http处理，不监听端口，覆盖 Http 的地址，用 App.Handler 在进程内调用，比如压测
+func InProcess() Option {
+	return func(opts *options) {
+		opts.inProcess = true
+	}
+}
+
 func Grpc(grpcAddress string) Option {
 	return func(opts *options) {
 		// TODO
@@ -81,22 +141,356 @@ func ModulePlugin(plugin Plugin) Option {
 	}
 }
 
+func Election(key string, locker election.Locker) Option {
+	return func(opts *options) {
+		opts.electionKey = key
+		opts.electionLocker = locker
+	}
+}
+
+// CronAdmin 定时任务管理接口，会修改状态，只挂在管理端口，需要同时设置 Admin
+func CronAdmin(enable bool) Option {
+	return func(opts *options) {
+		opts.enableCronAdmin = enable
+	}
+}
+
+// LogAdmin 日志级别管理接口，会修改状态，只挂在管理端口，需要同时设置 Admin
+func LogAdmin(enable bool) Option {
+	return func(opts *options) {
+		opts.enableLogAdmin = enable
+	}
+}
+
+// LogLevel 设置模块的日志级别，请求日志按模块过滤
+func LogLevel(module, level string) Option {
+	return func(opts *options) {
+		if opts.logLevels == nil {
+			opts.logLevels = make(map[string]string)
+		}
+		opts.logLevels[module] = level
+	}
+}
+
+// Modules 只安装这些注册的模块，不设置则安装全部
+func Modules(uids ...string) Option {
+	return func(opts *options) {
+		opts.enableModules = append(opts.enableModules, uids...)
+	}
+}
+
+// DisableModules 不安装这些注册的模块，同一个程序按部署环境关掉某些功能
+func DisableModules(uids ...string) Option {
+	return func(opts *options) {
+		opts.disableModules = append(opts.disableModules, uids...)
+	}
+}
+
+// AccessLog 访问日志
+func AccessLog(config network.AccessLogConfig) Option {
+	return func(opts *options) {
+		opts.accessLog = &config
+	}
+}
+
+// RequestId 请求id生成器，trustInbound为true时沿用上游传过来的X-Request-Id
+func RequestId(generator requestid.Generator, trustInbound bool) Option {
+	return func(opts *options) {
+		opts.requestIdGenerator = generator
+		opts.trustRequestId = trustInbound
+	}
+}
+
+// Tracing 开启链路追踪
+func Tracing(tracingOptions ...tracing.Option) Option {
+	return func(opts *options) {
+		opts.enableTracing = true
+		opts.tracingOptions = append(opts.tracingOptions, tracingOptions...)
+	}
+}
+
+// MetricPush 定时把metric推送到Pushgateway或者remote write，需要打开metric
+func MetricPush(pushOptions ...metricpush.Option) Option {
+	return func(opts *options) {
+		opts.metricPushOptions = append(opts.metricPushOptions, pushOptions...)
+	}
+}
+
+// Profiler 持续采集cpu、heap、goroutine和mutex，慢调用、崩溃或者超过阈值时马上采集一次
+func Profiler(profilerOptions ...profiler.Option) Option {
+	return func(opts *options) {
+		opts.profilerOptions = append(opts.profilerOptions, profilerOptions...)
+	}
+}
+
+// PanicReporter 崩溃上报到sentry类的http服务、webhook或者本地文件，同一分组按间隔上报
+func PanicReporter(reporters ...panics.Reporter) Option {
+	return func(opts *options) {
+		opts.panicReporters = append(opts.panicReporters, reporters...)
+	}
+}
+
+// PanicReportInterval 同一分组上报的最短间隔，第一次总是上报，默认1分钟
+func PanicReportInterval(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.panicInterval = interval
+	}
+}
+
+func Tasks(taskOptions ...tasks.Option) Option {
+	return func(opts *options) {
+		opts.taskOptions = append(opts.taskOptions, taskOptions...)
+	}
+}
+
+// Config 使用配置，配置里的app部分相当于对应的Option，之后的Option可以覆盖；app.log只在没有用 Logger 指定日志时生效；模块配置在安装模块时按uid解析
+func Config(cfg *config.Config) Option {
+	return func(opts *options) {
+		if cfg == nil {
+			return
+		}
+		opts.config = cfg
+
+		app := cfg.App
+		if app.Http != "" {
+			opts.httpAddress = app.Http
+		}
+		if app.RuntimeStat != "" {
+			opts.runtimeStatAddress = app.RuntimeStat
+		}
+		if app.PProf {
+			opts.enablePProf = true
+		}
+		if app.CronAdmin {
+			opts.enableCronAdmin = true
+		}
+		if app.LogAdmin {
+			opts.enableLogAdmin = true
+		}
+		for module, level := range app.Log.Levels {
+			LogLevel(module, level)(opts)
+		}
+		if len(app.Modules) != 0 {
+			Modules(app.Modules...)(opts)
+		}
+		if len(app.DisableModules) != 0 {
+			DisableModules(app.DisableModules...)(opts)
+		}
+		if app.AccessLog.Enable {
+			accessLog := network.AccessLogConfig{
+				SampleRate:  app.AccessLog.SampleRate,
+				SlowTime:    app.AccessLog.SlowTime,
+				CaptureBody: app.AccessLog.CaptureBody,
+				MaxBodySize: app.AccessLog.MaxBodySize,
+				Redact:      app.AccessLog.Redact,
+			}
+			if app.AccessLog.Filename != "" {
+				accessLog.Logger = network.NewAccessLogger(app.AccessLog.Filename, app.Log.MaxSize, app.Log.MaxBackups, app.Log.MaxAge)
+			}
+			AccessLog(accessLog)(opts)
+		}
+		if app.RequestId.Generator != "" || app.RequestId.IgnoreInbound {
+			var generator requestid.Generator
+			switch app.RequestId.Generator {
+			case "", "ulid":
+				generator = requestid.ULID()
+			case "uuidv7":
+				generator = requestid.UUIDv7()
+			case "snowflake":
+				generator = requestid.Snowflake(app.RequestId.Node)
+			default:
+				opts.err = fmt.Errorf("%w: %s", ErrorUnknownRequestIdGenerator, app.RequestId.Generator)
+				return
+			}
+			RequestId(generator, !app.RequestId.IgnoreInbound)(opts)
+		}
+		if app.Trace.Exporter != "" {
+			exporter, err := traceExporter(app.Trace)
+			if err != nil {
+				opts.err = fmt.Errorf("trace exporter %s: %w", app.Trace.Exporter, err)
+				return
+			}
+
+			tracingOptions := []tracing.Option{tracing.WithExporter(exporter)}
+			if app.Trace.ServiceName != "" {
+				tracingOptions = append(tracingOptions, tracing.ServiceName(app.Trace.ServiceName))
+			}
+			if app.Trace.SampleRatio > 0 {
+				tracingOptions = append(tracingOptions, tracing.SampleRatio(app.Trace.SampleRatio))
+			}
+			Tracing(tracingOptions...)(opts)
+		}
+		if app.Admin.Address != "" {
+			var adminOptions []admin.Option
+			if app.Admin.Username != "" {
+				adminOptions = append(adminOptions, admin.BasicAuth(app.Admin.Username, app.Admin.Password))
+			}
+			if app.Admin.Token != "" {
+				adminOptions = append(adminOptions, admin.Token(app.Admin.Token))
+			}
+			if len(app.Admin.AllowIPs) != 0 {
+				adminOptions = append(adminOptions, admin.AllowIPs(app.Admin.AllowIPs...))
+			}
+			Admin(app.Admin.Address, adminOptions...)(opts)
+		}
+		if push := app.MetricPush; push.Pushgateway != "" || push.RemoteWrite != "" {
+			pushOptions := []metricpush.Option{
+				metricpush.Pushgateway(push.Pushgateway),
+				metricpush.RemoteWrite(push.RemoteWrite),
+			}
+			if push.Job != "" {
+				pushOptions = append(pushOptions, metricpush.Job(push.Job))
+			}
+			if push.Instance != "" {
+				pushOptions = append(pushOptions, metricpush.Instance(push.Instance))
+			}
+			if push.Interval > 0 {
+				pushOptions = append(pushOptions, metricpush.Interval(push.Interval))
+			}
+			MetricPush(pushOptions...)(opts)
+		}
+		if prof := app.Profiler; prof.Dir != "" || prof.Pyroscope != "" {
+			var profilerOptions []profiler.Option
+			if prof.Dir != "" {
+				profilerOptions = append(profilerOptions, profiler.Dir(prof.Dir, prof.MaxFiles))
+			}
+			if prof.Pyroscope != "" {
+				appName := prof.AppName
+				if appName == "" {
+					appName = "espresso"
+				}
+				profilerOptions = append(profilerOptions, profiler.Pyroscope(prof.Pyroscope, appName, prof.Tags))
+			}
+			if len(prof.Profiles) != 0 {
+				profilerOptions = append(profilerOptions, profiler.Profiles(prof.Profiles...))
+			}
+			if prof.Interval != 0 {
+				profilerOptions = append(profilerOptions, profiler.Interval(prof.Interval))
+			}
+			if prof.CPUDuration > 0 {
+				profilerOptions = append(profilerOptions, profiler.CPUDuration(prof.CPUDuration))
+			}
+			if prof.Cooldown > 0 {
+				profilerOptions = append(profilerOptions, profiler.Cooldown(prof.Cooldown))
+			}
+			profilerOptions = append(profilerOptions,
+				profiler.HeapThreshold(prof.HeapThreshold),
+				profiler.GoroutineThreshold(prof.GoroutineThreshold),
+			)
+			Profiler(profilerOptions...)(opts)
+		}
+		if report := app.Panic; report.Http != "" || report.Webhook != "" || report.File != "" {
+			var reporters []panics.Reporter
+			if report.Http != "" {
+				reporters = append(reporters, panics.HttpReporter(report.Http, report.Headers))
+			}
+			if report.Webhook != "" {
+				reporters = append(reporters, panics.WebhookReporter(report.Webhook))
+			}
+			if report.File != "" {
+				reporters = append(reporters, panics.FileReporter(report.File))
+			}
+			PanicReporter(reporters...)(opts)
+		}
+		if app.Panic.Interval > 0 {
+			PanicReportInterval(app.Panic.Interval)(opts)
+		}
+		if app.Metric && opts.registry == nil {
+			opts.registry = prometheus.NewRegistry()
+		}
+		// 已经用 Logger 指定了日志则不替换，之后的 Logger 也会覆盖配置的
+		if opts.logger == nil && (app.Log.Environment != "" || app.Log.Filename != "") {
+			opts.logger = minilog.New(minilog.Config{
+				Environment: app.Log.Environment,
+				Filename:    app.Log.Filename,
+				MaxSize:     app.Log.MaxSize,
+				MaxBackups:  app.Log.MaxBackups,
+				MaxAge:      app.Log.MaxAge,
+			})
+		}
+	}
+}
+
+// ConfigWatch 每隔interval轮询重新加载配置，不监听文件变化，模块配置变化时通知模块，app部分变化只打印警告，需要先设置 Config
+func ConfigWatch(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.configWatch = interval
+	}
+}
+
+// traceExporter 根据配置创建span导出
+func traceExporter(trace config.TraceConfig) (tracing.Exporter, error) {
+	switch trace.Exporter {
+	case "otlp":
+		return tracing.OTLPExporter(context.Background(), trace.Endpoint, trace.Insecure)
+	case "stdout":
+		return tracing.StdoutExporter()
+	case "file":
+		return tracing.FileExporter(trace.File)
+	}
+
+	return nil, ErrorUnknownTraceExporter
+}
+
+// OnStart 模块初始化后、开始监听前调用，返回错误则退出
+func OnStart(hook func(ctx context.Context) error) Option {
+	return func(opts *options) {
+		opts.onStart = append(opts.onStart, hook)
+	}
+}
+
+// OnReady 全部服务启动、标记就绪后调用，返回错误则退出
+func OnReady(hook func(ctx context.Context) error) Option {
+	return func(opts *options) {
+		opts.onReady = append(opts.onReady, hook)
+	}
+}
+
+// OnStop 优雅退出的最后阶段调用，模块已经退出
+func OnStop(hook func(ctx context.Context) error) Option {
+	return func(opts *options) {
+		opts.onStop = append(opts.onStop, hook)
+	}
+}
+
 type App struct {
 	opts *options
 
-	network     *network.Network
-	runtimeStat *runtimestat.RuntimeStat
-	registry    *prometheus.Registry
-	logger      *minilog.MiniLog
-	ctx         context.Context
+	mvc          *mvc.Mvc // 自己的模块、消息派发、事件、定时器和任务队列
+	network      *network.Network
+	runtimeStat  *runtimestat.RuntimeStat
+	admin        *admin.Admin
+	elector      *election.Elector
+	tracing      *tracing.Tracing
+	panics       *panics.Panics        // 自己的崩溃分组、钩子和上报
+	logLevels    *logging.ModuleLevels // 自己的模块日志级别
+	metricPusher *metricpush.Pusher
+	profiler     *profiler.Profiler
+	stopElection context.CancelFunc
+	registry     *prometheus.Registry
+	logger       *minilog.MiniLog
+	ctx          context.Context
+	config       atomic.Pointer[config.Config] // 当前生效的配置，热更新时替换
+
+	// 第一个致命错误
+	err     error
+	errOnce sync.Once
+
+	// 主动退出
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	done         chan struct{}
 }
 
 func New(applyOptions ...Option) *App {
 	app := &App{
-		ctx: context.Background(),
+		ctx:        context.Background(),
+		shutdownCh: make(chan struct{}),
+		done:       make(chan struct{}),
 	}
 	opts := &options{
-		app: app,
+		app:      app,
+		shutdown: defaultShutdownConfig,
 	}
 	app.opts = opts
 
@@ -112,6 +506,17 @@ func New(applyOptions ...Option) *App {
 		app.ctx = moduleContext(app.ctx)
 	}
 
+	// 自己的mvc，从目录里挑选模块安装，多个App互不影响
+	app.mvc = mvc.New()
+	app.ctx = mvc.Inject(app.ctx, app.mvc)
+	installs, err := modules.Select(app.opts.enableModules, app.opts.disableModules)
+	if err != nil && app.opts.err == nil {
+		app.opts.err = err
+	}
+	for _, module := range installs {
+		app.mvc.Modules().Register(module)
+	}
+
 	// 日志
 	if app.opts.logger != nil {
 		app.logger = app.opts.logger
@@ -122,38 +527,188 @@ func New(applyOptions ...Option) *App {
 		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "logger"))
 	}
 
+	// 配置
+	if app.opts.config != nil {
+		app.config.Store(app.opts.config)
+		app.ctx = ctxhelper.InjectConfigSource(app.ctx, app.Config) // 注入给模块，热更新后拿到新配置
+
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "config"), zap.String("source", app.opts.config.Source()))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "config"))
+	}
+
+	// 挑选的模块
+	installed := make([]string, 0, len(installs))
+	for _, module := range installs {
+		installed = append(installed, module.ModuleUID())
+	}
+	selected := len(app.opts.enableModules) != 0 || len(app.opts.disableModules) != 0
+	app.logger.Info("set app option", zap.Bool("enable", selected), zap.String("option", "modules"), zap.Strings("installed", installed), zap.Strings("disabled", app.opts.disableModules))
+
+	// 模块日志级别，每个App一份
+	app.logLevels = logging.New()
+	app.ctx = logging.Inject(app.ctx, app.logLevels)
+	for module, level := range app.opts.logLevels {
+		if err := app.logLevels.Set(module, level); err != nil {
+			app.logger.Error("set log level", zap.String("module", module), zap.String("level", level), zap.Error(err))
+		}
+	}
+
 	// metric
 	if app.opts.registry != nil {
 		app.registry = app.opts.registry
 		app.ctx = ctxhelper.InjectRegistry(app.ctx, app.registry) // 注入给模块
 
+		// go运行时和进程的metric
+		if err := runtimestat.RegisterCollectors(app.registry); err != nil {
+			app.logger.Error("register runtime collectors", zap.Error(err))
+		}
+
+		// 协程组的metric
+		if err := gosafe.RegisterCollectors(app.registry); err != nil {
+			app.logger.Error("register gosafe collectors", zap.Error(err))
+		}
+
 		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "metric"))
 	} else {
 		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "metric"))
 	}
 
+	// 推送metric
+	if len(app.opts.metricPushOptions) != 0 && app.registry != nil {
+		app.metricPusher = metricpush.New(append([]metricpush.Option{metricpush.Gatherer(app.registry)}, app.opts.metricPushOptions...)...)
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "metric push"))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "metric push"))
+	}
+
+	// 链路追踪，不开启时span都是空操作
+	if app.opts.enableTracing {
+		app.tracing = tracing.New(app.opts.tracingOptions...)
+		app.ctx = tracing.InjectTracing(app.ctx, app.tracing)
+		tracing.SetPropagator()
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "tracing"))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "tracing"))
+	}
+
+	// 崩溃上报，每个App一份，每次崩溃都在后台发布事件，不会在事件处理里再发布
+	app.panics = panics.New()
+	app.panics.SetLogger(app.logger)
+	if app.opts.panicInterval > 0 {
+		app.panics.SetReportInterval(app.opts.panicInterval)
+	}
+	app.panics.AddReporter(app.opts.panicReporters...)
+	app.panics.OnPanic(func(p *panics.Panic) {
+		events.PublishPanic(app.ctx, p)
+	})
+	app.ctx = panics.Inject(app.ctx, app.panics)
+	if len(app.opts.panicReporters) != 0 {
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "panic report"), zap.Int("reporters", len(app.opts.panicReporters)))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "panic report"))
+	}
+
+	// 持续采集，慢调用和崩溃时马上采集一次
+	if len(app.opts.profilerOptions) != 0 {
+		app.profiler = profiler.New(app.opts.profilerOptions...)
+		_ = app.mvc.Subscribe(events.ModuleCallSlow, app.onSlowCall)
+		_ = app.mvc.Subscribe(events.Panic, app.onPanic)
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "profiler"))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "profiler"))
+	}
+
 	// 设置mvc插件
-	mvc.SetPlugins(opts.modulePlugins...)
+	app.mvc.SetPlugins(opts.modulePlugins...)
+
+	// 任务队列
+	if len(app.opts.taskOptions) != 0 {
+		app.mvc.SetTasks(app.opts.taskOptions...)
+	}
+
+	// 选举，单例定时任务只在leader上执行
+	if app.opts.electionLocker != nil {
+		app.elector = election.New(
+			election.Key(app.opts.electionKey),
+			election.WithLocker(app.opts.electionLocker),
+		)
+		app.mvc.SetElector(app.elector)
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "election"), zap.String("id", app.elector.Id()))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "election"))
+	}
 
 	// 网络
-	if app.opts.httpAddress != "" {
-		app.network = network.New(
-			network.PProf(app.opts.enablePProf),               // pprof
-			network.Logger(app.logger),                        // 日志
-			network.Http(app.opts.httpAddress, mvc.NewHttp()), // 启动http服务
-			network.Metric(app.registry),                      // metric
+	if app.opts.httpAddress != "" || app.opts.inProcess {
+		// 有管理端口时pprof、metric、健康检查和管理接口都挂在管理端口
+		onAdmin := app.opts.adminAddress != ""
+		httpAddress := app.opts.httpAddress
+		if app.opts.inProcess {
+			httpAddress = "" // 不监听
+		}
+		networkOptions := []network.Option{
+			network.PProf(app.opts.enablePProf && !onAdmin),    // pprof
+			network.Logger(app.logger),                         // 日志
+			network.Http(httpAddress, app.mvc.NewHttp()),       // 启动http服务
+			network.Metric(app.registry),                       // metric
+			network.OpsRoutes(!onAdmin),                        // metric和健康检查
+			network.ReadyCheck(app.modulesHealthy),             // 模块不健康时未就绪
+			network.HealthModules(app.mvc.Modules().Installed), // 健康检查带上安装的模块
+			network.RequestContext(app.requestContext),         // 请求里的事件发到自己的mvc
 			network.ModuleContext(app.opts.moduleContext...),
-		)
+		}
+		if app.opts.accessLog != nil {
+			networkOptions = append(networkOptions, network.AccessLog(*app.opts.accessLog)) // 访问日志
+		}
+		if app.opts.requestIdGenerator != nil {
+			networkOptions = append(networkOptions, network.RequestId(app.opts.requestIdGenerator, app.opts.trustRequestId)) // 请求id
+		}
+
+		app.network = network.New(networkOptions...)
 		if app.opts.enablePProf {
 			app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "pprof"))
 		} else {
 			app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "pprof"))
 		}
+		if app.opts.enableCronAdmin {
+			app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "cron admin"))
+		} else {
+			app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "cron admin"))
+		}
+		if app.opts.enableLogAdmin {
+			app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "log admin"))
+		} else {
+			app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "log admin"))
+		}
+		if app.opts.accessLog != nil {
+			app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "access log"))
+		} else {
+			app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "access log"))
+		}
 		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "network"))
 	} else {
 		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "network"))
 	}
 
+	// 会修改状态的管理接口不挂在公开端口上
+	if (app.opts.enableCronAdmin || app.opts.enableLogAdmin) && app.opts.adminAddress == "" && app.opts.err == nil {
+		app.opts.err = fmt.Errorf("%w: cron admin and log admin only serve on the admin port", ErrorAdminRequired)
+	}
+
+	// 管理端口
+	if app.opts.adminAddress != "" {
+		adminOptions := []admin.Option{
+			admin.Address(app.opts.adminAddress),
+			admin.Logger(app.logger),
+			admin.Routes(app.adminRoutes()...),
+		}
+		app.admin = admin.New(append(adminOptions, app.opts.adminOptions...)...)
+		app.logger.Info("set app option", zap.Bool("enable", true), zap.String("option", "admin"), zap.String("address", app.opts.adminAddress))
+	} else {
+		app.logger.Info("set app option", zap.Bool("enable", false), zap.String("option", "admin"))
+	}
+
 	// 运行时统计
 	if app.opts.runtimeStatAddress != "" {
 		app.runtimeStat = runtimestat.New(
@@ -167,29 +722,114 @@ func New(applyOptions ...Option) *App {
 	return app
 }
 
+// Run 运行直到收到退出信号、调用Shutdown或者某个服务失败，返回第一个致命错误
 func (app *App) Run() error {
 	if app == nil || app.opts == nil {
 		return nil
 	}
+	defer close(app.done)
 	defer app.logger.Close()
 
+	// 配置错误
+	if app.opts.err != nil {
+		app.logger.Error("app option fail", zap.Error(app.opts.err))
+		return app.opts.err
+	}
+
 	// 监听关闭信号
 	ctx := app.ctx
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// 任意服务失败都取消其他服务
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fail := func(err error) {
+		app.errOnce.Do(func() {
+			app.err = err
+		})
+		cancel()
+	}
+
 	var wait sync.WaitGroup
+	if err := app.start(ctx, &wait, fail); err != nil {
+		fail(err)
+	}
+
+	select {
+	case <-ctx.Done():
+		if app.err != nil {
+			app.logger.Error("service fail, stopping....", zap.Error(app.err))
+		} else {
+			app.logger.Warn("receive stop signal....")
+		}
+	case <-app.shutdownCh:
+		app.logger.Warn("receive shutdown....")
+	}
+	stop()
+
+	// 优雅退出
+	app.shutdown()
+
+	wait.Wait()
+	cancel()
+	app.logger.Info("bye~ :)")
+
+	return app.err
+}
+
+// Config 当前生效的配置，热更新接受后是新的配置；没有设置 Config 时为nil
+func (app *App) Config() *config.Config {
+	return app.config.Load()
+}
+
+// Handler http端口的处理，进程内压测或者测试时不走网络直接调用；没有http服务时为nil，OnReady之后模块才初始化好
+func (app *App) Handler() http.Handler {
+	if app.network == nil {
+		return nil
+	}
+	return app.network.Handler()
+}
+
+// Shutdown 主动优雅退出，等待Run返回或者ctx超时
+func (app *App) Shutdown(ctx context.Context) error {
+	if app == nil || app.opts == nil {
+		return nil
+	}
+
+	app.shutdownOnce.Do(func() {
+		close(app.shutdownCh)
+	})
+
+	select {
+	case <-app.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start 初始化模块，启动全部服务
+func (app *App) start(ctx context.Context, wait *sync.WaitGroup, fail func(error)) error {
+	// 初始化模块，启动任务队列和定时器
+	app.mvc.Run(ctx)
+
+	for _, hook := range app.opts.onStart {
+		if err := hook(ctx); err != nil {
+			app.logger.Error("run start hook fail", zap.Error(err))
+			return err
+		}
+	}
 
 	// 启动网络
 	if app.network != nil {
 		wait.Add(1)
 		gosafe.GoSafe(ctx,
 			func(ctx context.Context) {
-				// 运行mvc
-				mvc.Run(ctx)
 				// 运行网络
 				if err := app.network.Run(ctx); err != nil {
 					app.logger.Error("run network fail", zap.Error(err))
+					fail(err)
 					return
 				}
 			},
@@ -197,17 +837,38 @@ func (app *App) Run() error {
 				defer wait.Done()
 				if err != nil {
 					app.logger.Error("stop network fail", zap.Error(err))
+					fail(err)
 					return
 				}
 
 				app.logger.Info("stop network success")
-			},
-			func(ctx context.Context) {
-				// 暂停mvc
-				mvc.Stop(ctx)
 			})
 	}
 
+	// 启动管理端口
+	if app.admin != nil {
+		wait.Add(1)
+		gosafe.GoSafe(ctx,
+			func(ctx context.Context) {
+				if err := app.admin.Run(ctx); err != nil {
+					app.logger.Error("run admin fail", zap.Error(err))
+					fail(err)
+					return
+				}
+			},
+			func(ctx context.Context, err error) {
+				defer wait.Done()
+				if err != nil {
+					app.logger.Error("stop admin fail", zap.Error(err))
+					fail(err)
+					return
+				}
+
+				app.logger.Info("stop admin success")
+			},
+		)
+	}
+
 	// 启动运行时统计
 	if app.runtimeStat != nil {
 		wait.Add(1)
@@ -215,7 +876,8 @@ func (app *App) Run() error {
 			func(ctx context.Context) {
 				// 运行时统计
 				if err := app.runtimeStat.Run(ctx); err != nil {
-					app.logger.Info("run runtime stat fail", zap.Error(err))
+					app.logger.Error("run runtime stat fail", zap.Error(err))
+					fail(err)
 					return
 				}
 			},
@@ -223,20 +885,182 @@ func (app *App) Run() error {
 				defer wait.Done()
 				if err != nil {
 					app.logger.Error("stop runtime stat fail", zap.Error(err))
+					fail(err)
 					return
 				}
 
 				app.logger.Info("stop runtime stat success")
 			},
 		)
+	}
 
+	// 推送metric
+	if app.metricPusher != nil {
+		wait.Add(1)
+		gosafe.GoSafe(ctx,
+			func(ctx context.Context) {
+				if err := app.metricPusher.Run(ctx); err != nil {
+					app.logger.Error("run metric pusher fail", zap.Error(err))
+					fail(err)
+					return
+				}
+			},
+			func(ctx context.Context, err error) {
+				defer wait.Done()
+				if err != nil {
+					app.logger.Error("stop metric pusher fail", zap.Error(err))
+					fail(err)
+					return
+				}
+
+				app.logger.Info("stop metric pusher success")
+			},
+		)
 	}
 
-	wait.Wait()
-	<-ctx.Done()
-	app.logger.Warn("receive stop signal....")
-	stop()
-	app.logger.Info("bye~ :)")
+	// 持续采集
+	if app.profiler != nil {
+		wait.Add(1)
+		gosafe.GoSafe(ctx,
+			func(ctx context.Context) {
+				if err := app.profiler.Run(ctx); err != nil {
+					app.logger.Error("run profiler fail", zap.Error(err))
+					fail(err)
+					return
+				}
+			},
+			func(ctx context.Context, err error) {
+				defer wait.Done()
+				if err != nil {
+					app.logger.Error("stop profiler fail", zap.Error(err))
+					fail(err)
+					return
+				}
+
+				app.logger.Info("stop profiler success")
+			},
+		)
+	}
+
+	// 参与选举，定时任务停止后才退出
+	if app.elector != nil {
+		var electionCtx context.Context
+		electionCtx, app.stopElection = context.WithCancel(app.ctx)
+
+		wait.Add(1)
+		gosafe.GoSafe(electionCtx,
+			func(ctx context.Context) {
+				if err := app.elector.Run(ctx); err != nil {
+					app.logger.Error("run election fail", zap.Error(err))
+					fail(err)
+					return
+				}
+			},
+			func(ctx context.Context, err error) {
+				defer wait.Done()
+				if err != nil {
+					app.logger.Error("stop election fail", zap.Error(err))
+					fail(err)
+					return
+				}
+
+				app.logger.Info("stop election success")
+			},
+		)
+	}
+
+	// 配置热更新
+	if app.opts.config != nil && app.opts.configWatch > 0 {
+		wait.Add(1)
+		gosafe.GoSafe(ctx,
+			func(ctx context.Context) {
+				if err := config.Watch(ctx, app.logger, app.opts.config, app.opts.configWatch, app.reconfigure); err != nil {
+					app.logger.Error("run config watcher fail", zap.Error(err))
+					return
+				}
+			},
+			func(ctx context.Context, err error) {
+				defer wait.Done()
+				if err != nil {
+					app.logger.Error("stop config watcher fail", zap.Error(err))
+					return
+				}
+
+				app.logger.Info("stop config watcher success")
+			},
+		)
+	}
+
+	// 就绪
+	if app.network != nil {
+		app.network.SetReady(true)
+	}
+
+	for _, hook := range app.opts.onReady {
+		if err := hook(ctx); err != nil {
+			app.logger.Error("run ready hook fail", zap.Error(err))
+			return err
+		}
+	}
 
 	return nil
 }
+
+// reconfigure 配置变化时通知模块，全部模块接受后才发布事件
+func (app *App) reconfigure(old, new *config.Config) error {
+	changes, err := app.mvc.Modules().Reconfigure(app.ctx, old, new)
+	if err != nil {
+		return err
+	}
+
+	app.config.Store(new)
+	for _, change := range changes {
+		events.PublishModuleReconfigure(app.ctx, change.Module, change.Old, change.New)
+	}
+
+	return nil
+}
+
+// requestContext 请求最先注入自己的mvc，处理函数和请求里发布的事件都用它
+func (app *App) requestContext(ctx context.Context) context.Context {
+	ctx = mvc.Inject(ctx, app.mvc)
+	ctx = panics.Inject(ctx, app.panics)
+	ctx = logging.Inject(ctx, app.logLevels)
+	if app.opts.config != nil {
+		ctx = ctxhelper.InjectConfigSource(ctx, app.Config)
+	}
+	if app.tracing != nil {
+		ctx = tracing.InjectTracing(ctx, app.tracing)
+	}
+	return ctx
+}
+
+// modulesHealthy 被监督的循环重启太多次的模块不健康
+func (app *App) modulesHealthy() error {
+	unhealthy := app.mvc.Modules().Unhealthy()
+	if len(unhealthy) == 0 {
+		return nil
+	}
+
+	reasons := make([]string, 0, len(unhealthy))
+	for module, reason := range unhealthy {
+		reasons = append(reasons, module+": "+reason)
+	}
+	sort.Strings(reasons)
+
+	return fmt.Errorf("unhealthy modules: %s", strings.Join(reasons, "; "))
+}
+
+// onSlowCall 慢调用时采集
+func (app *App) onSlowCall(ctx context.Context, event events.EventModuleCallSlow) {
+	if app.profiler.Trigger("slow-" + event.Module + "-" + event.Function) {
+		ctxhelper.FetchLogger(ctx).Warn("capture profiles on slow call", zap.Duration("cost", time.Duration(event.CostTime)))
+	}
+}
+
+// onPanic 任意地方崩溃时采集
+func (app *App) onPanic(ctx context.Context, event events.EventPanic) {
+	if app.profiler.Trigger("panic-" + event.Panic.Fingerprint) {
+		ctxhelper.FetchLogger(ctx).Warn("capture profiles on panic", zap.String("source", event.Panic.Source), zap.Error(event.Panic))
+	}
+}
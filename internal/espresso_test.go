@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"espresso/pkg/config"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestConfigKeepsLogger(t *testing.T) {
+	cfg := &config.Config{App: config.AppConfig{Log: config.LogConfig{Environment: "production"}}}
+	logger := &minilog.MiniLog{Logger: zap.NewNop()}
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    func(got *minilog.MiniLog) bool
+	}{
+		{"logger then config", []Option{Logger(logger), Config(cfg)}, func(got *minilog.MiniLog) bool { return got == logger }},
+		{"config then logger", []Option{Config(cfg), Logger(logger)}, func(got *minilog.MiniLog) bool { return got == logger }},
+		{"config only", []Option{Config(cfg)}, func(got *minilog.MiniLog) bool { return got != nil && got != logger }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &options{}
+			for _, applyOption := range test.options {
+				applyOption(opts)
+			}
+			if !test.want(opts.logger) {
+				t.Fatalf("logger = %p", opts.logger)
+			}
+		})
+	}
+}
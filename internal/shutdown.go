@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/panics"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ShutdownConfig 优雅退出各阶段的时间
+type ShutdownConfig struct {
+	Readiness time.Duration // 标记未就绪
+	Grace     time.Duration // 标记未就绪后等待负载均衡摘掉本实例
+	Network   time.Duration // 等待进行中的请求完成
+	Timer     time.Duration // 等待进行中的定时任务完成
+	Tasks     time.Duration // 处理完已经到期的任务，超时取消处理函数的ctx
+	Modules   time.Duration // 模块退出和清理
+	Tracing   time.Duration // 导出剩下的span
+	Metric    time.Duration // 推送最后的metric
+	Panic     time.Duration // 上报剩下的崩溃
+	Hooks     time.Duration // OnStop钩子
+}
+
+var defaultShutdownConfig = ShutdownConfig{
+	Readiness: time.Second,
+	Grace:     0,
+	Network:   5 * time.Second,
+	Timer:     10 * time.Second,
+	Tasks:     10 * time.Second,
+	Modules:   10 * time.Second,
+	Tracing:   5 * time.Second,
+	Metric:    5 * time.Second,
+	Panic:     5 * time.Second,
+	Hooks:     10 * time.Second,
+}
+
+// GracefulShutdown 优雅退出配置，没有设置的阶段使用默认值
+func GracefulShutdown(config ShutdownConfig) Option {
+	return func(opts *options) {
+		if config.Readiness > 0 {
+			opts.shutdown.Readiness = config.Readiness
+		}
+		if config.Grace > 0 {
+			opts.shutdown.Grace = config.Grace
+		}
+		if config.Network > 0 {
+			opts.shutdown.Network = config.Network
+		}
+		if config.Timer > 0 {
+			opts.shutdown.Timer = config.Timer
+		}
+		if config.Tasks > 0 {
+			opts.shutdown.Tasks = config.Tasks
+		}
+		if config.Modules > 0 {
+			opts.shutdown.Modules = config.Modules
+		}
+		if config.Tracing > 0 {
+			opts.shutdown.Tracing = config.Tracing
+		}
+		if config.Metric > 0 {
+			opts.shutdown.Metric = config.Metric
+		}
+		if config.Panic > 0 {
+			opts.shutdown.Panic = config.Panic
+		}
+		if config.Hooks > 0 {
+			opts.shutdown.Hooks = config.Hooks
+		}
+	}
+}
+
+// shutdown 按顺序退出：标记未就绪 -> 等待负载均衡 -> 停止网络 -> 停止定时任务 -> 停止任务队列 -> 模块退出 -> 导出span -> 推送metric -> 上报崩溃 -> OnStop钩子
+func (app *App) shutdown() {
+	config := app.opts.shutdown
+	startTime := time.Now()
+	app.logger.Warn("shutdown begin")
+
+	// 不再就绪，负载均衡不再转发新请求
+	app.shutdownPhase("readiness", config.Readiness, func(ctx context.Context) error {
+		if app.network != nil {
+			app.network.SetReady(false)
+		}
+		return nil
+	})
+
+	// 等待负载均衡感知
+	if config.Grace > 0 {
+		app.shutdownPhase("grace", config.Grace+time.Second, func(ctx context.Context) error {
+			time.Sleep(config.Grace)
+			return nil
+		})
+	}
+
+	// 不再接收新请求，等待进行中的请求完成
+	app.shutdownPhase("network", config.Network, func(ctx context.Context) error {
+		var err error
+		if app.network != nil {
+			err = app.network.Shutdown(ctx)
+		}
+		if app.runtimeStat != nil {
+			if statErr := app.runtimeStat.Shutdown(ctx); err == nil {
+				err = statErr
+			}
+		}
+		if app.admin != nil {
+			if adminErr := app.admin.Shutdown(ctx); err == nil {
+				err = adminErr
+			}
+		}
+		return err
+	})
+
+	// 不再触发定时任务
+	app.shutdownPhase("timer", config.Timer, app.mvc.StopTimer)
+
+	// 处理完已经到期的任务
+	app.shutdownPhase("tasks", config.Tasks, app.mvc.StopTasks)
+
+	// 定时任务都停了，可以让出leader
+	if app.stopElection != nil {
+		app.stopElection()
+	}
+
+	// 模块按依赖顺序退出
+	app.shutdownPhase("modules", config.Modules, func(ctx context.Context) error {
+		app.mvc.Exit(ctx)
+		return nil
+	})
+
+	// 导出剩下的span
+	if app.tracing != nil {
+		app.shutdownPhase("tracing", config.Tracing, app.tracing.Shutdown)
+	}
+
+	// 推送最后的metric
+	if app.metricPusher != nil {
+		app.shutdownPhase("metric push", config.Metric, app.metricPusher.Push)
+	}
+
+	// 上报剩下的崩溃
+	app.shutdownPhase("panic report", config.Panic, func(ctx context.Context) error {
+		defer app.panics.Close()
+		return app.panics.Flush(ctx)
+	})
+
+	// 退出钩子
+	if len(app.opts.onStop) != 0 {
+		app.shutdownPhase("hooks", config.Hooks, func(ctx context.Context) error {
+			var err error
+			for _, hook := range app.opts.onStop {
+				if hookErr := hook(ctx); hookErr != nil && err == nil {
+					err = hookErr
+				}
+			}
+			return err
+		})
+	}
+
+	app.logger.Warn("shutdown end", zap.Duration("cost", time.Since(startTime)))
+}
+
+// shutdownPhase 执行一个退出阶段，超时后不再等待，继续下一个阶段
+func (app *App) shutdownPhase(phase string, timeout time.Duration, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(app.ctx, timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	app.logger.Info("shutdown phase", zap.String("phase", phase), zap.Duration("timeout", timeout))
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("shutdown phase panic: %w", app.panics.Capture(panics.SourceShutdown, r, "", phase))
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			app.logger.Error("shutdown phase", zap.String("phase", phase), zap.Bool("result", false), zap.Duration("cost", time.Since(startTime)), zap.Error(err))
+			return
+		}
+		app.logger.Info("shutdown phase", zap.String("phase", phase), zap.Bool("result", true), zap.Duration("cost", time.Since(startTime)))
+	case <-ctx.Done():
+		app.logger.Error("shutdown phase", zap.String("phase", phase), zap.Bool("result", false), zap.Duration("cost", time.Since(startTime)), zap.Error(ctx.Err()))
+	}
+}
@@ -0,0 +1,24 @@
+package internal
+
+import "time"
+
+// Config 模块配置，对应配置文件里 modules.metric_v0.1.0_core
+type Config struct {
+	SlowTime        time.Duration            `yaml:"slowTime" comment:"慢调用的耗时，默认3s"`
+	SlowTimes       map[string]time.Duration `yaml:"slowTimes" comment:"按模块或者消息设置慢调用的耗时，比如 say: 1s、say::slow: 5s，消息优先"`
+	Buckets         []float64                `yaml:"buckets" comment:"耗时直方图的桶（秒），为空使用prometheus默认值，修改后重启生效"`
+	NativeHistogram bool                     `yaml:"nativeHistogram" comment:"同时使用prometheus native histogram，修改后重启生效"`
+}
+
+// slowTimeOf 模块消息的慢调用耗时
+func (config *Config) slowTimeOf(module, function string) time.Duration {
+	if slowTime, ok := config.SlowTimes[module+"::"+function]; ok {
+		return slowTime
+	}
+
+	if slowTime, ok := config.SlowTimes[module]; ok {
+		return slowTime
+	}
+
+	return config.SlowTime
+}
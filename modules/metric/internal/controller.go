@@ -6,58 +6,70 @@ import (
 	"espresso/pkg/events"
 	"espresso/pkg/mvc"
 	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
 )
 
 type Controller struct {
-	model *Model
+	model  *Model
+	config *atomic.Pointer[Config]
+	mvc    *mvc.Mvc
 }
 
-func (ctr *Controller) Init(model *Model) error {
+func (ctr *Controller) Init(ctx context.Context, model *Model, config *atomic.Pointer[Config]) error {
 	ctr.model = model
+	ctr.config = config
+	ctr.mvc = mvc.FromContext(ctx)
 
 	// 注册事件处理
-	_ = mvc.Subscribe(events.ModuleCall, ctr.OnModuleCall)
-	_ = mvc.Subscribe(events.ModuleCallPanic, ctr.OnModuleCallPanic)
+	_ = ctr.mvc.Subscribe(events.ModuleCallStart, ctr.OnModuleCallStart)
+	_ = ctr.mvc.Subscribe(events.ModuleCall, ctr.OnModuleCall)
+	_ = ctr.mvc.Subscribe(events.ModuleCallPanic, ctr.OnModuleCallPanic)
 
 	return nil
 }
 
 func (ctr *Controller) Clean() {
 	// 解绑事件处理
-	_ = mvc.UnSubscribe(events.ModuleCall, ctr.OnModuleCall)
-	_ = mvc.UnSubscribe(events.ModuleCallPanic, ctr.OnModuleCallPanic)
+	_ = ctr.mvc.UnSubscribe(events.ModuleCallStart, ctr.OnModuleCallStart)
+	_ = ctr.mvc.UnSubscribe(events.ModuleCall, ctr.OnModuleCall)
+	_ = ctr.mvc.UnSubscribe(events.ModuleCallPanic, ctr.OnModuleCallPanic)
 
 }
 
+func (ctr *Controller) OnModuleCallStart(ctx context.Context, event events.EventModuleCallStart) {
+	ctr.model.MetricCallStart(event.Module, event.Function)
+}
+
 func (ctr *Controller) OnModuleCall(ctx context.Context, event events.EventModuleCall) {
-	requestId := ctxhelper.FetchRequestId(ctx)
+	// 请求的日志已经带上requestId、模块、消息和traceId
 	logger := ctxhelper.FetchLogger(ctx)
+	cost := time.Duration(event.CostTime)
 
-	// 大于3秒，打印堆栈
-	if event.CostTime/1000000000 >= 3 {
-		t := ctxhelper.FetchTrace(ctx)
-		traceInfo := ""
-		if t != nil {
-			traceInfo = t.String()
-		}
-		logger.Warn("slow call",
-			zap.String("module", event.Module),
-			zap.String("function", event.Function),
-			zap.String("requestId", requestId),
-			zap.String("trace", traceInfo))
+	// 慢调用
+	if slowTime := ctr.config.Load().slowTimeOf(event.Module, event.Function); slowTime > 0 && cost >= slowTime {
+		logger.Warn("slow call", zap.Duration("cost", cost), zap.Duration("slowTime", slowTime))
 
 		ctr.model.MetricSlowCall(event.Module, event.Function)
+
+		// 事件处理里同步发布会死锁（EventBus发布时持有锁），gin.Context请求结束后会复用，换成带日志和mvc的新context
+		go events.PublishModuleCallSlow(mvc.Inject(ctxhelper.InjectLogger(context.Background(), logger), ctr.mvc), events.EventModuleCallSlow{
+			Module:   event.Module,
+			Function: event.Function,
+			CostTime: event.CostTime,
+			SlowTime: slowTime.Nanoseconds(),
+		})
 	}
 
 	// 调用统计次数
 	ctr.model.MetricCall(event.Module, event.Function)
+	ctr.model.MetricCallEnd(event.Module, event.Function, cost, event.Code, event.RequestSize, event.ResponseSize)
 }
 
 func (ctr *Controller) OnModuleCallPanic(ctx context.Context, event events.EventModuleCallPanic) {
-	requestId := ctxhelper.FetchRequestId(ctx)
 	logger := ctxhelper.FetchLogger(ctx)
 
-	logger.Error("module panic", zap.String("module", event.Module), zap.String("function", event.Function), zap.Error(event.Error), zap.String("requestId", requestId))
+	logger.Error("module panic", zap.Error(event.Error), zap.String("fingerprint", event.Fingerprint), zap.String("stack", event.Stack))
 
 	ctr.model.MetricPanicCall(event.Module, event.Function)
 }
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// grafana面板，只保留用到的字段
+type panel struct {
+	Id          int            `json:"id"`
+	Type        string         `json:"type"`
+	Title       string         `json:"title"`
+	Datasource  map[string]any `json:"datasource,omitempty"`
+	GridPos     gridPos        `json:"gridPos"`
+	Targets     []target       `json:"targets,omitempty"`
+	FieldConfig map[string]any `json:"fieldConfig,omitempty"`
+	Collapsed   bool           `json:"collapsed,omitempty"`
+	Panels      []panel        `json:"panels,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefId        string `json:"refId"`
+}
+
+// 按job和instance过滤，对应推送和抓取时的标签
+const selector = `job="$job",instance=~"$instance"`
+
+type dashboardPanel struct {
+	title   string
+	unit    string
+	targets []target
+}
+
+type dashboardRow struct {
+	title  string
+	panels []dashboardPanel
+}
+
+// dashboardRows 每行两个面板，查询里的 {} 和 ,} 替换成selector
+var dashboardRows = []dashboardRow{
+	{
+		title: "mvc",
+		panels: []dashboardPanel{
+			{"调用次数", "reqps", []target{
+				{Expr: `sum by (module, function) (rate(mvc_module_call{}[$__rate_interval]))`, LegendFormat: "{{module}}::{{function}}"},
+			}},
+			{"耗时 p99", "s", []target{
+				{Expr: `histogram_quantile(0.99, sum by (le, module, function) (rate(mvc_module_call_duration_seconds_bucket{}[$__rate_interval])))`, LegendFormat: "{{module}}::{{function}}"},
+			}},
+			{"耗时 p50 / p95", "s", []target{
+				{Expr: `histogram_quantile(0.5, sum by (le, module, function) (rate(mvc_module_call_duration_seconds_bucket{}[$__rate_interval])))`, LegendFormat: "p50 {{module}}::{{function}}"},
+				{Expr: `histogram_quantile(0.95, sum by (le, module, function) (rate(mvc_module_call_duration_seconds_bucket{}[$__rate_interval])))`, LegendFormat: "p95 {{module}}::{{function}}"},
+			}},
+			{"失败（按code）", "reqps", []target{
+				{Expr: `sum by (module, function, code) (rate(mvc_module_call_error{}[$__rate_interval]))`, LegendFormat: "{{module}}::{{function}} {{code}}"},
+			}},
+			{"慢调用", "short", []target{
+				{Expr: `sum by (module, function) (increase(mvc_slow_module_call{}[$__rate_interval]))`, LegendFormat: "{{module}}::{{function}}"},
+			}},
+			{"崩溃", "short", []target{
+				{Expr: `sum by (module, function) (increase(mvc_panic_module_panic_call{}[$__rate_interval]))`, LegendFormat: "{{module}}::{{function}}"},
+			}},
+			{"进行中的调用", "short", []target{
+				{Expr: `sum by (module, function) (mvc_module_call_in_flight{})`, LegendFormat: "{{module}}::{{function}}"},
+			}},
+			{"请求和回复大小", "bytes", []target{
+				{Expr: `sum by (module, function) (rate(mvc_module_call_request_size_bytes_sum{}[$__rate_interval])) / sum by (module, function) (rate(mvc_module_call_request_size_bytes_count{}[$__rate_interval]))`, LegendFormat: "request {{module}}::{{function}}"},
+				{Expr: `sum by (module, function) (rate(mvc_module_call_response_size_bytes_sum{}[$__rate_interval])) / sum by (module, function) (rate(mvc_module_call_response_size_bytes_count{}[$__rate_interval]))`, LegendFormat: "response {{module}}::{{function}}"},
+			}},
+		},
+	},
+	{
+		title: "go runtime",
+		panels: []dashboardPanel{
+			{"goroutine", "short", []target{
+				{Expr: `go_goroutines{}`, LegendFormat: "{{instance}}"},
+			}},
+			{"堆内存", "bytes", []target{
+				{Expr: `go_memstats_heap_alloc_bytes{}`, LegendFormat: "alloc {{instance}}"},
+				{Expr: `go_memstats_heap_inuse_bytes{}`, LegendFormat: "inuse {{instance}}"},
+				{Expr: `process_resident_memory_bytes{}`, LegendFormat: "rss {{instance}}"},
+			}},
+			{"gc暂停", "s", []target{
+				{Expr: `go_gc_duration_seconds{quantile="1",}`, LegendFormat: "max {{instance}}"},
+				{Expr: `rate(go_gc_duration_seconds_sum{}[$__rate_interval]) / rate(go_gc_duration_seconds_count{}[$__rate_interval])`, LegendFormat: "avg {{instance}}"},
+			}},
+			{"cpu", "percentunit", []target{
+				{Expr: `rate(process_cpu_seconds_total{}[$__rate_interval])`, LegendFormat: "{{instance}}"},
+			}},
+		},
+	},
+}
+
+// Dashboard 生成grafana面板json，覆盖mvc的调用、耗时、失败、慢调用、崩溃和go运行时，导入时选择prometheus数据源
+func Dashboard(title string) ([]byte, error) {
+	datasource := map[string]any{"type": "prometheus", "uid": "${datasource}"}
+
+	var panels []panel
+	id, y := 1, 0
+	for _, row := range dashboardRows {
+		panels = append(panels, panel{Id: id, Type: "row", Title: row.title, GridPos: gridPos{H: 1, W: 24, Y: y}})
+		id++
+		y++
+
+		for i, p := range row.panels {
+			targets := make([]target, 0, len(p.targets))
+			for j, t := range p.targets {
+				t.Expr = strings.ReplaceAll(t.Expr, "{}", "{"+selector+"}")
+				t.Expr = strings.ReplaceAll(t.Expr, ",}", ","+selector+"}")
+				t.RefId = string(rune('A' + j))
+				targets = append(targets, t)
+			}
+
+			panels = append(panels, panel{
+				Id:         id,
+				Type:       "timeseries",
+				Title:      p.title,
+				Datasource: datasource,
+				GridPos:    gridPos{H: 8, W: 12, X: i % 2 * 12, Y: y + i/2*8},
+				Targets:    targets,
+				FieldConfig: map[string]any{
+					"defaults": map[string]any{"unit": p.unit},
+				},
+			})
+			id++
+		}
+		y += (len(row.panels) + 1) / 2 * 8
+	}
+
+	variable := func(name, query string) map[string]any {
+		return map[string]any{
+			"name":       name,
+			"type":       "query",
+			"datasource": datasource,
+			"query":      map[string]any{"query": query, "refId": name},
+			"definition": query,
+			"refresh":    2,
+			"includeAll": name == "instance",
+			"multi":      name == "instance",
+			"current":    map[string]any{},
+		}
+	}
+
+	dashboard := map[string]any{
+		"title":         title,
+		"uid":           "espresso",
+		"tags":          []string{"espresso"},
+		"schemaVersion": 38,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]any{"from": "now-1h", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]any{
+			"list": []any{
+				map[string]any{
+					"name":    "datasource",
+					"label":   "数据源",
+					"type":    "datasource",
+					"query":   "prometheus",
+					"current": map[string]any{},
+				},
+				variable("job", "label_values(mvc_module_call, job)"),
+				variable("instance", `label_values(mvc_module_call{job="$job"}, instance)`),
+			},
+		},
+	}
+
+	return json.MarshalIndent(dashboard, "", "  ")
+}
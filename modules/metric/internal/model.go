@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"espresso/pkg/ctxhelper"
+	"espresso/pkg/protocol"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"strconv"
+	"time"
 )
 
 var (
@@ -13,12 +16,17 @@ var (
 )
 
 type Model struct {
-	callMetric      *prometheus.CounterVec
-	slowCallMetric  *prometheus.CounterVec
-	panicCallMetric *prometheus.CounterVec
+	callMetric         *prometheus.CounterVec
+	slowCallMetric     *prometheus.CounterVec
+	panicCallMetric    *prometheus.CounterVec
+	durationMetric     *prometheus.HistogramVec
+	errorCallMetric    *prometheus.CounterVec
+	inFlightMetric     *prometheus.GaugeVec
+	requestSizeMetric  *prometheus.SummaryVec
+	responseSizeMetric *prometheus.SummaryVec
 }
 
-func (model *Model) Init(ctx context.Context) error {
+func (model *Model) Init(ctx context.Context, config *Config) error {
 	logger := ctxhelper.FetchLogger(ctx)
 	registry := ctxhelper.FetchRegistry(ctx)
 	if registry == nil {
@@ -43,19 +51,56 @@ func (model *Model) Init(ctx context.Context) error {
 			Help:      "模块调用崩溃数",
 		}, []string{"module", "function"})
 
-		if err := registry.Register(model.callMetric); err != nil {
-			logger.Error("model init", zap.Error(err))
-			return err
+		histogramOpts := prometheus.HistogramOpts{
+			Namespace: "mvc",
+			Name:      "module_call_duration_seconds",
+			Help:      "模块调用耗时",
+			Buckets:   config.Buckets,
 		}
-
-		if err := registry.Register(model.slowCallMetric); err != nil {
-			logger.Error("model init", zap.Error(err))
-			return err
+		if config.NativeHistogram {
+			histogramOpts.NativeHistogramBucketFactor = 1.1
 		}
+		model.durationMetric = prometheus.NewHistogramVec(histogramOpts, []string{"module", "function"})
+
+		model.errorCallMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "mvc",
+			Name:      "module_call_error",
+			Help:      "模块调用失败数，按协议code划分",
+		}, []string{"module", "function", "code"})
+
+		model.inFlightMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mvc",
+			Name:      "module_call_in_flight",
+			Help:      "正在进行的模块调用数",
+		}, []string{"module", "function"})
+
+		model.requestSizeMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: "mvc",
+			Name:      "module_call_request_size_bytes",
+			Help:      "模块调用的请求大小",
+		}, []string{"module", "function"})
+
+		model.responseSizeMetric = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: "mvc",
+			Name:      "module_call_response_size_bytes",
+			Help:      "模块调用的回复大小",
+		}, []string{"module", "function"})
 
-		if err := registry.Register(model.panicCallMetric); err != nil {
-			logger.Error("model init", zap.Error(err))
-			return err
+		collectors := []prometheus.Collector{
+			model.callMetric,
+			model.slowCallMetric,
+			model.panicCallMetric,
+			model.durationMetric,
+			model.errorCallMetric,
+			model.inFlightMetric,
+			model.requestSizeMetric,
+			model.responseSizeMetric,
+		}
+		for _, collector := range collectors {
+			if err := registry.Register(collector); err != nil {
+				logger.Error("model init", zap.Error(err))
+				return err
+			}
 		}
 	}
 	return nil
@@ -81,3 +126,25 @@ func (model *Model) MetricPanicCall(module, function string) {
 	}
 	model.panicCallMetric.WithLabelValues(module, function).Add(1)
 }
+
+func (model *Model) MetricCallStart(module, function string) {
+	if model.inFlightMetric == nil {
+		return
+	}
+	model.inFlightMetric.WithLabelValues(module, function).Inc()
+}
+
+// MetricCallEnd 耗时、失败、请求和回复大小
+func (model *Model) MetricCallEnd(module, function string, cost time.Duration, code int, requestSize, responseSize int64) {
+	if model.durationMetric == nil {
+		return
+	}
+
+	model.inFlightMetric.WithLabelValues(module, function).Dec()
+	model.durationMetric.WithLabelValues(module, function).Observe(cost.Seconds())
+	model.requestSizeMetric.WithLabelValues(module, function).Observe(float64(requestSize))
+	model.responseSizeMetric.WithLabelValues(module, function).Observe(float64(responseSize))
+	if code != protocol.CodeOk {
+		model.errorCallMetric.WithLabelValues(module, function, strconv.Itoa(code)).Inc()
+	}
+}
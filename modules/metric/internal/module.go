@@ -4,13 +4,10 @@ package internal
 
 import (
 	"context"
+	"espresso/pkg/ctxhelper"
 	"espresso/pkg/modules"
-	"sync"
-)
-
-var (
-	metric *Module = nil
-	once   sync.Once
+	"sync/atomic"
+	"time"
 )
 
 // Metric 接口定义
@@ -20,36 +17,54 @@ type Metric interface {
 	// 对外接口
 }
 
-func GetSingleInst() Metric {
-	if metric == nil {
-		once.Do(func() {
-			metric = new(Module)
-		})
-	}
+// UID 模块uid，登记到模块目录时不用构造实例
+const UID = "metric_v0.1.0_core"
 
-	return metric
+// New 每个App安装时构造自己的实例
+func New() Metric {
+	return new(Module)
 }
 
 // Module 模块
 type Module struct {
 	*Model
 	network *Controller
+	config  atomic.Pointer[Config]
 }
 
 // ModuleUID 模块uid
 func (module *Module) ModuleUID() string {
-	return "metric_v0.1.0_core"
+	return UID
+}
+
+// ModuleConfig 模块配置的默认值
+func (module *Module) ModuleConfig() any {
+	return &Config{
+		SlowTime: 3 * time.Second,
+	}
+}
+
+// ModuleReconfigure 配置热更新，慢调用的耗时马上生效
+func (module *Module) ModuleReconfigure(ctx context.Context, old, new any) error {
+	module.config.Store(new.(*Config))
+	return nil
 }
 
 // ModuleInit 模块初始化
 func (module *Module) ModuleInit(ctx context.Context) error {
+	config, ok := ctxhelper.FetchModuleConfig(ctx).(*Config)
+	if !ok {
+		config = module.ModuleConfig().(*Config)
+	}
+	module.config.Store(config)
+
 	module.Model = new(Model)
-	if err := module.Init(ctx); err != nil {
+	if err := module.Init(ctx, config); err != nil {
 		return err
 	}
 
 	module.network = new(Controller)
-	if err := module.network.Init(module.Model); err != nil {
+	if err := module.network.Init(ctx, module.Model, &module.config); err != nil {
 		return err
 	}
 
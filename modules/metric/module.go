@@ -7,3 +7,8 @@ import (
 )
 
 type Metric = internal.Metric
+
+// Dashboard 生成grafana面板json，导入grafana时选择prometheus数据源
+func Dashboard(title string) ([]byte, error) {
+	return internal.Dashboard(title)
+}
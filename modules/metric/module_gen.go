@@ -8,5 +8,5 @@ import (
 )
 
 func init() {
-	modules.Register(internal.GetSingleInst())
+	modules.Provide(internal.UID, func() modules.Module { return internal.New() })
 }
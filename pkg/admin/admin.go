@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"espresso/pkg/admin/internal"
+	"github.com/dan-and-dna/minilog"
+	"github.com/gin-gonic/gin"
+)
+
+type Admin = internal.Admin
+type Option = internal.Option
+
+var (
+	ErrorInvalidAllowIP = internal.ErrorInvalidAllowIP
+)
+
+// Address 管理端口的监听地址，比如 127.0.0.1:8081
+func Address(address string) Option {
+	return internal.Address(address)
+}
+
+func Logger(logger *minilog.MiniLog) Option {
+	return internal.Logger(logger)
+}
+
+// BasicAuth http basic认证，和Token同时设置时满足一个即可
+func BasicAuth(username, password string) Option {
+	return internal.BasicAuth(username, password)
+}
+
+// Token 请求头带上 Authorization: Bearer <token>
+func Token(token string) Option {
+	return internal.Token(token)
+}
+
+// AllowIPs 只允许这些ip或者网段访问，比如 127.0.0.1、10.0.0.0/8
+func AllowIPs(ips ...string) Option {
+	return internal.AllowIPs(ips...)
+}
+
+// Routes 挂在管理端口上的路由
+func Routes(routes ...func(gin.IRouter)) Option {
+	return internal.Routes(routes...)
+}
+
+func New(options ...Option) *Admin {
+	return internal.New(options...)
+}
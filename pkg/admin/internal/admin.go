@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"espresso/pkg/protocol"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrorInvalidAllowIP = errors.New("invalid allow ip")
+)
+
+type options struct {
+	address  string
+	logger   *minilog.MiniLog
+	username string
+	password string
+	token    string
+	allowIPs []*net.IPNet
+	routes   []func(gin.IRouter)
+
+	// 配置错误，Run时返回
+	err error
+}
+
+type Option func(opts *options)
+
+// Address 管理端口的监听地址，比如 127.0.0.1:8081
+func Address(address string) Option {
+	return func(opts *options) {
+		opts.address = address
+	}
+}
+
+func Logger(logger *minilog.MiniLog) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+// BasicAuth http basic认证，和Token同时设置时满足一个即可
+func BasicAuth(username, password string) Option {
+	return func(opts *options) {
+		opts.username = username
+		opts.password = password
+	}
+}
+
+// Token 请求头带上 Authorization: Bearer <token>
+func Token(token string) Option {
+	return func(opts *options) {
+		opts.token = token
+	}
+}
+
+// AllowIPs 只允许这些ip或者网段访问，比如 127.0.0.1、10.0.0.0/8，不经过代理按连接的ip判断
+func AllowIPs(ips ...string) Option {
+	return func(opts *options) {
+		for _, ip := range ips {
+			if !strings.Contains(ip, "/") {
+				if strings.Contains(ip, ":") {
+					ip += "/128"
+				} else {
+					ip += "/32"
+				}
+			}
+
+			_, ipNet, err := net.ParseCIDR(ip)
+			if err != nil {
+				opts.err = fmt.Errorf("%w: %s", ErrorInvalidAllowIP, ip)
+				return
+			}
+			opts.allowIPs = append(opts.allowIPs, ipNet)
+		}
+	}
+}
+
+// Routes 挂在管理端口上的路由
+func Routes(routes ...func(gin.IRouter)) Option {
+	return func(opts *options) {
+		opts.routes = append(opts.routes, routes...)
+	}
+}
+
+// Admin 单独的管理端口，metric、pprof、统计、健康检查和各种管理接口都挂在这里，不暴露在公开端口
+type Admin struct {
+	opts  *options
+	route *gin.Engine
+	srv   *http.Server
+}
+
+func New(applyOptions ...Option) *Admin {
+	gin.SetMode(gin.ReleaseMode)
+
+	admin := &Admin{
+		opts: &options{
+			logger: &minilog.MiniLog{Logger: zap.NewNop()},
+		},
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(admin.opts)
+	}
+
+	admin.route = gin.New()
+	admin.route.Use(gin.Recovery(), GinProtect(admin.opts))
+	for _, route := range admin.opts.routes {
+		route(admin.route)
+	}
+
+	admin.srv = &http.Server{
+		Addr:    admin.opts.address,
+		Handler: admin.route,
+	}
+
+	return admin
+}
+
+// Run 监听http请求，直到Shutdown或者监听失败
+func (admin *Admin) Run(ctx context.Context) error {
+	logger := admin.opts.logger
+	if admin.opts.err != nil {
+		logger.Error("service run", zap.Error(admin.opts.err), zap.String("service", "admin"), zap.Bool("result", false))
+		return admin.opts.err
+	}
+
+	// 按连接的ip判断，不信任X-Forwarded-For
+	if err := admin.route.SetTrustedProxies(nil); err != nil {
+		logger.Error("service run", zap.Error(err), zap.String("service", "admin"), zap.Bool("result", false))
+		return err
+	}
+
+	logger.Info("service run", zap.String("listenAddress", admin.opts.address), zap.Bool("basicAuth", admin.opts.username != ""),
+		zap.Bool("token", admin.opts.token != ""), zap.Int("allowIPs", len(admin.opts.allowIPs)), zap.Bool("result", true), zap.String("service", "admin"))
+	if err := admin.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("service run", zap.Error(err), zap.String("service", "admin"), zap.Bool("result", false))
+		return err
+	}
+
+	logger.Info("service exit", zap.String("service", "admin"), zap.Bool("result", true))
+	return nil
+}
+
+// Shutdown 不再接收新请求，ctx超时后强制关闭
+func (admin *Admin) Shutdown(ctx context.Context) error {
+	logger := admin.opts.logger
+	logger.Warn("service shutdown", zap.String("service", "admin"))
+
+	if err := admin.srv.Shutdown(ctx); err != nil {
+		logger.Error("service shutdown", zap.String("service", "admin"), zap.Bool("result", false), zap.Error(err))
+		_ = admin.srv.Close()
+		return err
+	}
+
+	logger.Info("service shutdown", zap.String("service", "admin"), zap.Bool("result", true))
+	return nil
+}
+
+// Routes 管理端口上的全部路由
+func (admin *Admin) Routes() gin.RoutesInfo {
+	return admin.route.Routes()
+}
+
+// GinProtect 先检查ip白名单，再检查basic认证或者token，都没有设置则不检查
+func GinProtect(opts *options) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(opts.allowIPs) != 0 && !allowIP(opts.allowIPs, c.RemoteIP()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: "forbidden"})
+			return
+		}
+
+		if opts.username == "" && opts.token == "" {
+			c.Next()
+			return
+		}
+
+		if opts.token != "" {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && equal(token, opts.token) {
+				c.Next()
+				return
+			}
+		}
+
+		if opts.username != "" {
+			if username, password, ok := c.Request.BasicAuth(); ok && equal(username, opts.username) && equal(password, opts.password) {
+				c.Next()
+				return
+			}
+			c.Header("WWW-Authenticate", `Basic realm="admin"`)
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: "unauthorized"})
+	}
+}
+
+func allowIP(allowIPs []*net.IPNet, remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range allowIPs {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGinProtect(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	tests := []struct {
+		name     string
+		options  []Option
+		remoteIP string
+		setup    func(request *http.Request)
+		want     int
+	}{
+		{"no protect", nil, "192.168.1.1", nil, http.StatusOK},
+		{"allowed ip", []Option{AllowIPs("127.0.0.1")}, "127.0.0.1", nil, http.StatusOK},
+		{"allowed network", []Option{AllowIPs("10.0.0.0/8")}, "10.1.2.3", nil, http.StatusOK},
+		{"forbidden ip", []Option{AllowIPs("10.0.0.0/8")}, "192.168.1.1", nil, http.StatusForbidden},
+		{"token", []Option{Token("secret")}, "127.0.0.1", func(request *http.Request) {
+			request.Header.Set("Authorization", "Bearer secret")
+		}, http.StatusOK},
+		{"wrong token", []Option{Token("secret")}, "127.0.0.1", func(request *http.Request) {
+			request.Header.Set("Authorization", "Bearer wrong")
+		}, http.StatusUnauthorized},
+		{"missing token", []Option{Token("secret")}, "127.0.0.1", nil, http.StatusUnauthorized},
+		{"basic auth", []Option{BasicAuth("admin", "pass")}, "127.0.0.1", func(request *http.Request) {
+			request.SetBasicAuth("admin", "pass")
+		}, http.StatusOK},
+		{"wrong password", []Option{BasicAuth("admin", "pass")}, "127.0.0.1", func(request *http.Request) {
+			request.SetBasicAuth("admin", "wrong")
+		}, http.StatusUnauthorized},
+		// 同时设置时满足一个即可
+		{"basic auth or token", []Option{BasicAuth("admin", "pass"), Token("secret")}, "127.0.0.1", func(request *http.Request) {
+			request.Header.Set("Authorization", "Bearer secret")
+		}, http.StatusOK},
+		// 先检查ip
+		{"forbidden ip with token", []Option{AllowIPs("127.0.0.1"), Token("secret")}, "10.1.2.3", func(request *http.Request) {
+			request.Header.Set("Authorization", "Bearer secret")
+		}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &options{}
+			for _, applyOption := range test.options {
+				applyOption(opts)
+			}
+			if opts.err != nil {
+				t.Fatal(opts.err)
+			}
+
+			route := gin.New()
+			route.Use(GinProtect(opts))
+			route.GET("/health/live", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+			request.RemoteAddr = test.remoteIP + ":12345"
+			if test.setup != nil {
+				test.setup(request)
+			}
+			recorder := httptest.NewRecorder()
+			route.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+			if recorder.Code == http.StatusUnauthorized && opts.username != "" && recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAllowIPsInvalid(t *testing.T) {
+	opts := &options{}
+	AllowIPs("not an ip")(opts)
+	if opts.err == nil {
+		t.Fatal("want error")
+	}
+}
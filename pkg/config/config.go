@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"espresso/pkg/config/internal"
+	"github.com/dan-and-dna/minilog"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Config = internal.Config
+type AppConfig = internal.AppConfig
+type LogConfig = internal.LogConfig
+type AccessLogConfig = internal.AccessLogConfig
+type TraceConfig = internal.TraceConfig
+type RequestIdConfig = internal.RequestIdConfig
+type MetricPushConfig = internal.MetricPushConfig
+type AdminConfig = internal.AdminConfig
+type ProfilerConfig = internal.ProfilerConfig
+type Option = internal.Option
+type Provider = internal.Provider
+type ModuleSection = internal.ModuleSection
+type Problem = internal.Problem
+
+func File(path string) Option {
+	return internal.File(path)
+}
+
+func FromProvider(provider Provider) Option {
+	return internal.FromProvider(provider)
+}
+
+// HttpProvider 从http地址拉取配置，client为空使用默认client
+func HttpProvider(url string, client *http.Client) Provider {
+	return internal.NewHttpProvider(url, client)
+}
+
+func Env(prefix string) Option {
+	return internal.Env(prefix)
+}
+
+func Args(args []string) Option {
+	return internal.Args(args)
+}
+
+// Load 加载配置，优先级 命令行 > 环境变量 > 配置文件 > 默认值
+func Load(options ...Option) (*Config, error) {
+	return internal.Load(options...)
+}
+
+// Watch 每隔interval轮询重新加载配置，不监听文件变化，配置文件、环境变量或者远端内容变化时调用onChange，onChange返回错误则保持旧配置；app部分的变化只打印警告
+func Watch(ctx context.Context, logger *minilog.MiniLog, current *Config, interval time.Duration, onChange func(old, new *Config) error) error {
+	return internal.Watch(ctx, logger, current, interval, onChange)
+}
+
+// DefaultAppConfig 填好默认值的框架配置，和零值效果一样，用来生成配置文件
+func DefaultAppConfig() AppConfig {
+	return internal.DefaultAppConfig()
+}
+
+// Generate 生成带注释的yaml配置
+func Generate(w io.Writer, app AppConfig, sections []ModuleSection) error {
+	return internal.Generate(w, app, sections)
+}
+
+// Check 检查配置文件里未知的和缺少的key
+func Check(path string, sections []ModuleSection) ([]Problem, error) {
+	return internal.Check(path, sections)
+}
@@ -0,0 +1,522 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorUnknownConfigFormat = errors.New("unknown config format")
+)
+
+// LogConfig 日志配置，对应 minilog.Config
+type LogConfig struct {
+	Environment string `yaml:"environment" comment:"运行环境 development 或者 production"`
+	Filename    string `yaml:"filename" comment:"日志文件路径"`
+	MaxSize     int    `yaml:"maxSize" comment:"日志文件的大小（mb）"`
+	MaxBackups  int    `yaml:"maxBackups" comment:"老日志最大保持数"`
+	MaxAge      int    `yaml:"maxAge" comment:"老日志最大保持日期（天）"`
+	// 按模块设置的日志级别
+	Levels map[string]string `yaml:"levels" comment:"按模块设置日志级别 debug、info、warn、error，比如 say: debug"`
+}
+
+// AccessLogConfig 访问日志配置，对应 network.AccessLogConfig
+type AccessLogConfig struct {
+	Enable      bool          `yaml:"enable" comment:"是否打开访问日志"`
+	Filename    string        `yaml:"filename" comment:"单独的访问日志文件，空则写到app日志"`
+	SampleRate  float64       `yaml:"sampleRate" comment:"正常请求的采样率 0~1，错误和慢请求总是记录，默认1"`
+	SlowTime    time.Duration `yaml:"slowTime" comment:"超过则算慢请求，默认1s"`
+	CaptureBody bool          `yaml:"captureBody" comment:"是否记录请求和回复内容"`
+	MaxBodySize int           `yaml:"maxBodySize" comment:"记录内容的最大长度，默认4096"`
+	Redact      []string      `yaml:"redact" comment:"需要脱敏的json和表单字段，默认 password、token、secret"`
+}
+
+// TraceConfig 链路追踪配置
+type TraceConfig struct {
+	Exporter    string  `yaml:"exporter" comment:"导出方式 otlp、stdout、file，空则不开启"`
+	Endpoint    string  `yaml:"endpoint" comment:"otlp http地址，比如 localhost:4318"`
+	Insecure    bool    `yaml:"insecure" comment:"otlp不使用https"`
+	File        string  `yaml:"file" comment:"file导出的文件路径"`
+	ServiceName string  `yaml:"serviceName" comment:"服务名，默认espresso"`
+	SampleRatio float64 `yaml:"sampleRatio" comment:"采样率 0~1，默认1"`
+}
+
+// RequestIdConfig 请求id配置
+type RequestIdConfig struct {
+	Generator     string `yaml:"generator" comment:"生成器 ulid、uuidv7、snowflake，默认ulid"`
+	Node          int64  `yaml:"node" comment:"snowflake的节点 0~1023，多实例部署时每个实例不能相同"`
+	IgnoreInbound bool   `yaml:"ignoreInbound" comment:"不沿用上游传过来的X-Request-Id"`
+}
+
+// MetricPushConfig 推送metric配置，对应 metricpush.Option
+type MetricPushConfig struct {
+	Pushgateway string        `yaml:"pushgateway" comment:"Pushgateway地址，比如 http://localhost:9091"`
+	RemoteWrite string        `yaml:"remoteWrite" comment:"remote write地址，比如 http://localhost:9090/api/v1/write"`
+	Job         string        `yaml:"job" comment:"job标签，默认espresso"`
+	Instance    string        `yaml:"instance" comment:"instance标签，默认主机名"`
+	Interval    time.Duration `yaml:"interval" comment:"推送间隔，默认15s"`
+}
+
+// AdminConfig 管理端口配置，对应 admin.Option
+type AdminConfig struct {
+	Address  string   `yaml:"address" comment:"管理端口监听地址，空则metric、pprof、健康检查和管理接口挂在http端口"`
+	Username string   `yaml:"username" comment:"basic认证的用户名，和token同时设置时满足一个即可"`
+	Password string   `yaml:"password" comment:"basic认证的密码"`
+	Token    string   `yaml:"token" comment:"请求头带上 Authorization: Bearer <token>"`
+	AllowIPs []string `yaml:"allowIPs" comment:"只允许这些ip或者网段访问，比如 127.0.0.1、10.0.0.0/8"`
+}
+
+// ProfilerConfig 持续采集配置，对应 profiler.Option
+type ProfilerConfig struct {
+	Dir                string            `yaml:"dir" comment:"写到本地目录，和pyroscope都为空则不开启"`
+	MaxFiles           int               `yaml:"maxFiles" comment:"本地目录最多保留的文件数，默认100"`
+	Pyroscope          string            `yaml:"pyroscope" comment:"推送到pyroscope，比如 http://localhost:4040"`
+	AppName            string            `yaml:"appName" comment:"pyroscope的应用名，默认espresso"`
+	Tags               map[string]string `yaml:"tags" comment:"pyroscope的标签"`
+	Profiles           []string          `yaml:"profiles" comment:"采集的种类 cpu、heap、goroutine、mutex，默认全部"`
+	Interval           time.Duration     `yaml:"interval" comment:"定时采集的间隔，默认1m，负数不定时采集"`
+	CPUDuration        time.Duration     `yaml:"cpuDuration" comment:"每次cpu采集的时长，默认10s"`
+	HeapThreshold      uint64            `yaml:"heapThreshold" comment:"堆内存超过多少字节时采集，0不检查"`
+	GoroutineThreshold uint64            `yaml:"goroutineThreshold" comment:"goroutine数超过多少时采集，0不检查"`
+	Cooldown           time.Duration     `yaml:"cooldown" comment:"慢调用、崩溃和超过阈值触发的采集最短间隔，默认1m"`
+}
+
+// PanicConfig 崩溃上报配置，对应 panics.Reporter
+type PanicConfig struct {
+	Http     string            `yaml:"http" comment:"把崩溃的json发到http服务，比如自建的sentry类服务"`
+	Headers  map[string]string `yaml:"headers" comment:"http上报带上的请求头，比如认证"`
+	Webhook  string            `yaml:"webhook" comment:"钉钉、企业微信机器人地址"`
+	File     string            `yaml:"file" comment:"每行一个json追加到本地文件"`
+	Interval time.Duration     `yaml:"interval" comment:"同一分组上报的最短间隔，默认1m"`
+}
+
+// AppConfig 对应 espresso.Option
+type AppConfig struct {
+	Http           string           `yaml:"http" comment:"http监听地址，空则不提供http服务"`
+	Grpc           string           `yaml:"grpc" comment:"grpc监听地址，空则不提供grpc服务"`
+	RuntimeStat    string           `yaml:"runtimeStat" comment:"运行时统计监听地址，空则不启动"`
+	PProf          bool             `yaml:"pprof" comment:"是否打开pprof"`
+	Metric         bool             `yaml:"metric" comment:"是否打开metric"`
+	CronAdmin      bool             `yaml:"cronAdmin" comment:"是否打开定时任务管理接口，需要设置admin"`
+	LogAdmin       bool             `yaml:"logAdmin" comment:"是否打开日志级别管理接口，需要设置admin"`
+	Log            LogConfig        `yaml:"log" comment:"日志"`
+	AccessLog      AccessLogConfig  `yaml:"accessLog" comment:"访问日志"`
+	Trace          TraceConfig      `yaml:"trace" comment:"链路追踪"`
+	RequestId      RequestIdConfig  `yaml:"requestId" comment:"请求id"`
+	MetricPush     MetricPushConfig `yaml:"metricPush" comment:"推送metric，需要打开metric"`
+	Admin          AdminConfig      `yaml:"admin" comment:"管理端口"`
+	Profiler       ProfilerConfig   `yaml:"profiler" comment:"持续采集cpu、heap、goroutine和mutex"`
+	Panic          PanicConfig      `yaml:"panic" comment:"崩溃上报"`
+	Modules        []string         `yaml:"modules" comment:"安装的模块uid，空则安装全部注册的模块"`
+	DisableModules []string         `yaml:"disableModules" comment:"不安装的模块uid，比如某个环境不需要的功能"`
+}
+
+// DefaultAppConfig 填好默认值的框架配置，和零值效果一样，用来生成配置文件
+func DefaultAppConfig() AppConfig {
+	return AppConfig{
+		AccessLog: AccessLogConfig{
+			SampleRate:  1,
+			SlowTime:    time.Second,
+			MaxBodySize: 4096,
+			Redact:      []string{"password", "token", "secret"},
+		},
+		Trace: TraceConfig{
+			ServiceName: "espresso",
+			SampleRatio: 1,
+		},
+		RequestId: RequestIdConfig{
+			Generator: "ulid",
+		},
+		MetricPush: MetricPushConfig{
+			Job:      "espresso",
+			Interval: 15 * time.Second,
+		},
+		Profiler: ProfilerConfig{
+			MaxFiles:    100,
+			AppName:     "espresso",
+			Profiles:    []string{"cpu", "heap", "goroutine", "mutex"},
+			Interval:    time.Minute,
+			CPUDuration: 10 * time.Second,
+			Cooldown:    time.Minute,
+		},
+		Panic: PanicConfig{
+			Interval: time.Minute,
+		},
+	}
+}
+
+// Config 配置，app是框架配置，modules是按模块uid划分的模块配置
+type Config struct {
+	App AppConfig
+
+	opts     *options
+	provider Provider
+	digest   string
+	modules  map[string]any
+}
+
+type options struct {
+	provider  Provider
+	envPrefix string
+	args      []string
+	useArgs   bool
+}
+
+type Option func(opts *options)
+
+// File 配置文件，根据后缀识别 yaml、toml 和 json
+func File(path string) Option {
+	return func(opts *options) {
+		opts.provider = NewFileProvider(path)
+	}
+}
+
+// FromProvider 从远端读取配置
+func FromProvider(provider Provider) Option {
+	return func(opts *options) {
+		opts.provider = provider
+	}
+}
+
+// Env 环境变量前缀，默认 ESPRESSO，比如 ESPRESSO_APP_HTTP、ESPRESSO_MODULES_SAY_V0_1_0_PREFIX
+func Env(prefix string) Option {
+	return func(opts *options) {
+		opts.envPrefix = prefix
+	}
+}
+
+// Args 命令行参数，比如 -config modules.yaml -http :8080 -pprof
+func Args(args []string) Option {
+	return func(opts *options) {
+		opts.args = args
+		opts.useArgs = true
+	}
+}
+
+// Load 加载配置，优先级 命令行 > 环境变量 > 配置文件 > 默认值
+func Load(applyOptions ...Option) (*Config, error) {
+	opts := defaultOptions()
+	for _, applyOption := range applyOptions {
+		applyOption(opts)
+	}
+
+	return load(context.Background(), opts)
+}
+
+func defaultOptions() *options {
+	return &options{
+		envPrefix: "ESPRESSO",
+	}
+}
+
+// Reload 用同样的来源重新加载，零值的Config只读环境变量
+func (config *Config) Reload(ctx context.Context) (*Config, error) {
+	return load(ctx, config.options())
+}
+
+// options 零值的Config没有加载选项，用默认的
+func (config *Config) options() *options {
+	if config == nil || config.opts == nil {
+		return defaultOptions()
+	}
+
+	return config.opts
+}
+
+func load(ctx context.Context, opts *options) (*Config, error) {
+	config := &Config{
+		opts:    opts,
+		modules: make(map[string]any),
+	}
+
+	// 先解析命令行，拿到配置文件路径
+	provider := opts.provider
+	var flags map[string]string
+	if opts.useArgs {
+		var err error
+		var path string
+		path, flags, err = parseArgs(opts.args)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			provider = NewFileProvider(path)
+		}
+	}
+
+	// 配置文件
+	if provider != nil {
+		config.provider = provider
+		data, format, err := provider.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		root, err := parse(data, format)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", provider, err)
+		}
+
+		if app, ok := root["app"]; ok {
+			if err := decodeSection(app, &config.App); err != nil {
+				return nil, fmt.Errorf("decode app config: %w", err)
+			}
+		}
+
+		if modules, ok := root["modules"].(map[string]any); ok {
+			config.modules = modules
+		}
+	}
+
+	// 环境变量
+	if err := applyEnv(opts.envPrefix+"_APP", reflect.ValueOf(&config.App)); err != nil {
+		return nil, err
+	}
+
+	// 命令行
+	for name, value := range flags {
+		field, ok := findFlagField(reflect.ValueOf(&config.App).Elem(), name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(field, value); err != nil {
+			return nil, fmt.Errorf("flag -%s: %w", name, err)
+		}
+	}
+
+	if err := validate(&config.App); err != nil {
+		return nil, fmt.Errorf("validate app config: %w", err)
+	}
+
+	digest, err := config.merged()
+	if err != nil {
+		return nil, err
+	}
+	config.digest = digest
+
+	return config, nil
+}
+
+// Source 配置来源
+func (config *Config) Source() string {
+	if config == nil || config.provider == nil {
+		return ""
+	}
+
+	return fmt.Sprint(config.provider)
+}
+
+// merged 合并后的摘要：app部分已经应用了环境变量和命令行，模块部分在Decode时才应用环境变量，带上模块的环境变量
+func (config *Config) merged() (string, error) {
+	data, err := yaml.Marshal(map[string]any{"app": config.App, "modules": config.modules})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+
+	var envs []string
+	prefix := config.options().envPrefix + "_MODULES_"
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		hash.Write([]byte(env))
+		hash.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Digest 合并了配置文件、环境变量和命令行后的摘要，任意来源变化摘要都会变
+func (config *Config) Digest() string {
+	if config == nil {
+		return ""
+	}
+
+	return config.digest
+}
+
+// Section 模块uid对应的原始配置
+func (config *Config) Section(uid string) any {
+	if config == nil {
+		return nil
+	}
+
+	return config.modules[uid]
+}
+
+// Modules 配置文件里出现的模块uid
+func (config *Config) Modules() []string {
+	if config == nil {
+		return nil
+	}
+
+	uids := make([]string, 0, len(config.modules))
+	for uid := range config.modules {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	return uids
+}
+
+// Decode 把模块uid对应的配置解析到out，out需要是带默认值的结构体指针，然后应用环境变量并校验
+func (config *Config) Decode(uid string, out any) error {
+	envPrefix := config.options().envPrefix
+	if config != nil {
+		if section, ok := config.modules[uid]; ok && section != nil {
+			if err := decodeSection(section, out); err != nil {
+				return fmt.Errorf("decode module config %s: %w", uid, err)
+			}
+		}
+	}
+
+	if err := applyEnv(envPrefix+"_MODULES_"+envKey(uid), reflect.ValueOf(out)); err != nil {
+		return err
+	}
+
+	if err := validate(out); err != nil {
+		return fmt.Errorf("validate module config %s: %w", uid, err)
+	}
+
+	return nil
+}
+
+// parse 解析配置内容为通用结构
+func parse(data []byte, format string) (map[string]any, error) {
+	root := make(map[string]any)
+
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(data, &root)
+	case "toml":
+		err = toml.Unmarshal(data, &root)
+	case "json":
+		err = json.Unmarshal(data, &root)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownConfigFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// decodeSection 统一转成yaml再解析，结构体字段只需要yaml标签
+func decodeSection(section any, out any) error {
+	data, err := yaml.Marshal(section)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, out)
+}
+
+// validate 跟请求参数一样使用gin的binding标签校验
+func validate(out any) error {
+	if binding.Validator == nil {
+		return nil
+	}
+
+	return binding.Validator.ValidateStruct(out)
+}
+
+// parseArgs 解析命令行，返回配置文件路径和设置过的参数
+func parseArgs(args []string) (string, map[string]string, error) {
+	fs := flag.NewFlagSet("espresso", flag.ContinueOnError)
+	path := fs.String("config", "", "配置文件路径")
+
+	values := make(map[string]*flagValue)
+	walkFields(reflect.TypeOf(AppConfig{}), "", nil, func(name string, field reflect.StructField, index []int) {
+		value := &flagValue{isBool: field.Type.Kind() == reflect.Bool}
+		values[name] = value
+		fs.Var(value, name, field.Tag.Get("comment"))
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+
+	flags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok {
+			flags[f.Name] = value.value
+		}
+	})
+
+	return *path, flags, nil
+}
+
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (value *flagValue) String() string {
+	if value == nil {
+		return ""
+	}
+	return value.value
+}
+
+func (value *flagValue) Set(s string) error {
+	value.value = s
+	return nil
+}
+
+func (value *flagValue) IsBoolFlag() bool {
+	return value.isBool
+}
+
+// walkFields 遍历结构体的叶子字段，name是用-连接的路径，比如 log-max-size
+func walkFields(t reflect.Type, prefix string, index []int, fn func(name string, field reflect.StructField, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := FieldKey(field)
+		if key == "" {
+			continue
+		}
+
+		name := flagKey(key)
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		if isNested(field.Type) {
+			walkFields(field.Type, name, fieldIndex, fn)
+			continue
+		}
+
+		fn(name, field, fieldIndex)
+	}
+}
+
+func findFlagField(v reflect.Value, name string) (reflect.Value, bool) {
+	var found reflect.Value
+	walkFields(v.Type(), "", nil, func(fieldName string, field reflect.StructField, index []int) {
+		if fieldName == name {
+			found = v.FieldByIndex(index)
+		}
+	})
+
+	return found, found.IsValid()
+}
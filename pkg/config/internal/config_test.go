@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "modules.yaml", `
+app:
+  http: ":8080"
+  runtimeStat: ":8081"
+  log:
+    maxSize: 10
+  accessLog:
+    slowTime: 2s
+`)
+
+	// 命令行 > 环境变量 > 配置文件
+	t.Setenv("ESPRESSO_TEST_APP_HTTP", ":9090")
+	t.Setenv("ESPRESSO_TEST_APP_RUNTIME_STAT", ":9091")
+	t.Setenv("ESPRESSO_TEST_APP_LOG_MAX_SIZE", "20")
+
+	cfg, err := Load(Env("ESPRESSO_TEST"), Args([]string{"-config", path, "-http", ":7070", "-pprof"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := cfg.App
+	if app.Http != ":7070" || app.RuntimeStat != ":9091" || app.Log.MaxSize != 20 || !app.PProf {
+		t.Fatalf("app = %+v", app)
+	}
+	if app.AccessLog.SlowTime != 2*time.Second {
+		t.Fatalf("slowTime = %v", app.AccessLog.SlowTime)
+	}
+	if cfg.Source() != path {
+		t.Fatalf("source = %q", cfg.Source())
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"modules.yaml", "app:\n  http: \":8080\"\nmodules:\n  say:\n    prefix: hi\n"},
+		{"modules.toml", "[app]\nhttp = \":8080\"\n[modules.say]\nprefix = \"hi\"\n"},
+		{"modules.json", `{"app":{"http":":8080"},"modules":{"say":{"prefix":"hi"}}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Load(File(writeConfig(t, test.name, test.content)), Env("ESPRESSO_TEST"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.App.Http != ":8080" {
+				t.Fatalf("http = %q", cfg.App.Http)
+			}
+
+			var say struct {
+				Prefix string `yaml:"prefix"`
+			}
+			if err := cfg.Decode("say", &say); err != nil {
+				t.Fatal(err)
+			}
+			if say.Prefix != "hi" {
+				t.Fatalf("prefix = %q", say.Prefix)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cfg, err := Load(File(writeConfig(t, "modules.yaml", "modules:\n  say_v0.1.0:\n    prefix: hi\n")), Env("ESPRESSO_TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type sayConfig struct {
+		Prefix string `yaml:"prefix"`
+		Times  int    `yaml:"times" binding:"gte=1"`
+	}
+
+	// 没有配置的字段保持默认值，环境变量覆盖配置文件
+	t.Setenv("ESPRESSO_TEST_MODULES_SAY_V0_1_0_TIMES", "3")
+	say := &sayConfig{Prefix: "hello", Times: 1}
+	if err := cfg.Decode("say_v0.1.0", say); err != nil {
+		t.Fatal(err)
+	}
+	if say.Prefix != "hi" || say.Times != 3 {
+		t.Fatalf("say = %+v", say)
+	}
+
+	// 校验失败
+	t.Setenv("ESPRESSO_TEST_MODULES_SAY_V0_1_0_TIMES", "0")
+	if err := cfg.Decode("say_v0.1.0", &sayConfig{}); err == nil {
+		t.Fatal("want validate error")
+	}
+}
+
+func TestLoadUnknownFormat(t *testing.T) {
+	if _, err := Load(File(writeConfig(t, "modules.ini", "http=:8080"))); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestZeroConfig(t *testing.T) {
+	// 零值和nil只读环境变量
+	t.Setenv("ESPRESSO_MODULES_SAY_PREFIX", "env")
+
+	for _, cfg := range []*Config{{}, nil} {
+		var say struct {
+			Prefix string `yaml:"prefix"`
+		}
+		if err := cfg.Decode("say", &say); err != nil {
+			t.Fatal(err)
+		}
+		if say.Prefix != "env" {
+			t.Fatalf("prefix = %q", say.Prefix)
+		}
+	}
+
+	if _, err := (&Config{}).Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	path := writeConfig(t, "modules.yaml", "app:\n  http: \":8080\"\nmodules:\n  say:\n    prefix: hi\n")
+
+	cfg, err := Load(File(path), Env("ESPRESSO_TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		value   string
+		changed bool
+	}{
+		{"unchanged", "", "", false},
+		// 配置文件没变，环境变量变了
+		{"app env", "ESPRESSO_TEST_APP_HTTP", ":9090", true},
+		{"module env", "ESPRESSO_TEST_MODULES_SAY_PREFIX", "hello", true},
+		{"other env", "OTHER_ENV", "x", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.env != "" {
+				t.Setenv(test.env, test.value)
+			}
+
+			next, err := cfg.Reload(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := next.Digest() != cfg.Digest(); changed != test.changed {
+				t.Fatalf("changed = %v", changed)
+			}
+		})
+	}
+}
+
+func TestAppChanges(t *testing.T) {
+	old := DefaultAppConfig()
+	new := DefaultAppConfig()
+	if keys := appChanges(old, new); len(keys) != 0 {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	new.Http = ":8080"
+	new.Log.MaxSize = 10
+	new.Profiler.Profiles = []string{"cpu"}
+	keys := appChanges(old, new)
+	if strings.Join(keys, ",") != "http,log-max-size,profiler-profiles" {
+		t.Fatalf("keys = %v", keys)
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ModuleSection 模块uid和带默认值的模块配置
+type ModuleSection struct {
+	UID    string
+	Config any
+}
+
+// Problem 配置文件的问题
+type Problem struct {
+	Key  string
+	Kind string // unknown 或者 missing
+}
+
+func (problem Problem) String() string {
+	return problem.Kind + ": " + problem.Key
+}
+
+// Generate 生成带注释的yaml配置，注释取comment标签
+func Generate(w io.Writer, app AppConfig, sections []ModuleSection) error {
+	appNode, err := encodeNode(reflect.ValueOf(app))
+	if err != nil {
+		return err
+	}
+
+	modulesNode := &yaml.Node{Kind: yaml.MappingNode}
+	for _, section := range sections {
+		value, err := encodeNode(reflect.ValueOf(section.Config))
+		if err != nil {
+			return fmt.Errorf("encode module config %s: %w", section.UID, err)
+		}
+
+		modulesNode.Content = append(modulesNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section.UID, HeadComment: "模块 " + section.UID}, value)
+	}
+
+	root := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "app", HeadComment: "本文件由 config-generator 生成\n\n框架配置，对应 espresso.Option"}, appNode,
+			{Kind: yaml.ScalarNode, Value: "modules", HeadComment: "模块配置，按模块uid划分"}, modulesNode,
+		},
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// encodeNode 结构体展开成带注释的节点，时长用 4s 这样的写法
+func encodeNode(v reflect.Value) (*yaml.Node, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.MappingNode}, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == durationType {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(v.Int()).String()}, nil
+	}
+
+	if !isNested(v.Type()) {
+		node := &yaml.Node{}
+		if err := node.Encode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := FieldKey(field)
+		if key == "" {
+			continue
+		}
+
+		value, err := encodeNode(v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key, HeadComment: field.Tag.Get("comment")}, value)
+	}
+
+	return node, nil
+}
+
+// Check 检查配置文件，找出没有对应字段的key和缺少的key（缺少的key使用默认值）
+func Check(path string, sections []ModuleSection) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parse(data, formatOf(path))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var problems []Problem
+	for _, key := range sortedKeys(root) {
+		if key != "app" && key != "modules" {
+			problems = append(problems, Problem{Key: key, Kind: "unknown"})
+		}
+	}
+
+	checkValue("app", reflect.TypeOf(AppConfig{}), root["app"], &problems)
+
+	modules, _ := root["modules"].(map[string]any)
+	known := make(map[string]struct{}, len(sections))
+	for _, section := range sections {
+		known[section.UID] = struct{}{}
+		key := "modules." + section.UID
+		value, ok := modules[section.UID]
+		if !ok {
+			problems = append(problems, Problem{Key: key, Kind: "missing"})
+			continue
+		}
+		checkValue(key, reflect.TypeOf(section.Config), value, &problems)
+	}
+	for _, uid := range sortedKeys(modules) {
+		if _, ok := known[uid]; !ok {
+			problems = append(problems, Problem{Key: "modules." + uid, Kind: "unknown"})
+		}
+	}
+
+	return problems, nil
+}
+
+func checkValue(prefix string, t reflect.Type, value any, problems *[]Problem) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || !isNested(t) {
+		return
+	}
+
+	section, ok := value.(map[string]any)
+	if !ok {
+		*problems = append(*problems, Problem{Key: prefix, Kind: "missing"})
+		return
+	}
+
+	fields := make(map[string]struct{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := FieldKey(field)
+		if key == "" {
+			continue
+		}
+		fields[key] = struct{}{}
+
+		value, ok := section[key]
+		if !ok {
+			*problems = append(*problems, Problem{Key: prefix + "." + key, Kind: "missing"})
+			continue
+		}
+		checkValue(prefix+"."+key, field.Type, value, problems)
+	}
+
+	for _, key := range sortedKeys(section) {
+		if _, ok := fields[key]; !ok {
+			*problems = append(*problems, Problem{Key: prefix + "." + key, Kind: "unknown"})
+		}
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
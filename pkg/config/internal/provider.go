@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Provider 配置来源，返回内容和格式(yaml、toml、json)
+type Provider interface {
+	Read(ctx context.Context) ([]byte, string, error)
+}
+
+// formatOf 根据后缀识别格式
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".json":
+		return "json"
+	}
+
+	return ""
+}
+
+// FileProvider 本地配置文件
+type FileProvider struct {
+	path string
+}
+
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+func (provider *FileProvider) Read(ctx context.Context) ([]byte, string, error) {
+	data, err := os.ReadFile(provider.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, formatOf(provider.path), nil
+}
+
+func (provider *FileProvider) String() string {
+	return provider.path
+}
+
+// HttpProvider 从http地址拉取配置，支持ETag，格式取Content-Type或者地址后缀
+type HttpProvider struct {
+	url    string
+	client *http.Client
+
+	etag   string
+	data   []byte
+	format string
+	m      sync.Mutex
+}
+
+func NewHttpProvider(url string, client *http.Client) *HttpProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HttpProvider{url: url, client: client}
+}
+
+func (provider *HttpProvider) Read(ctx context.Context) ([]byte, string, error) {
+	provider.m.Lock()
+	defer provider.m.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if provider.etag != "" {
+		req.Header.Set("If-None-Match", provider.etag)
+	}
+
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	// 没有变化
+	if resp.StatusCode == http.StatusNotModified {
+		return provider.data, provider.format, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("read config %s: %s", provider.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	format := ""
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		switch {
+		case strings.Contains(mediaType, "json"):
+			format = "json"
+		case strings.Contains(mediaType, "toml"):
+			format = "toml"
+		case strings.Contains(mediaType, "yaml"):
+			format = "yaml"
+		}
+	}
+	if format == "" {
+		if u, err := url.Parse(provider.url); err == nil {
+			format = formatOf(u.Path)
+		}
+	}
+
+	provider.etag = resp.Header.Get("ETag")
+	provider.data = data
+	provider.format = format
+
+	return data, format, nil
+}
+
+func (provider *HttpProvider) String() string {
+	return provider.url
+}
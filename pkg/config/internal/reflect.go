@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// FieldKey 字段在配置文件里的名字，取yaml标签，没有则用字段名，忽略的字段返回空
+func FieldKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	tag := field.Tag.Get("yaml")
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return ""
+	}
+
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+
+	return name
+}
+
+// isNested 是否是需要展开的结构体
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// splitWords 按驼峰和非字母数字切分，maxSize -> max size，v0.1.0 -> v0 1 0
+func splitWords(key string) []string {
+	var words []string
+	var word []rune
+
+	runes := []rune(key)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) != 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+
+		if unicode.IsUpper(r) && len(word) != 0 && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			words = append(words, string(word))
+			word = nil
+		}
+
+		word = append(word, unicode.ToLower(r))
+	}
+
+	if len(word) != 0 {
+		words = append(words, string(word))
+	}
+
+	return words
+}
+
+// envKey 环境变量名，maxSize -> MAX_SIZE
+func envKey(key string) string {
+	return strings.ToUpper(strings.Join(splitWords(key), "_"))
+}
+
+// flagKey 命令行参数名，maxSize -> max-size
+func flagKey(key string) string {
+	return strings.Join(splitWords(key), "-")
+}
+
+// applyEnv 用环境变量覆盖结构体字段，变量名是 前缀_字段路径
+func applyEnv(prefix string, v reflect.Value) error {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := FieldKey(field)
+		if key == "" {
+			continue
+		}
+
+		name := prefix + "_" + envKey(key)
+		if isNested(field.Type) {
+			if err := applyEnv(name, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setFromString(v.Field(i), value); err != nil {
+			return &EnvError{Name: name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// setFromString 字符串赋值给字段，字符串切片用逗号分隔，其他类型按yaml解析
+func setFromString(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values).Convert(field.Type()))
+		return nil
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	return yaml.Unmarshal([]byte(value), field.Addr().Interface())
+}
+
+// EnvError 环境变量格式错误
+type EnvError struct {
+	Name string
+	Err  error
+}
+
+func (err *EnvError) Error() string {
+	return "env " + err.Name + ": " + err.Err.Error()
+}
+
+func (err *EnvError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"reflect"
+	"time"
+)
+
+// Watch 定时重新加载配置（轮询，不监听文件变化），配置文件、环境变量或者远端内容变化时调用onChange，onChange返回错误则保持旧配置，直到ctx结束；
+// app部分的变化不会生效，只打印警告，需要重启
+func Watch(ctx context.Context, logger *minilog.MiniLog, current *Config, interval time.Duration, onChange func(old, new *Config) error) error {
+	logger.Info("service run", zap.String("source", current.Source()), zap.Duration("interval", interval), zap.Bool("result", true), zap.String("service", "configWatcher"))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// 回滚过的内容不再重复尝试
+	rejected := ""
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("service exit", zap.String("service", "configWatcher"), zap.Bool("result", true))
+			return nil
+		case <-ticker.C:
+		}
+
+		next, err := current.Reload(ctx)
+		if err != nil {
+			logger.Error("reload config", zap.String("source", current.Source()), zap.Bool("result", false), zap.Error(err))
+			continue
+		}
+
+		if next.Digest() == current.Digest() || next.Digest() == rejected {
+			continue
+		}
+
+		if keys := appChanges(current.App, next.App); len(keys) != 0 {
+			logger.Warn("reload config, app config changed, restart required", zap.String("source", current.Source()), zap.Strings("keys", keys))
+		}
+
+		if err := onChange(current, next); err != nil {
+			rejected = next.Digest()
+			logger.Error("reload config, rollback", zap.String("source", current.Source()), zap.Bool("result", false), zap.Error(err))
+			continue
+		}
+
+		logger.Info("reload config", zap.String("source", current.Source()), zap.Bool("result", true))
+		current = next
+	}
+}
+
+// appChanges 变化的app配置，返回命令行参数名，比如 http、log-max-size
+func appChanges(old, new AppConfig) []string {
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+
+	var keys []string
+	walkFields(oldValue.Type(), "", nil, func(name string, field reflect.StructField, index []int) {
+		if !reflect.DeepEqual(oldValue.FieldByIndex(index).Interface(), newValue.FieldByIndex(index).Interface()) {
+			keys = append(keys, name)
+		}
+	})
+
+	return keys
+}
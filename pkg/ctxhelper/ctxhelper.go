@@ -2,12 +2,15 @@ package ctxhelper
 
 import (
 	"context"
+	"espresso/pkg/config"
 	"github.com/dan-and-dna/minilog"
 	"github.com/gin-gonic/gin"
-	"github.com/kamilsk/tracer"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Set 字符串key，容易冲突
+//
+// Deprecated: 使用 Key
 func Set(ctx context.Context, key string, value any) context.Context {
 	if c, ok := ctx.(*gin.Context); ok {
 		c.Set(key, value)
@@ -18,6 +21,9 @@ func Set(ctx context.Context, key string, value any) context.Context {
 	return ctx
 }
 
+// Get 字符串key，容易冲突
+//
+// Deprecated: 使用 Key
 func Get(ctx context.Context, key string) any {
 	if c, ok := ctx.(*gin.Context); ok {
 		val, exists := c.Get(key)
@@ -29,67 +35,43 @@ func Get(ctx context.Context, key string) any {
 	return ctx.Value(key)
 }
 
-const TraceKey = "core_trace_key"
-
-func InjectTrace(ctx context.Context, trace *tracer.Trace) context.Context {
-	return Set(ctx, TraceKey, trace)
-}
-
-func FetchTrace(ctx context.Context) *tracer.Trace {
-	ptr := Get(ctx, TraceKey)
-	if ptr == nil {
-		return nil
-	}
-
-	ret, _ := ptr.(*tracer.Trace)
-	return ret
-}
-
-const LoggerKey = "core_logger_key"
+var (
+	LoggerKey       = NewKey[*minilog.MiniLog]("core_logger")
+	RegistryKey     = NewKey[*prometheus.Registry]("core_registry")
+	RequestIdKey    = NewKey[string]("core_requestId")
+	MetadataKey     = NewKey[map[string][]string]("core_metadata")
+	ConfigKey       = NewKey[*config.Config]("core_config")
+	ModuleConfigKey = NewKey[any]("core_module_config")
+	ModuleUIDKey    = NewKey[string]("core_module_uid")
+
+	// configSourceKey 热更新时配置会变，注入取当前配置的函数
+	configSourceKey = NewKey[func() *config.Config]("core_config_source")
+)
 
 func InjectLogger(ctx context.Context, logger *minilog.MiniLog) context.Context {
-	return Set(ctx, LoggerKey, logger)
+	return LoggerKey.Inject(ctx, logger)
 }
 
 func FetchLogger(ctx context.Context) *minilog.MiniLog {
-	ptr := Get(ctx, LoggerKey)
-	if ptr == nil {
-		return nil
-	}
-
-	ret, _ := ptr.(*minilog.MiniLog)
+	ret, _ := LoggerKey.Fetch(ctx)
 	return ret
 }
 
-const RegistryKey = "core_registry_key"
-
 func InjectRegistry(ctx context.Context, registry *prometheus.Registry) context.Context {
-	return Set(ctx, RegistryKey, registry)
+	return RegistryKey.Inject(ctx, registry)
 }
 
 func FetchRegistry(ctx context.Context) *prometheus.Registry {
-	ptr := Get(ctx, RegistryKey)
-	if ptr == nil {
-		return nil
-	}
-
-	ret, _ := ptr.(*prometheus.Registry)
+	ret, _ := RegistryKey.Fetch(ctx)
 	return ret
 }
 
-const RequestIdKey = "core_requestId"
-
 func InjectRequestId(ctx context.Context, requestId string) context.Context {
-	return Set(ctx, RequestIdKey, requestId)
+	return RequestIdKey.Inject(ctx, requestId)
 }
 
 func FetchRequestId(ctx context.Context) string {
-	ptr := Get(ctx, RequestIdKey)
-	if ptr == nil {
-		return ""
-	}
-
-	ret, _ := ptr.(string)
+	ret, _ := RequestIdKey.Fetch(ctx)
 	return ret
 }
 
@@ -100,17 +82,52 @@ func FetchClientIP(ctx context.Context) string {
 	return ""
 }
 
-const MetadataKey = "core_metadata"
-
 func InjectMetadata(ctx context.Context, metadata map[string][]string) context.Context {
-	return Set(ctx, MetadataKey, metadata)
+	return MetadataKey.Inject(ctx, metadata)
 }
 
 func FetchMetadata(ctx context.Context) map[string][]string {
-	val := Get(ctx, MetadataKey)
-	if val != nil {
-		return val.(map[string][]string)
+	ret, _ := MetadataKey.Fetch(ctx)
+	return ret
+}
+
+func InjectConfig(ctx context.Context, config *config.Config) context.Context {
+	return ConfigKey.Inject(ctx, config)
+}
+
+// InjectConfigSource 注入取当前配置的函数，热更新后 FetchConfig 拿到的是新配置
+func InjectConfigSource(ctx context.Context, source func() *config.Config) context.Context {
+	return configSourceKey.Inject(ctx, source)
+}
+
+// FetchConfig 当前配置，注入了 InjectConfigSource 时用它
+func FetchConfig(ctx context.Context) *config.Config {
+	if source, ok := configSourceKey.Fetch(ctx); ok && source != nil {
+		return source()
 	}
 
-	return nil
+	ret, _ := ConfigKey.Fetch(ctx)
+	return ret
+}
+
+// InjectModuleConfig 注入当前模块的配置，安装模块时注入
+func InjectModuleConfig(ctx context.Context, moduleConfig any) context.Context {
+	return ModuleConfigKey.Inject(ctx, moduleConfig)
+}
+
+// FetchModuleConfig 当前模块的配置，即 ModuleConfig() 返回并填充好的结构体指针
+func FetchModuleConfig(ctx context.Context) any {
+	ret, _ := ModuleConfigKey.Fetch(ctx)
+	return ret
+}
+
+// InjectModuleUID 注入当前模块的uid，安装模块时注入
+func InjectModuleUID(ctx context.Context, uid string) context.Context {
+	return ModuleUIDKey.Inject(ctx, uid)
+}
+
+// FetchModuleUID 当前模块的uid，模块初始化的ctx里才有
+func FetchModuleUID(ctx context.Context) string {
+	ret, _ := ModuleUIDKey.Fetch(ctx)
+	return ret
 }
@@ -0,0 +1,77 @@
+package ctxhelper
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sync/atomic"
+)
+
+var keySeq atomic.Uint64
+
+// Key 带类型的context key，每次 NewKey 都是不同的key，同名也不会冲突
+type Key[T any] struct {
+	name string
+	// gin.Context 只支持字符串key，带上序号避免冲突
+	ginKey string
+}
+
+// NewKey 创建key，name只用来识别
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{
+		name:   name,
+		ginKey: fmt.Sprintf("%s#%d", name, keySeq.Add(1)),
+	}
+}
+
+func (key *Key[T]) String() string {
+	return key.name
+}
+
+// Inject 注入值，*gin.Context 直接修改自身并返回自身，其他context返回新的context
+func (key *Key[T]) Inject(ctx context.Context, value T) context.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		c.Set(key.ginKey, value)
+		return c
+	}
+
+	return context.WithValue(ctx, key, value)
+}
+
+// Fetch 取值，没有注入返回零值和false
+func (key *Key[T]) Fetch(ctx context.Context) (T, bool) {
+	var zero T
+	if ctx == nil {
+		return zero, false
+	}
+
+	if c, ok := ctx.(*gin.Context); ok {
+		if val, exists := c.Get(key.ginKey); exists {
+			ret, ok := val.(T)
+			return ret, ok
+		}
+		return zero, false
+	}
+
+	val := ctx.Value(key)
+	if val == nil {
+		// 从 *gin.Context 派生的context
+		val = ctx.Value(key.ginKey)
+	}
+	if val == nil {
+		return zero, false
+	}
+
+	ret, ok := val.(T)
+	return ret, ok
+}
+
+// MustFetch 取值，没有注入则panic
+func (key *Key[T]) MustFetch(ctx context.Context) T {
+	ret, ok := key.Fetch(ctx)
+	if !ok {
+		panic(fmt.Errorf("ctxhelper: key %s not injected", key.name))
+	}
+
+	return ret
+}
@@ -0,0 +1,47 @@
+package election
+
+import (
+	"espresso/pkg/election/internal"
+	"github.com/redis/go-redis/v9"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"time"
+)
+
+type Elector = internal.Elector
+type Locker = internal.Locker
+type Option = internal.Option
+
+func WithLocker(locker Locker) Option {
+	return internal.WithLocker(locker)
+}
+
+func Key(key string) Option {
+	return internal.Key(key)
+}
+
+func Id(id string) Option {
+	return internal.Id(id)
+}
+
+func TTL(ttl time.Duration) Option {
+	return internal.TTL(ttl)
+}
+
+func New(options ...Option) *Elector {
+	return internal.New(options...)
+}
+
+// FileLocker 本地文件锁
+func FileLocker(dir string) Locker {
+	return internal.NewFileLocker(dir)
+}
+
+// RedisLocker redis锁
+func RedisLocker(client redis.UniversalClient) Locker {
+	return internal.NewRedisLocker(client)
+}
+
+// EtcdLocker etcd锁
+func EtcdLocker(client *clientv3.Client) Locker {
+	return internal.NewEtcdLocker(client)
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrorEmptyLocker = errors.New("empty locker")
+)
+
+// Locker 分布式锁，锁带租期，持有者需要在租期内续约，否则锁自动释放
+type Locker interface {
+	// TryLock 尝试加锁，已持有则续约，返回是否持有锁
+	TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Unlock 释放锁，只有持有者才能释放
+	Unlock(ctx context.Context, key, owner string) error
+}
+
+type options struct {
+	locker Locker
+	key    string
+	id     string
+	ttl    time.Duration
+}
+
+type Option func(opts *options)
+
+// WithLocker 锁提供者
+func WithLocker(locker Locker) Option {
+	return func(opts *options) {
+		opts.locker = locker
+	}
+}
+
+// Key 选举用的锁名，同一服务的全部实例需要一致
+func Key(key string) Option {
+	return func(opts *options) {
+		opts.key = key
+	}
+}
+
+// Id 本实例id，默认 hostname-pid
+func Id(id string) Option {
+	return func(opts *options) {
+		opts.id = id
+	}
+}
+
+// TTL 租期，leader挂掉后最多过这么久完成切换
+func TTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.ttl = ttl
+	}
+}
+
+// Elector 基于租约锁的leader选举
+type Elector struct {
+	opts   *options
+	leader atomic.Bool
+}
+
+func New(applyOptions ...Option) *Elector {
+	elector := &Elector{
+		opts: &options{},
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(elector.opts)
+	}
+
+	if elector.opts.key == "" {
+		elector.opts.key = "espresso_leader"
+	}
+
+	if elector.opts.ttl <= 0 {
+		elector.opts.ttl = 15 * time.Second
+	}
+
+	if elector.opts.id == "" {
+		hostname, _ := os.Hostname()
+		elector.opts.id = fmt.Sprintf("%s-%s", hostname, strconv.Itoa(os.Getpid()))
+	}
+
+	return elector
+}
+
+// Id 本实例id
+func (elector *Elector) Id() string {
+	if elector == nil {
+		return ""
+	}
+
+	return elector.opts.id
+}
+
+// IsLeader 本实例是否是leader
+func (elector *Elector) IsLeader() bool {
+	if elector == nil {
+		return false
+	}
+
+	return elector.leader.Load()
+}
+
+// Run 参与选举直到ctx结束，结束时主动释放锁方便其他实例尽快接手
+func (elector *Elector) Run(ctx context.Context) error {
+	logger := ctxhelper.FetchLogger(ctx)
+	if elector.opts.locker == nil {
+		logger.Error("service run", zap.Error(ErrorEmptyLocker), zap.String("service", "election"), zap.Bool("result", false))
+		return ErrorEmptyLocker
+	}
+
+	logger.Info("service run", zap.String("key", elector.opts.key), zap.String("id", elector.opts.id), zap.Bool("result", true), zap.String("service", "election"))
+
+	// 续约间隔是租期的1/3，容忍两次失败
+	ticker := time.NewTicker(elector.opts.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		elector.campaign(ctx)
+
+		select {
+		case <-ctx.Done():
+			elector.resign()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign 抢锁或者续约
+func (elector *Elector) campaign(ctx context.Context) {
+	logger := ctxhelper.FetchLogger(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, elector.opts.ttl/3)
+	defer cancel()
+
+	ok, err := elector.opts.locker.TryLock(ctx, elector.opts.key, elector.opts.id, elector.opts.ttl)
+	if err != nil {
+		logger.Error("campaign", zap.Error(err), zap.String("key", elector.opts.key), zap.String("id", elector.opts.id))
+		// 续约失败不能确认自己还是leader，退位避免双主
+		ok = false
+	}
+
+	if old := elector.leader.Swap(ok); old != ok {
+		if ok {
+			logger.Info("become leader", zap.String("key", elector.opts.key), zap.String("id", elector.opts.id))
+		} else {
+			logger.Warn("lose leadership", zap.String("key", elector.opts.key), zap.String("id", elector.opts.id))
+		}
+	}
+}
+
+// resign 退位
+func (elector *Elector) resign() {
+	if !elector.leader.Swap(false) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), elector.opts.ttl/3)
+	defer cancel()
+
+	_ = elector.opts.locker.Unlock(ctx, elector.opts.key, elector.opts.id)
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"sync"
+	"time"
+)
+
+// EtcdLocker 基于etcd租约的锁
+type EtcdLocker struct {
+	client *clientv3.Client
+
+	leases  map[string]clientv3.LeaseID
+	leasesM sync.Mutex
+}
+
+func NewEtcdLocker(client *clientv3.Client) *EtcdLocker {
+	return &EtcdLocker{
+		client: client,
+		leases: make(map[string]clientv3.LeaseID),
+	}
+}
+
+func (locker *EtcdLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	locker.leasesM.Lock()
+	defer locker.leasesM.Unlock()
+
+	// 已经持有就续约
+	if leaseId, ok := locker.leases[key]; ok {
+		_, err := locker.client.KeepAliveOnce(ctx, leaseId)
+		if err == nil {
+			return true, nil
+		}
+
+		delete(locker.leases, key)
+		if !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return false, err
+		}
+	}
+
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	lease, err := locker.client.Grant(ctx, seconds)
+	if err != nil {
+		return false, err
+	}
+
+	// key不存在才写入
+	resp, err := locker.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, owner, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		_, _ = locker.client.Revoke(context.Background(), lease.ID)
+		return false, err
+	}
+
+	if !resp.Succeeded {
+		_, _ = locker.client.Revoke(context.Background(), lease.ID)
+		return false, nil
+	}
+
+	locker.leases[key] = lease.ID
+	return true, nil
+}
+
+func (locker *EtcdLocker) Unlock(ctx context.Context, key, owner string) error {
+	locker.leasesM.Lock()
+	defer locker.leasesM.Unlock()
+
+	leaseId, ok := locker.leases[key]
+	if !ok {
+		return nil
+	}
+	delete(locker.leases, key)
+
+	// 撤销租约，key跟着删除
+	_, err := locker.client.Revoke(ctx, leaseId)
+	return err
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileLocker 本地文件锁，适合同一台机器上跑多个实例
+type FileLocker struct {
+	dir string
+}
+
+type fileLease struct {
+	Owner    string `json:"owner"`
+	ExpireAt int64  `json:"expireAt"`
+}
+
+func NewFileLocker(dir string) *FileLocker {
+	return &FileLocker{dir: dir}
+}
+
+func (locker *FileLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	unguard, err := locker.guard(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer unguard()
+
+	now := time.Now()
+	lease, err := locker.read(key)
+	if err != nil {
+		return false, err
+	}
+
+	// 别人持有并且没过期
+	if lease != nil && lease.Owner != owner && lease.ExpireAt > now.UnixNano() {
+		return false, nil
+	}
+
+	// 抢锁或者续约
+	if err := locker.write(key, &fileLease{Owner: owner, ExpireAt: now.Add(ttl).UnixNano()}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (locker *FileLocker) Unlock(ctx context.Context, key, owner string) error {
+	unguard, err := locker.guard(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer unguard()
+
+	lease, err := locker.read(key)
+	if err != nil {
+		return err
+	}
+
+	if lease == nil || lease.Owner != owner {
+		return nil
+	}
+
+	if err := os.Remove(locker.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (locker *FileLocker) path(key string) string {
+	return filepath.Join(locker.dir, key+".lock")
+}
+
+// guard 用独占创建的文件保护读写过程，进程崩溃留下的guard文件超时后清理
+func (locker *FileLocker) guard(ctx context.Context, key string) (func(), error) {
+	if err := os.MkdirAll(locker.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	guardPath := locker.path(key) + ".guard"
+	for {
+		f, err := os.OpenFile(guardPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(guardPath) }, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(guardPath); err == nil && time.Since(info.ModTime()) > 10*time.Second {
+			_ = os.Remove(guardPath)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (locker *FileLocker) read(key string) (*fileLease, error) {
+	data, err := os.ReadFile(locker.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lease := &fileLease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		// 内容损坏当作没有人持有
+		return nil, nil
+	}
+
+	return lease, nil
+}
+
+func (locker *FileLocker) write(key string, lease *fileLease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免别人读到一半的内容
+	tmp := locker.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, locker.path(key))
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFileLocker(t *testing.T) {
+	ctx := context.Background()
+	locker := NewFileLocker(t.TempDir())
+
+	steps := []struct {
+		name   string
+		action func() (bool, error)
+		want   bool
+	}{
+		{"a locks", func() (bool, error) { return locker.TryLock(ctx, "job", "a", time.Hour) }, true},
+		{"b blocked", func() (bool, error) { return locker.TryLock(ctx, "job", "b", time.Hour) }, false},
+		{"a renews", func() (bool, error) { return locker.TryLock(ctx, "job", "a", time.Hour) }, true},
+		{"other key", func() (bool, error) { return locker.TryLock(ctx, "other", "b", time.Hour) }, true},
+		// 不是持有者，释放不生效
+		{"b unlock ignored", func() (bool, error) { return true, locker.Unlock(ctx, "job", "b") }, true},
+		{"b still blocked", func() (bool, error) { return locker.TryLock(ctx, "job", "b", time.Hour) }, false},
+		{"a unlock", func() (bool, error) { return true, locker.Unlock(ctx, "job", "a") }, true},
+		{"b locks", func() (bool, error) { return locker.TryLock(ctx, "job", "b", time.Millisecond) }, true},
+		// 租期过期后别人可以抢
+		{"a takes expired", func() (bool, error) {
+			time.Sleep(5 * time.Millisecond)
+			return locker.TryLock(ctx, "job", "a", time.Hour)
+		}, true},
+	}
+
+	for _, step := range steps {
+		got, err := step.action()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Fatalf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+}
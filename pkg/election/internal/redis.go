@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	// 持有者续约
+	redisRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// 持有者释放
+	redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisLocker 基于redis SET NX PX的锁
+type RedisLocker struct {
+	client redis.UniversalClient
+}
+
+func NewRedisLocker(client redis.UniversalClient) *RedisLocker {
+	return &RedisLocker{client: client}
+}
+
+func (locker *RedisLocker) TryLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	ok, err := locker.client.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		return true, nil
+	}
+
+	// 已经持有就续约
+	renewed, err := redisRenewScript.Run(ctx, locker.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return renewed == 1, nil
+}
+
+func (locker *RedisLocker) Unlock(ctx context.Context, key, owner string) error {
+	return redisUnlockScript.Run(ctx, locker.client, []string{key}, owner).Err()
+}
@@ -0,0 +1,301 @@
+// Package espressotest 模块和处理函数的测试工具，每个Harness有自己的mvc、模块管理器、事件和定时器，不启动App和端口
+package espressotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"espresso/pkg/config"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/events"
+	"espresso/pkg/modules"
+	"espresso/pkg/mvc"
+	"espresso/pkg/panics"
+	"espresso/pkg/protocol"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type options struct {
+	modules  []modules.Module
+	logger   *minilog.MiniLog
+	registry *prometheus.Registry
+	// Server 挂上http的metric中间件
+	httpMetric bool
+	config     *config.Config
+	plugins    []mvc.Plugin
+	contexts   []func(ctx context.Context) context.Context
+	err        error
+}
+
+type Option func(opts *options)
+
+// Modules 安装的模块，按依赖顺序初始化
+func Modules(installs ...modules.Module) Option {
+	return func(opts *options) {
+		opts.modules = append(opts.modules, installs...)
+	}
+}
+
+// Registered 从模块目录新建实例安装，按uid挑选，每个测试拿到的都是新实例
+func Registered(uids ...string) Option {
+	return func(opts *options) {
+		installs, err := modules.FromCatalog(uids...)
+		if err != nil {
+			opts.err = err
+			return
+		}
+		opts.modules = append(opts.modules, installs...)
+	}
+}
+
+// Logger 默认输出到t.Log
+func Logger(logger *minilog.MiniLog) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+// Registry 默认新建一个
+func Registry(registry *prometheus.Registry) Option {
+	return func(opts *options) {
+		opts.registry = registry
+	}
+}
+
+// HttpMetric Server 挂上和App一样的http metric中间件，默认不挂；gin-prom的中间件在并发请求下有数据竞争，-race 时不要打开
+func HttpMetric() Option {
+	return func(opts *options) {
+		opts.httpMetric = true
+	}
+}
+
+// Config 模块配置，默认只读环境变量
+func Config(cfg *config.Config) Option {
+	return func(opts *options) {
+		opts.config = cfg
+	}
+}
+
+// Plugins 模块调用插件
+func Plugins(plugins ...mvc.Plugin) Option {
+	return func(opts *options) {
+		opts.plugins = append(opts.plugins, plugins...)
+	}
+}
+
+// ModuleContext 注入给模块和处理函数的context
+func ModuleContext(f ...func(ctx context.Context) context.Context) Option {
+	return func(opts *options) {
+		opts.contexts = append(opts.contexts, f...)
+	}
+}
+
+// Event 发布的事件，Args是发布时的参数，Payload是最后一个参数
+type Event struct {
+	Name    string
+	Args    []any
+	Payload any
+}
+
+// Result 一次调用的结果
+type Result struct {
+	Code int32 // 协议code，和http回复里的code一致
+	Msg  string
+	Err  error // 处理函数返回的错误或者崩溃，崩溃时是*panics.Panic
+}
+
+type Harness struct {
+	t        testing.TB
+	opts     *options
+	mvc      *mvc.Mvc
+	panics   *panics.Panics
+	ctx      context.Context
+	requests int64
+
+	events  []Event
+	eventsM sync.Mutex
+	eventsC chan struct{}
+
+	server     *httptest.Server
+	serverOnce sync.Once
+	closeOnce  sync.Once
+}
+
+// New 安装模块并初始化，测试结束时模块按依赖顺序退出
+func New(t testing.TB, applyOptions ...Option) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		t:       t,
+		opts:    &options{},
+		eventsC: make(chan struct{}),
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(h.opts)
+	}
+	if h.opts.err != nil {
+		t.Fatalf("espressotest: %v", h.opts.err)
+	}
+
+	if h.opts.logger == nil {
+		h.opts.logger = &minilog.MiniLog{Logger: zaptest.NewLogger(t)}
+	}
+	if h.opts.registry == nil {
+		h.opts.registry = prometheus.NewRegistry()
+	}
+
+	h.mvc = mvc.New()
+	h.mvc.SetPlugins(h.opts.plugins...)
+	h.mvc.Observe(h.record)
+	for _, module := range h.opts.modules {
+		h.mvc.Modules().Register(module)
+	}
+
+	// 自己的崩溃记录，不和其他测试、App共享
+	h.panics = panics.New()
+	h.panics.SetLogger(h.opts.logger)
+
+	ctx := context.Background()
+	ctx = ctxhelper.InjectLogger(ctx, h.opts.logger)
+	ctx = ctxhelper.InjectRegistry(ctx, h.opts.registry)
+	if h.opts.config != nil {
+		ctx = ctxhelper.InjectConfig(ctx, h.opts.config)
+	}
+	for _, moduleContext := range h.opts.contexts {
+		ctx = moduleContext(ctx)
+	}
+	h.ctx = panics.Inject(mvc.Inject(ctx, h.mvc), h.panics)
+	h.panics.OnPanic(func(p *panics.Panic) {
+		events.PublishPanic(h.ctx, p)
+	})
+
+	h.mvc.Run(h.ctx)
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+// Mvc 这个Harness的mvc，可以直接订阅事件、触发定时任务
+func (h *Harness) Mvc() *mvc.Mvc {
+	return h.mvc
+}
+
+// Context 带日志、registry、配置、mvc和崩溃记录的context
+func (h *Harness) Context() context.Context {
+	return h.ctx
+}
+
+func (h *Harness) Logger() *minilog.MiniLog {
+	return h.opts.logger
+}
+
+func (h *Harness) Registry() *prometheus.Registry {
+	return h.opts.registry
+}
+
+// Close 关闭http服务，模块退出，测试结束时自动调用
+func (h *Harness) Close() {
+	h.closeOnce.Do(func() {
+		if h.server != nil {
+			h.server.Close()
+		}
+
+		ctx, cancel := context.WithTimeout(h.ctx, 10*time.Second)
+		defer cancel()
+		h.mvc.Stop(ctx)
+		_ = h.panics.Flush(ctx)
+		h.panics.Close()
+	})
+}
+
+// Call 进程内调用，route格式 module::message，request和response是处理函数参数的类型；和http一样发布模块调用的事件
+func (h *Harness) Call(route string, request, response any) Result {
+	h.t.Helper()
+
+	module, message, ok := strings.Cut(route, "::")
+	if !ok {
+		h.t.Fatalf("espressotest: bad route %q, want module::message", route)
+	}
+
+	h.eventsM.Lock()
+	h.requests++
+	requestId := fmt.Sprintf("test-%d", h.requests)
+	h.eventsM.Unlock()
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	ctx = ctxhelper.InjectRequestId(ctx, requestId)
+	ctx = ctxhelper.InjectLogger(ctx, &minilog.MiniLog{Logger: h.opts.logger.GetZap().With(
+		zap.String("requestId", requestId),
+		zap.String("module", module),
+		zap.String("message", message),
+	)})
+
+	startTime := time.Now()
+	events.PublishModuleCallStart(ctx, module, message)
+
+	result := h.call(ctx, module, message, request, response)
+	if errors.Is(result.Err, mvc.ErrorNoSuchMessage) || errors.Is(result.Err, mvc.ErrorMessageMismatch) {
+		h.t.Fatalf("espressotest: %v", result.Err)
+	}
+
+	events.PublishModuleCall(ctx, events.EventModuleCall{
+		Module:   module,
+		Function: message,
+		CostTime: time.Since(startTime).Nanoseconds(),
+		Code:     int(result.Code),
+	})
+
+	return result
+}
+
+func (h *Harness) call(ctx context.Context, module, message string, request, response any) (result Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			p := h.panics.Capture(panics.SourceHttp, r, module, message)
+			events.PublishModuleCallPanic(ctx, module, message, p)
+			result = Result{Code: protocol.CodeInternalError, Msg: "internal error", Err: p}
+		}
+	}()
+
+	if err := h.mvc.Call(ctx, module, message, request, response); err != nil {
+		errorResponse := mvc.ErrorResponse(err)
+		return Result{Code: errorResponse.Code, Msg: errorResponse.Msg, Err: err}
+	}
+
+	// 回复里自己带了code
+	var base protocol.BaseResponse
+	if body, err := json.Marshal(response); err == nil && json.Unmarshal(body, &base) == nil {
+		return Result{Code: base.Code, Msg: base.Msg}
+	}
+
+	return Result{Code: protocol.CodeOk}
+}
+
+// MustCall 调用失败则结束测试
+func (h *Harness) MustCall(route string, request, response any) {
+	h.t.Helper()
+
+	if result := h.Call(route, request, response); result.Code != protocol.CodeOk || result.Err != nil {
+		h.t.Fatalf("espressotest: call %s: code %d %s %v", route, result.Code, result.Msg, result.Err)
+	}
+}
+
+// AssertCode 协议code不一致时测试失败
+func (h *Harness) AssertCode(result Result, code int32) {
+	h.t.Helper()
+
+	if result.Code != code {
+		h.t.Errorf("espressotest: code = %d, want %d (msg %q, err %v)", result.Code, code, result.Msg, result.Err)
+	}
+}
@@ -0,0 +1,175 @@
+package espressotest_test
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/espressotest"
+	"espresso/pkg/events"
+	"espresso/pkg/mvc"
+	"espresso/pkg/panics"
+	"espresso/pkg/protocol"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const greeted = "greeter_greeted"
+
+type helloRequest struct {
+	Name string `json:"name" form:"name" binding:"required"`
+}
+
+type helloResponse struct {
+	Content string `json:"content,omitempty"`
+}
+
+// greeter 测试用的模块
+type greeter struct {
+	ctx context.Context
+}
+
+func (module *greeter) ModuleUID() string {
+	return "greeter"
+}
+
+func (module *greeter) ModuleInit(ctx context.Context) error {
+	module.ctx = ctx
+	mvc.Register(ctx, "greeter", "hello", module.Hello)
+	mvc.Register(ctx, "greeter", "fail", module.Fail)
+	mvc.Register(ctx, "greeter", "boom", module.Boom)
+	return nil
+}
+
+func (module *greeter) ModuleExit(ctx context.Context) error {
+	return nil
+}
+
+func (module *greeter) ModuleClean(ctx context.Context) error {
+	return nil
+}
+
+// Hello 回复后在后台发布事件；请求的ctx（*gin.Context）返回后会被复用，后台用模块初始化时的ctx
+func (module *greeter) Hello(ctx context.Context, request *helloRequest, response *helloResponse) error {
+	response.Content = "hello " + request.Name
+	go mvc.Publish(greeted, module.ctx, request.Name)
+	return nil
+}
+
+func (module *greeter) Fail(ctx context.Context, request *helloRequest, response *helloResponse) error {
+	return errors.New("no greeting today")
+}
+
+func (module *greeter) Boom(ctx context.Context, request *helloRequest, response *helloResponse) error {
+	panic("boom")
+}
+
+func TestCall(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	var response helloResponse
+	result := h.Call("greeter::hello", &helloRequest{Name: "espresso"}, &response)
+	h.AssertCode(result, protocol.CodeOk)
+	if result.Err != nil {
+		t.Fatalf("err = %v", result.Err)
+	}
+	if response.Content != "hello espresso" {
+		t.Fatalf("content = %q", response.Content)
+	}
+
+	calls := espressotest.Payloads[events.EventModuleCall](h, events.ModuleCall)
+	if len(calls) != 1 || calls[0].Module != "greeter" || calls[0].Function != "hello" || calls[0].Code != protocol.CodeOk {
+		t.Fatalf("module call events = %+v", calls)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	result := h.Call("greeter::fail", &helloRequest{Name: "espresso"}, &helloResponse{})
+	h.AssertCode(result, protocol.CodeInvalidRequest)
+	if result.Err == nil || result.Msg != "no greeting today" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestCallPanic(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	result := h.Call("greeter::boom", &helloRequest{Name: "espresso"}, &helloResponse{})
+	h.AssertCode(result, protocol.CodeInternalError)
+
+	var p *panics.Panic
+	if !errors.As(result.Err, &p) || p.Message != "boom" || p.Module != "greeter" {
+		t.Fatalf("err = %v", result.Err)
+	}
+	h.AssertEvent(events.ModuleCallPanic)
+
+	// 崩溃记在Harness自己的崩溃记录里，在后台发布
+	event := h.WaitEvent(events.Panic, time.Second)
+	if payload, ok := event.Payload.(events.EventPanic); !ok || payload.Panic != p {
+		t.Fatalf("panic event = %+v", event.Payload)
+	}
+	if groups := panics.FromContext(h.Context()).Groups(); len(groups) != 1 || groups[0].Count != 1 {
+		t.Fatalf("panic groups = %+v", groups)
+	}
+	if groups := panics.Default().Groups(); len(groups) != 0 {
+		t.Fatalf("global panic groups = %+v", groups)
+	}
+}
+
+func TestWaitEvent(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	h.MustCall("greeter::hello", &helloRequest{Name: "espresso"}, &helloResponse{})
+
+	event := h.WaitEvent(greeted, time.Second)
+	if event.Payload != "espresso" {
+		t.Fatalf("payload = %v", event.Payload)
+	}
+	h.AssertNoEvent(events.ModuleCallPanic)
+}
+
+func TestPost(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	var response helloResponse
+	status, result := h.Post("greeter::hello", helloRequest{Name: "http"}, &response)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+	h.AssertCode(result, protocol.CodeOk)
+	if response.Content != "hello http" {
+		t.Fatalf("content = %q", response.Content)
+	}
+	h.WaitEvent(greeted, time.Second)
+
+	// 和App一样按binding校验参数
+	_, result = h.Post("greeter::hello", map[string]string{}, nil)
+	h.AssertCode(result, protocol.CodeInvalidRequest)
+
+	// 崩溃转成500和内部错误
+	status, result = h.Post("greeter::boom", helloRequest{Name: "http"}, nil)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("status = %d", status)
+	}
+	h.AssertCode(result, protocol.CodeInternalError)
+	h.WaitEvent(events.Panic, time.Second)
+}
+
+func TestServer(t *testing.T) {
+	h := espressotest.New(t, espressotest.Modules(&greeter{}))
+
+	server := h.Server()
+	if server != h.Server() {
+		t.Fatal("Server should return the same server")
+	}
+
+	response, err := http.Get(server.URL + "/health/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", response.StatusCode)
+	}
+}
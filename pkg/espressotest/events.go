@@ -0,0 +1,103 @@
+package espressotest
+
+import (
+	"time"
+)
+
+func (h *Harness) record(event string, args []any) {
+	recorded := Event{Name: event, Args: append([]any(nil), args...)}
+	if len(args) != 0 {
+		recorded.Payload = args[len(args)-1]
+	}
+
+	h.eventsM.Lock()
+	h.events = append(h.events, recorded)
+	close(h.eventsC)
+	h.eventsC = make(chan struct{})
+	h.eventsM.Unlock()
+}
+
+// Events 发布过的事件，name为空则全部
+func (h *Harness) Events(name string) []Event {
+	h.eventsM.Lock()
+	defer h.eventsM.Unlock()
+
+	var events []Event
+	for _, event := range h.events {
+		if name == "" || event.Name == name {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// ResetEvents 清空记录的事件
+func (h *Harness) ResetEvents() {
+	h.eventsM.Lock()
+	defer h.eventsM.Unlock()
+
+	h.events = nil
+}
+
+// AssertEvent 没有发布过时测试失败，返回最后一次
+func (h *Harness) AssertEvent(name string) Event {
+	h.t.Helper()
+
+	events := h.Events(name)
+	if len(events) == 0 {
+		h.t.Errorf("espressotest: event %s not published", name)
+		return Event{}
+	}
+
+	return events[len(events)-1]
+}
+
+// AssertNoEvent 发布过时测试失败
+func (h *Harness) AssertNoEvent(name string) {
+	h.t.Helper()
+
+	if events := h.Events(name); len(events) != 0 {
+		h.t.Errorf("espressotest: event %s published %d times", name, len(events))
+	}
+}
+
+// WaitEvent 等待在后台发布的事件，比如慢调用，超时后测试失败
+func (h *Harness) WaitEvent(name string, timeout time.Duration) Event {
+	h.t.Helper()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		h.eventsM.Lock()
+		for index := len(h.events) - 1; index >= 0; index-- {
+			if h.events[index].Name == name {
+				event := h.events[index]
+				h.eventsM.Unlock()
+				return event
+			}
+		}
+		changed := h.eventsC
+		h.eventsM.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			h.t.Errorf("espressotest: event %s not published in %s", name, timeout)
+			return Event{}
+		}
+	}
+}
+
+// Payloads 事件里类型为T的最后一个参数，比如 Payloads[events.EventModuleCall](h, events.ModuleCall)
+func Payloads[T any](h *Harness, name string) []T {
+	var payloads []T
+	for _, event := range h.Events(name) {
+		if payload, ok := event.Payload.(T); ok {
+			payloads = append(payloads, payload)
+		}
+	}
+
+	return payloads
+}
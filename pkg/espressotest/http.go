@@ -0,0 +1,81 @@
+package espressotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/mvc"
+	"espresso/pkg/network"
+	"espresso/pkg/panics"
+	"espresso/pkg/protocol"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Server 和App一样的http中间件（请求id、recover、模块调用事件、日志），跑在httptest上，测试结束时关闭；metric中间件用 HttpMetric 打开
+func (h *Harness) Server() *httptest.Server {
+	h.serverOnce.Do(func() {
+		networkOptions := []network.Option{
+			network.Logger(h.opts.logger),
+			network.Http("", h.mvc.NewHttp()),
+			network.RequestContext(func(ctx context.Context) context.Context {
+				ctx = mvc.Inject(ctx, h.mvc)
+				ctx = panics.Inject(ctx, h.panics)
+				ctx = ctxhelper.InjectRegistry(ctx, h.opts.registry)
+				if h.opts.config != nil {
+					ctx = ctxhelper.InjectConfig(ctx, h.opts.config)
+				}
+				return ctx
+			}),
+			network.ModuleContext(h.opts.contexts...),
+		}
+		if h.opts.httpMetric {
+			networkOptions = append(networkOptions, network.Metric(h.opts.registry))
+		}
+
+		handler := network.New(networkOptions...).Handler()
+
+		h.server = httptest.NewServer(handler)
+	})
+
+	return h.server
+}
+
+// Post 通过http调用，route格式 module::message，request编码成json，回复解码到response，返回http状态码和协议code
+func (h *Harness) Post(route string, request, response any) (int, Result) {
+	h.t.Helper()
+
+	module, message, ok := strings.Cut(route, "::")
+	if !ok {
+		h.t.Fatalf("espressotest: bad route %q, want module::message", route)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		h.t.Fatalf("espressotest: encode request: %v", err)
+	}
+
+	reply, err := http.Post(h.Server().URL+"/daydream/"+module+"/"+message, "application/json", bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatalf("espressotest: post %s: %v", route, err)
+	}
+	defer reply.Body.Close()
+
+	replyBody, err := io.ReadAll(reply.Body)
+	if err != nil {
+		h.t.Fatalf("espressotest: read reply: %v", err)
+	}
+
+	var base protocol.BaseResponse
+	_ = json.Unmarshal(replyBody, &base)
+	if response != nil && len(replyBody) != 0 {
+		if err := json.Unmarshal(replyBody, response); err != nil {
+			h.t.Fatalf("espressotest: decode reply %s: %v", replyBody, err)
+		}
+	}
+
+	return reply.StatusCode, Result{Code: base.Code, Msg: base.Msg}
+}
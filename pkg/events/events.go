@@ -2,31 +2,89 @@ package events
 
 import (
 	"context"
+	"errors"
 	"espresso/pkg/mvc"
-	"time"
+	"espresso/pkg/panics"
 )
 
 const (
-	ModuleCall      = "event_module_call"
-	ModuleCallPanic = "event_module_call_panic"
+	ModuleCallStart   = "event_module_call_start"
+	ModuleCall        = "event_module_call"
+	ModuleCallSlow    = "event_module_call_slow"
+	ModuleCallPanic   = "event_module_call_panic"
+	ModuleReconfigure = "event_module_reconfigure"
+	Panic             = "event_panic"
 )
 
-type EventModuleCall struct {
+type EventModuleCallStart struct {
 	Module   string
 	Function string
-	CostTime int64
 }
 
-func PublishModuleCall(ctx context.Context, m, f string, startTime int64) {
-	mvc.Publish(ModuleCall, ctx, EventModuleCall{Module: m, Function: f, CostTime: time.Now().UnixNano() - startTime})
+func PublishModuleCallStart(ctx context.Context, m, f string) {
+	mvc.Publish(ModuleCallStart, ctx, EventModuleCallStart{Module: m, Function: f})
 }
 
-type EventModuleCallPanic struct {
+type EventModuleCall struct {
+	Module       string
+	Function     string
+	CostTime     int64 // 纳秒
+	Code         int   // 协议code
+	RequestSize  int64
+	ResponseSize int64
+}
+
+func PublishModuleCall(ctx context.Context, event EventModuleCall) {
+	mvc.Publish(ModuleCall, ctx, event)
+}
+
+// EventModuleCallSlow 慢调用，由metric模块按配置的耗时判断
+type EventModuleCallSlow struct {
 	Module   string
 	Function string
-	Error    error
+	CostTime int64 // 纳秒
+	SlowTime int64 // 纳秒
+}
+
+func PublishModuleCallSlow(ctx context.Context, event EventModuleCallSlow) {
+	mvc.Publish(ModuleCallSlow, ctx, event)
+}
+
+type EventModuleCallPanic struct {
+	Module      string
+	Function    string
+	Error       error
+	Fingerprint string // err是*panics.Panic时才有
+	Stack       string
 }
 
 func PublishModuleCallPanic(ctx context.Context, m, f string, err error) {
-	mvc.Publish(ModuleCallPanic, ctx, EventModuleCallPanic{Module: m, Function: f, Error: err})
+	event := EventModuleCallPanic{Module: m, Function: f, Error: err}
+
+	var p *panics.Panic
+	if errors.As(err, &p) {
+		event.Fingerprint = p.Fingerprint
+		event.Stack = p.Stack
+	}
+
+	mvc.Publish(ModuleCallPanic, ctx, event)
+}
+
+// EventPanic 任意地方的崩溃，包括http、协程、模块生命周期、定时器、任务和停机，在后台发布
+type EventPanic struct {
+	Panic *panics.Panic
+}
+
+func PublishPanic(ctx context.Context, p *panics.Panic) {
+	mvc.Publish(Panic, ctx, EventPanic{Panic: p})
+}
+
+type EventModuleReconfigure struct {
+	Module string
+	Old    any
+	New    any
+}
+
+func PublishModuleReconfigure(ctx context.Context, m string, old, new any) {
+	mvc.Publish(ModuleReconfigure, ctx, EventModuleReconfigure{Module: m, Old: old, New: new})
 }
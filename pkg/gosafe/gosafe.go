@@ -2,15 +2,16 @@ package gosafe
 
 import (
 	"context"
+	"espresso/pkg/panics"
 	"fmt"
 	"github.com/pkg/errors"
 )
 
 var ErrorGOSafePanic = errors.New("go safe panic")
 
-// GoSafe 安全协程执行，panic自动清理
-func GoSafe(ctx context.Context, fn func(context.Context), beforeExit func(context.Context, error), cleanups ...func(context.Context)) chan<- error {
-	panicChan := make(chan<- error, 1)
+// GoSafe 安全协程执行，panic自动清理，返回的chan在协程结束后收到崩溃错误（没有崩溃时为nil）
+func GoSafe(ctx context.Context, fn func(context.Context), beforeExit func(context.Context, error), cleanups ...func(context.Context)) <-chan error {
+	panicChan := make(chan error, 1)
 
 	go func() {
 		defer func() {
@@ -30,7 +31,7 @@ func GoSafe(ctx context.Context, fn func(context.Context), beforeExit func(conte
 			r := recover()
 			var err error
 			if r != nil {
-				err = fmt.Errorf("%w reason: %v", ErrorGOSafePanic, r)
+				err = fmt.Errorf("%w reason: %w", ErrorGOSafePanic, panics.FromContext(ctx).Capture(panics.SourceGoSafe, r, "", ""))
 			}
 
 			// 退出前通知
@@ -0,0 +1,197 @@
+package gosafe
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/panics"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+var (
+	runningMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gosafe",
+		Name:      "running",
+		Help:      "运行中的协程数",
+	}, []string{"group", "name"})
+
+	finishedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gosafe",
+		Name:      "finished",
+		Help:      "结束的协程数，result是ok、error或者panic",
+	}, []string{"group", "name", "result"})
+
+	durationMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gosafe",
+		Name:      "duration_seconds",
+		Help:      "协程运行耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"group", "name"})
+)
+
+// RegisterCollectors 把协程组的metric加到registry，已经注册过的跳过
+func RegisterCollectors(registry prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{runningMetric, finishedMetric, durationMetric} {
+		if err := registry.Register(collector); err != nil {
+			var registered prometheus.AlreadyRegisteredError
+			if errors.As(err, &registered) {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+type groupOptions struct {
+	name      string
+	limit     int
+	keepGoing bool
+}
+
+type GroupOption func(opts *groupOptions)
+
+// Name 组名，metric的group标签和崩溃的模块名
+func Name(name string) GroupOption {
+	return func(opts *groupOptions) {
+		opts.name = name
+	}
+}
+
+// Limit 最多同时运行的协程数，<=0不限制
+func Limit(limit int) GroupOption {
+	return func(opts *groupOptions) {
+		opts.limit = limit
+	}
+}
+
+// KeepGoing 出错后不取消其他协程
+func KeepGoing() GroupOption {
+	return func(opts *groupOptions) {
+		opts.keepGoing = true
+	}
+}
+
+// Group 协程组，限制并发数，第一个错误时取消ctx，崩溃转成带调用栈的错误，Wait返回全部错误
+type Group struct {
+	opts   *groupOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	wait   sync.WaitGroup
+
+	errs  []error
+	errsM sync.Mutex
+}
+
+// NewGroup 返回的ctx在第一个错误或者Wait返回后取消
+func NewGroup(ctx context.Context, applyOptions ...GroupOption) (*Group, context.Context) {
+	group := &Group{
+		opts: &groupOptions{name: "default"},
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(group.opts)
+	}
+
+	if group.opts.limit > 0 {
+		group.slots = make(chan struct{}, group.opts.limit)
+	}
+	group.ctx, group.cancel = context.WithCancel(ctx)
+
+	return group, group.ctx
+}
+
+// Go 启动一个协程，name是metric的name标签，应该是固定的几个值；达到并发上限时阻塞，等待时ctx取消则不再启动
+func (group *Group) Go(name string, fn func(ctx context.Context) error) {
+	if group.slots != nil {
+		select {
+		case group.slots <- struct{}{}:
+		case <-group.ctx.Done():
+			group.fail(name, group.ctx.Err())
+			return
+		}
+	}
+
+	group.start(name, fn)
+}
+
+// TryGo 达到并发上限时不启动，返回false
+func (group *Group) TryGo(name string, fn func(ctx context.Context) error) bool {
+	if group.slots != nil {
+		select {
+		case group.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	group.start(name, fn)
+	return true
+}
+
+// Wait 等待全部协程结束，返回全部错误，每个错误带上协程名
+func (group *Group) Wait() error {
+	group.wait.Wait()
+	group.cancel()
+
+	group.errsM.Lock()
+	defer group.errsM.Unlock()
+
+	return errors.Join(group.errs...)
+}
+
+func (group *Group) start(name string, fn func(ctx context.Context) error) {
+	group.wait.Add(1)
+	go func() {
+		defer group.wait.Done()
+		if group.slots != nil {
+			defer func() { <-group.slots }()
+		}
+
+		if err := group.run(name, fn); err != nil {
+			group.fail(name, err)
+		}
+	}()
+}
+
+func (group *Group) run(name string, fn func(ctx context.Context) error) (err error) {
+	startTime := time.Now()
+	running := runningMetric.WithLabelValues(group.opts.name, name)
+	running.Inc()
+
+	defer func() {
+		result := "ok"
+		if r := recover(); r != nil {
+			result = "panic"
+			err = fmt.Errorf("%w reason: %w", ErrorGOSafePanic, panics.FromContext(group.ctx).Capture(panics.SourceGoSafe, r, group.opts.name, name))
+		} else if err != nil {
+			result = "error"
+		}
+
+		running.Dec()
+		finishedMetric.WithLabelValues(group.opts.name, name, result).Inc()
+		durationMetric.WithLabelValues(group.opts.name, name).Observe(time.Since(startTime).Seconds())
+	}()
+
+	// profile里可以按协程名区分
+	pprof.Do(group.ctx, pprof.Labels("group", group.opts.name, "goroutine", name), func(ctx context.Context) {
+		err = fn(ctx)
+	})
+
+	return err
+}
+
+func (group *Group) fail(name string, err error) {
+	group.errsM.Lock()
+	group.errs = append(group.errs, fmt.Errorf("%s: %w", name, err))
+	group.errsM.Unlock()
+
+	if !group.opts.keepGoing {
+		group.cancel()
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// 每个2的幂区间分成subBuckets个线性小桶，相对误差小于1/subBuckets，类似HdrHistogram的2位有效数字
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+	maxLatency    = time.Hour
+)
+
+// Histogram 记录微秒级延迟的HDR直方图，不加锁，每个压测协程一个，结束后合并
+type Histogram struct {
+	counts []int64
+	total  int64
+	min    int64
+	max    int64
+	sum    float64
+	sumSq  float64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]int64, bucketIndex(maxLatency.Microseconds())+1),
+		min:    math.MaxInt64,
+	}
+}
+
+// bucketIndex 小于subBuckets的值一个值一个桶，之后每个2的幂区间subBuckets/2个桶
+func bucketIndex(value int64) int {
+	if value < subBuckets {
+		return int(value)
+	}
+
+	exponent := bits.Len64(uint64(value)) - subBucketBits
+	sub := int(value >> exponent)
+	return exponent*subBuckets/2 + sub
+}
+
+// bucketValue 桶的上界
+func bucketValue(index int) int64 {
+	if index < subBuckets {
+		return int64(index)
+	}
+
+	exponent := (index - subBuckets/2) / (subBuckets / 2)
+	sub := index - exponent*subBuckets/2
+	return (int64(sub)+1)<<exponent - 1
+}
+
+// Record 记录一次延迟，超过1小时按1小时算
+func (h *Histogram) Record(latency time.Duration) {
+	value := latency.Microseconds()
+	if value < 0 {
+		value = 0
+	}
+	if value > maxLatency.Microseconds() {
+		value = maxLatency.Microseconds()
+	}
+
+	h.counts[bucketIndex(value)]++
+	h.total++
+	h.sum += float64(value)
+	h.sumSq += float64(value) * float64(value)
+	if value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Merge 合并其他协程的直方图
+func (h *Histogram) Merge(other *Histogram) {
+	for index, count := range other.counts {
+		h.counts[index] += count
+	}
+	h.total += other.total
+	h.sum += other.sum
+	h.sumSq += other.sumSq
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count 记录的次数
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// ValueAt 百分位的延迟，percentile取值0-100
+func (h *Histogram) ValueAt(percentile float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	target := int64(math.Ceil(percentile / 100 * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen int64
+	for index, count := range h.counts {
+		seen += count
+		if seen >= target {
+			value := bucketValue(index)
+			if value > h.max {
+				value = h.max
+			}
+			return time.Duration(value) * time.Microsecond
+		}
+	}
+
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Min 最小延迟
+func (h *Histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.min) * time.Microsecond
+}
+
+// Max 最大延迟
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Mean 平均延迟
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/float64(h.total)) * time.Microsecond
+}
+
+// StdDev 延迟的标准差
+func (h *Histogram) StdDev() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	mean := h.sum / float64(h.total)
+	variance := h.sumSq/float64(h.total) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance)) * time.Microsecond
+}
+
+// Distribution HdrHistogram格式的分布，0到50%分ticks段，之后每过剩下的一半刻度减半
+func (h *Histogram) Distribution(ticks int) []Point {
+	if h.total == 0 {
+		return nil
+	}
+	if ticks <= 0 {
+		ticks = 5
+	}
+
+	var points []Point
+	var seen int64
+	percentile := 0.0
+	step := 50.0 / float64(ticks)
+	for index, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		seen += count
+		reached := float64(seen) / float64(h.total) * 100
+
+		// 跨过的刻度都用这个桶的值，最后一个桶只输出一次
+		for percentile <= reached {
+			points = append(points, h.point(index, seen, percentile))
+			if seen == h.total {
+				break
+			}
+
+			half := 1.0
+			for 100-percentile <= 50/half {
+				half *= 2
+			}
+			percentile += step / half
+		}
+	}
+
+	last := h.point(bucketIndex(h.max), h.total, 100)
+	return append(points, last)
+}
+
+func (h *Histogram) point(index int, seen int64, percentile float64) Point {
+	value := bucketValue(index)
+	if value > h.max {
+		value = h.max
+	}
+
+	point := Point{
+		Latency:    time.Duration(value) * time.Microsecond,
+		Percentile: percentile,
+		Count:      seen,
+	}
+	if percentile < 100 {
+		point.InvertedPercentile = 1 / (1 - percentile/100)
+	}
+	return point
+}
+
+// Point 分布里的一个点，对应HdrHistogram输出的 Value Percentile TotalCount 1/(1-Percentile)
+type Point struct {
+	Latency            time.Duration `json:"latency"`
+	Percentile         float64       `json:"percentile"`
+	Count              int64         `json:"count"`
+	InvertedPercentile float64       `json:"invertedPercentile,omitempty"`
+}
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+var (
+	ErrorNoTarget   = errors.New("no target")
+	ErrorBadRoute   = errors.New("bad route")
+	ErrorBadPayload = errors.New("bad payload template")
+)
+
+type options struct {
+	target      string
+	handler     http.Handler
+	client      *http.Client
+	route       string
+	method      string
+	headers     http.Header
+	payloads    []string
+	concurrency int
+	rate        float64
+	duration    time.Duration
+	requests    int64
+	timeout     time.Duration
+}
+
+type Option func(opts *options)
+
+// Target 服务地址，比如 http://127.0.0.1:8080
+func Target(url string) Option {
+	return func(opts *options) {
+		opts.target = strings.TrimRight(url, "/")
+	}
+}
+
+// Handler 进程内压测，直接调用http处理，不走网络，比如App.Handler()
+func Handler(handler http.Handler) Option {
+	return func(opts *options) {
+		opts.handler = handler
+	}
+}
+
+// Client 自定义http客户端，默认按并发数保持连接
+func Client(client *http.Client) Option {
+	return func(opts *options) {
+		opts.client = client
+	}
+}
+
+// Route 压测的消息，格式 module::message，请求 /daydream/:module/:message
+func Route(route string) Option {
+	return func(opts *options) {
+		opts.route = route
+	}
+}
+
+// Method 请求方法，默认POST；GET时请求内容是query，比如 content=hi {{.Seq}}，按key=value编码后放在?后面
+func Method(method string) Option {
+	return func(opts *options) {
+		opts.method = strings.ToUpper(method)
+	}
+}
+
+// Header 请求header
+func Header(key, value string) Option {
+	return func(opts *options) {
+		opts.headers.Add(key, value)
+	}
+}
+
+// Payload 请求内容的模板，多个时轮流使用，比如 {"content":"hi {{.Seq}} {{randString 8}}"}
+func Payload(templates ...string) Option {
+	return func(opts *options) {
+		opts.payloads = append(opts.payloads, templates...)
+	}
+}
+
+// Concurrency 并发数，默认10
+func Concurrency(concurrency int) Option {
+	return func(opts *options) {
+		opts.concurrency = concurrency
+	}
+}
+
+// Rate 每秒请求数，0不限速；限速时延迟从计划发送的时间算起，服务变慢时排队的时间也算进去
+func Rate(rate float64) Option {
+	return func(opts *options) {
+		opts.rate = rate
+	}
+}
+
+// Duration 压测时长，默认10秒，0只按请求数
+func Duration(duration time.Duration) Option {
+	return func(opts *options) {
+		opts.duration = duration
+	}
+}
+
+// Requests 最多发送的请求数，0只按时长
+func Requests(requests int64) Option {
+	return func(opts *options) {
+		opts.requests = requests
+	}
+}
+
+// Timeout 单个请求的超时，默认5秒
+func Timeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = timeout
+	}
+}
+
+// Data 请求模板里可以用的数据
+type Data struct {
+	Seq    int64 // 请求序号，从1开始
+	Worker int   // 压测协程序号
+	Time   int64 // 发送时的unix纳秒
+}
+
+// Runner 压测，一个Runner只Run一次
+type Runner struct {
+	opts      *options
+	path      string
+	templates []*template.Template
+	seq       atomic.Int64
+	start     time.Time
+}
+
+// New 检查选项和模板
+func New(applyOptions ...Option) (*Runner, error) {
+	opts := &options{
+		method:      http.MethodPost,
+		headers:     make(http.Header),
+		concurrency: 10,
+		duration:    10 * time.Second,
+		timeout:     5 * time.Second,
+	}
+	for _, applyOption := range applyOptions {
+		applyOption(opts)
+	}
+
+	if opts.target == "" && opts.handler == nil {
+		return nil, ErrorNoTarget
+	}
+	if opts.concurrency <= 0 {
+		opts.concurrency = 1
+	}
+	if opts.duration <= 0 && opts.requests <= 0 {
+		opts.duration = 10 * time.Second
+	}
+	if opts.headers.Get("Content-Type") == "" {
+		opts.headers.Set("Content-Type", "application/json")
+	}
+	if opts.client == nil {
+		opts.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        opts.concurrency,
+				MaxIdleConnsPerHost: opts.concurrency,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+
+	module, message, ok := strings.Cut(opts.route, "::")
+	if !ok || module == "" || message == "" {
+		return nil, fmt.Errorf("%w: %q, want module::message", ErrorBadRoute, opts.route)
+	}
+
+	runner := &Runner{
+		opts: opts,
+		path: "/daydream/" + module + "/" + message,
+	}
+
+	if len(opts.payloads) == 0 {
+		opts.payloads = []string{"{}"}
+	}
+	for index, payload := range opts.payloads {
+		tmpl, err := template.New(fmt.Sprintf("payload-%d", index)).Funcs(templateFuncs()).Parse(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrorBadPayload, err)
+		}
+		runner.templates = append(runner.templates, tmpl)
+	}
+
+	return runner, nil
+}
+
+// templateFuncs 解析模板用的占位函数，运行时每个协程换成绑定自己随机数的workerFuncs，避免全局锁
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"randInt":    func(min, max int) int { return min },
+		"randString": func(n int) string { return "" },
+		"pick":       func(values ...string) string { return "" },
+	}
+}
+
+// workerFuncs 绑定协程自己的随机数
+func workerFuncs(random *rand.Rand) template.FuncMap {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	return template.FuncMap{
+		"randInt": func(min, max int) int {
+			if max <= min {
+				return min
+			}
+			return min + random.Intn(max-min+1)
+		},
+		"randString": func(n int) string {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = letters[random.Intn(len(letters))]
+			}
+			return string(b)
+		},
+		"pick": func(values ...string) string {
+			if len(values) == 0 {
+				return ""
+			}
+			return values[random.Intn(len(values))]
+		},
+	}
+}
+
+// worker 一个压测协程的状态和统计
+type worker struct {
+	id        int
+	templates []*template.Template
+	buffer    bytes.Buffer
+	result    *Report
+}
+
+// Run 开始压测，到时长、请求数或者ctx取消时结束，返回统计
+func (runner *Runner) Run(ctx context.Context) *Report {
+	if runner.opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runner.opts.duration)
+		defer cancel()
+	}
+
+	runner.start = time.Now()
+	workers := make([]*worker, runner.opts.concurrency)
+	var wg sync.WaitGroup
+	for index := range workers {
+		w := &worker{id: index, result: newReport()}
+		random := rand.New(rand.NewSource(runner.start.UnixNano() + int64(index)))
+		for _, tmpl := range runner.templates {
+			clone := template.Must(tmpl.Clone())
+			w.templates = append(w.templates, clone.Funcs(workerFuncs(random)))
+		}
+		workers[index] = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner.work(ctx, w)
+		}()
+	}
+	wg.Wait()
+
+	report := newReport()
+	for _, w := range workers {
+		report.merge(w.result)
+	}
+	report.finish(runner, time.Since(runner.start))
+
+	return report
+}
+
+func (runner *Runner) work(ctx context.Context, w *worker) {
+	for {
+		seq := runner.seq.Add(1)
+		if runner.opts.requests > 0 && seq > runner.opts.requests {
+			return
+		}
+
+		// 限速时按序号算出计划发送的时间
+		scheduled := time.Now()
+		if runner.opts.rate > 0 {
+			scheduled = runner.start.Add(time.Duration(float64(seq-1) / runner.opts.rate * float64(time.Second)))
+			if wait := time.Until(scheduled); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		tmpl := w.templates[int(seq-1)%len(w.templates)]
+		w.buffer.Reset()
+		if err := tmpl.Execute(&w.buffer, Data{Seq: seq, Worker: w.id, Time: time.Now().UnixNano()}); err != nil {
+			w.result.recordError(fmt.Errorf("payload: %w", err))
+			continue
+		}
+
+		status, code, err := runner.do(ctx, w.buffer.Bytes())
+		if err != nil && ctx.Err() != nil {
+			// 结束时被取消的请求不算
+			return
+		}
+		w.result.record(time.Since(scheduled), status, code, err)
+	}
+}
+
+// noCode 回复里没有协议code，比如网关返回的错误页
+const noCode = -1
+
+// do 发送一个请求，返回http状态码和回复里的协议code
+func (runner *Runner) do(ctx context.Context, payload []byte) (int, int32, error) {
+	ctx, cancel := context.WithTimeout(ctx, runner.opts.timeout)
+	defer cancel()
+
+	target := runner.opts.target + runner.path
+	var body io.Reader
+	if runner.opts.method == http.MethodGet {
+		// 模板生成的值里可能有空格、&、中文等，解析后重新编码
+		values, err := url.ParseQuery(string(payload))
+		if err != nil {
+			return 0, 0, fmt.Errorf("payload query: %w", err)
+		}
+		target += "?" + values.Encode()
+	} else {
+		body = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, runner.opts.method, target, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	for key, values := range runner.opts.headers {
+		request.Header[key] = values
+	}
+
+	var status int
+	var responseBody []byte
+	if runner.opts.handler != nil {
+		// 进程内直接调用
+		request.RemoteAddr = "127.0.0.1:0"
+		recorder := httptest.NewRecorder()
+		runner.opts.handler.ServeHTTP(recorder, request)
+		status, responseBody = recorder.Code, recorder.Body.Bytes()
+	} else {
+		response, err := runner.opts.client.Do(request)
+		if err != nil {
+			return 0, 0, err
+		}
+		responseBody, err = io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return response.StatusCode, 0, err
+		}
+		status = response.StatusCode
+	}
+
+	// 只解析协议code，不是协议回复时为noCode
+	var base struct {
+		Code *int32 `json:"code"`
+	}
+	if len(responseBody) == 0 || json.Unmarshal(responseBody, &base) != nil || base.Code == nil {
+		return status, noCode, nil
+	}
+
+	return status, *base.Code, nil
+}
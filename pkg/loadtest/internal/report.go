@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"espresso/pkg/protocol"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 不同的错误最多记录多少种，之后都算到other
+const maxErrorKinds = 20
+
+// Latency 延迟统计，json里的单位是纳秒
+type Latency struct {
+	Min    time.Duration `json:"min"`
+	Mean   time.Duration `json:"mean"`
+	StdDev time.Duration `json:"stdDev"`
+	Max    time.Duration `json:"max"`
+	P50    time.Duration `json:"p50"`
+	P75    time.Duration `json:"p75"`
+	P90    time.Duration `json:"p90"`
+	P95    time.Duration `json:"p95"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p999"`
+}
+
+// Report 压测结果；成功是http 200并且协议code为0或者回复里没有code
+type Report struct {
+	Route        string           `json:"route"`
+	Target       string           `json:"target"`
+	Concurrency  int              `json:"concurrency"`
+	Rate         float64          `json:"rate"`
+	Duration     time.Duration    `json:"duration"`
+	Requests     int64            `json:"requests"`
+	Success      int64            `json:"success"`
+	Failures     int64            `json:"failures"`
+	Throughput   float64          `json:"throughput"`
+	Statuses     map[int]int64    `json:"statuses"`
+	Codes        map[int32]int64  `json:"codes"`
+	Errors       map[string]int64 `json:"errors"`
+	Latency      Latency          `json:"latency"`
+	Distribution []Point          `json:"distribution"`
+
+	histogram *Histogram
+}
+
+func newReport() *Report {
+	return &Report{
+		Statuses:  make(map[int]int64),
+		Codes:     make(map[int32]int64),
+		Errors:    make(map[string]int64),
+		histogram: NewHistogram(),
+	}
+}
+
+// record 记录一个请求，网络错误只计数不记延迟
+func (report *Report) record(latency time.Duration, status int, code int32, err error) {
+	report.Requests++
+	if err != nil {
+		report.recordError(err)
+		return
+	}
+
+	report.histogram.Record(latency)
+	report.Statuses[status]++
+	if code != noCode {
+		report.Codes[code]++
+	}
+	if status == http.StatusOK && (code == noCode || code == protocol.CodeOk) {
+		report.Success++
+	} else {
+		report.Failures++
+	}
+}
+
+func (report *Report) recordError(err error) {
+	report.Failures++
+
+	key := errorKind(err)
+	if _, ok := report.Errors[key]; !ok && len(report.Errors) >= maxErrorKinds {
+		key = "other"
+	}
+	report.Errors[key]++
+}
+
+// errorKind 错误归类，去掉url这类每次不同的内容
+func errorKind(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+
+	message := err.Error()
+	if index := strings.LastIndex(message, ": "); index >= 0 {
+		message = message[index+2:]
+	}
+	if len(message) > 100 {
+		message = message[:100]
+	}
+	return message
+}
+
+func (report *Report) merge(other *Report) {
+	report.Requests += other.Requests
+	report.Success += other.Success
+	report.Failures += other.Failures
+	for status, count := range other.Statuses {
+		report.Statuses[status] += count
+	}
+	for code, count := range other.Codes {
+		report.Codes[code] += count
+	}
+	for kind, count := range other.Errors {
+		report.Errors[kind] += count
+	}
+	report.histogram.Merge(other.histogram)
+}
+
+func (report *Report) finish(runner *Runner, elapsed time.Duration) {
+	report.Route = runner.opts.route
+	report.Target = runner.opts.target
+	if runner.opts.handler != nil {
+		report.Target = "in-process"
+	}
+	report.Concurrency = runner.opts.concurrency
+	report.Rate = runner.opts.rate
+	report.Duration = elapsed
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+
+	h := report.histogram
+	report.Latency = Latency{
+		Min:    h.Min(),
+		Mean:   h.Mean(),
+		StdDev: h.StdDev(),
+		Max:    h.Max(),
+		P50:    h.ValueAt(50),
+		P75:    h.ValueAt(75),
+		P90:    h.ValueAt(90),
+		P95:    h.ValueAt(95),
+		P99:    h.ValueAt(99),
+		P999:   h.ValueAt(99.9),
+	}
+	report.Distribution = h.Distribution(5)
+}
+
+// Histogram 全部请求的延迟直方图
+func (report *Report) Histogram() *Histogram {
+	return report.histogram
+}
+
+// WriteJSON 输出json
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteText 输出文本，最后是HdrHistogram格式的延迟分布，可以贴到HdrHistogram的plotter里画图
+func (report *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "route:\t%s\n", report.Route)
+	fmt.Fprintf(tw, "target:\t%s\n", report.Target)
+	rate := "unlimited"
+	if report.Rate > 0 {
+		rate = fmt.Sprintf("%.0f/s", report.Rate)
+	}
+	fmt.Fprintf(tw, "concurrency:\t%d\trate: %s\n", report.Concurrency, rate)
+	fmt.Fprintf(tw, "duration:\t%s\n", report.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "requests:\t%d\tsuccess: %d\tfailures: %d\n", report.Requests, report.Success, report.Failures)
+	fmt.Fprintf(tw, "throughput:\t%.2f req/s\n", report.Throughput)
+
+	fmt.Fprintf(tw, "\nlatency:\n")
+	latency := report.Latency
+	fmt.Fprintf(tw, "  min\t%s\tmean\t%s\tstddev\t%s\tmax\t%s\n", latency.Min, latency.Mean, latency.StdDev, latency.Max)
+	fmt.Fprintf(tw, "  p50\t%s\tp75\t%s\tp90\t%s\tp95\t%s\n", latency.P50, latency.P75, latency.P90, latency.P95)
+	fmt.Fprintf(tw, "  p99\t%s\tp99.9\t%s\n", latency.P99, latency.P999)
+
+	if len(report.Statuses) != 0 {
+		fmt.Fprintf(tw, "\nhttp status:\n")
+		statuses := make([]int, 0, len(report.Statuses))
+		for status := range report.Statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(tw, "  %d\t%d\n", status, report.Statuses[status])
+		}
+	}
+
+	if len(report.Codes) != 0 {
+		fmt.Fprintf(tw, "\nprotocol code:\n")
+		codes := make([]int, 0, len(report.Codes))
+		for code := range report.Codes {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(tw, "  %d\t%d\n", code, report.Codes[int32(code)])
+		}
+	}
+
+	if len(report.Errors) != 0 {
+		fmt.Fprintf(tw, "\nerrors:\n")
+		kinds := make([]string, 0, len(report.Errors))
+		for kind := range report.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(tw, "  %s\t%d\n", kind, report.Errors[kind])
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.Distribution) == 0 {
+		return nil
+	}
+
+	// HdrHistogram的百分位输出，值的单位是毫秒
+	fmt.Fprintf(w, "\n%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	for _, point := range report.Distribution {
+		value := float64(point.Latency) / float64(time.Millisecond)
+		if point.Percentile < 100 {
+			fmt.Fprintf(w, "%12.3f %14.12f %10d %14.2f\n", value, point.Percentile/100, point.Count, point.InvertedPercentile)
+		} else {
+			fmt.Fprintf(w, "%12.3f %14.12f %10d %14s\n", value, point.Percentile/100, point.Count, "inf")
+		}
+	}
+	_, err := fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n#[Max     = %12.3f, Total count    = %12d]\n",
+		float64(latency.Mean)/float64(time.Millisecond), float64(latency.StdDev)/float64(time.Millisecond),
+		float64(latency.Max)/float64(time.Millisecond), report.histogram.Count())
+	return err
+}
@@ -0,0 +1,78 @@
+package loadtest
+
+import (
+	"espresso/pkg/loadtest/internal"
+	"net/http"
+	"time"
+)
+
+type Runner = internal.Runner
+type Option = internal.Option
+type Report = internal.Report
+type Latency = internal.Latency
+type Histogram = internal.Histogram
+type Point = internal.Point
+type Data = internal.Data
+
+var (
+	ErrorNoTarget   = internal.ErrorNoTarget
+	ErrorBadRoute   = internal.ErrorBadRoute
+	ErrorBadPayload = internal.ErrorBadPayload
+)
+
+// Target 服务地址，比如 http://127.0.0.1:8080
+func Target(url string) Option {
+	return internal.Target(url)
+}
+
+// Handler 进程内压测，比如 app.Handler()
+func Handler(handler http.Handler) Option {
+	return internal.Handler(handler)
+}
+
+func Client(client *http.Client) Option {
+	return internal.Client(client)
+}
+
+// Route 压测的消息，格式 module::message
+func Route(route string) Option {
+	return internal.Route(route)
+}
+
+func Method(method string) Option {
+	return internal.Method(method)
+}
+
+func Header(key, value string) Option {
+	return internal.Header(key, value)
+}
+
+// Payload 请求内容的模板，可以用 {{.Seq}}、{{.Worker}}、{{.Time}}、{{randInt 1 100}}、{{randString 8}}、{{pick "a" "b"}}
+func Payload(templates ...string) Option {
+	return internal.Payload(templates...)
+}
+
+func Concurrency(concurrency int) Option {
+	return internal.Concurrency(concurrency)
+}
+
+// Rate 每秒请求数，0不限速
+func Rate(rate float64) Option {
+	return internal.Rate(rate)
+}
+
+func Duration(duration time.Duration) Option {
+	return internal.Duration(duration)
+}
+
+func Requests(requests int64) Option {
+	return internal.Requests(requests)
+}
+
+func Timeout(timeout time.Duration) Option {
+	return internal.Timeout(timeout)
+}
+
+func New(options ...Option) (*Runner, error) {
+	return internal.New(options...)
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"espresso/pkg/protocol"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type setLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// RegisterAdmin 注册模块日志级别的管理接口
+func (levels *Levels) RegisterAdmin(router gin.IRouter) {
+	// 列出模块日志级别
+	router.GET("/log/levels", func(c *gin.Context) {
+		c.JSON(http.StatusOK, struct {
+			protocol.BaseResponse
+			Levels map[string]string `json:"levels"`
+		}{
+			BaseResponse: protocol.BaseResponse{Code: protocol.CodeOk},
+			Levels:       levels.All(),
+		})
+	})
+
+	// 修改模块日志级别
+	router.POST("/log/levels/:module", func(c *gin.Context) {
+		var request setLevelRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: err.Error()})
+			return
+		}
+
+		if err := levels.Set(c.Param("module"), request.Level); err != nil {
+			c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeOk})
+	})
+
+	// 恢复默认
+	router.DELETE("/log/levels/:module", func(c *gin.Context) {
+		levels.Reset(c.Param("module"))
+		c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeOk})
+	})
+}
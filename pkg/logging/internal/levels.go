@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sync"
+)
+
+var (
+	singleInst *Levels = nil
+	once       sync.Once
+	contextKey = ctxhelper.NewKey[*Levels]("core_log_levels")
+)
+
+var (
+	ErrorEmptyModule = errors.New("empty module")
+)
+
+// Levels 按模块设置的日志级别，没有设置的模块不过滤
+type Levels struct {
+	levels map[string]zapcore.Level
+	m      sync.RWMutex
+}
+
+func GetSingleInst() *Levels {
+	if singleInst == nil {
+		once.Do(func() {
+			singleInst = New()
+		})
+	}
+
+	return singleInst
+}
+
+// New 独立的模块日志级别，比如每个App一个
+func New() *Levels {
+	return &Levels{levels: make(map[string]zapcore.Level)}
+}
+
+// Inject 注入模块日志级别
+func Inject(ctx context.Context, levels *Levels) context.Context {
+	return contextKey.Inject(ctx, levels)
+}
+
+// FromContext ctx里的模块日志级别，没有注入则是全局的
+func FromContext(ctx context.Context) *Levels {
+	if ctx != nil {
+		if levels, ok := contextKey.Fetch(ctx); ok && levels != nil {
+			return levels
+		}
+	}
+
+	return GetSingleInst()
+}
+
+// Set 设置模块的日志级别 debug、info、warn、error
+func (levels *Levels) Set(module, level string) error {
+	if module == "" {
+		return ErrorEmptyModule
+	}
+
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	levels.m.Lock()
+	defer levels.m.Unlock()
+	levels.levels[module] = lvl
+
+	return nil
+}
+
+// Reset 取消模块的日志级别
+func (levels *Levels) Reset(module string) {
+	levels.m.Lock()
+	defer levels.m.Unlock()
+
+	delete(levels.levels, module)
+}
+
+// All 全部设置过的模块日志级别
+func (levels *Levels) All() map[string]string {
+	levels.m.RLock()
+	defer levels.m.RUnlock()
+
+	all := make(map[string]string, len(levels.levels))
+	for module, lvl := range levels.levels {
+		all[module] = lvl.String()
+	}
+
+	return all
+}
+
+// Enabled 模块是否输出该级别的日志
+func (levels *Levels) Enabled(module string, lvl zapcore.Level) bool {
+	levels.m.RLock()
+	defer levels.m.RUnlock()
+
+	moduleLevel, ok := levels.levels[module]
+	if !ok {
+		return true
+	}
+
+	return lvl >= moduleLevel
+}
+
+// With 模块的子日志，带上fields，并按模块日志级别过滤；只能在原日志的级别上过滤，不能打开原日志关掉的级别
+func (levels *Levels) With(logger *minilog.MiniLog, module string, fields ...zap.Field) *minilog.MiniLog {
+	if logger == nil || logger.Logger == nil {
+		return logger
+	}
+
+	return &minilog.MiniLog{
+		Logger: logger.Logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return &moduleCore{Core: core, levels: levels, module: module}
+		})).With(fields...),
+	}
+}
+
+// moduleCore 按模块日志级别过滤
+type moduleCore struct {
+	zapcore.Core
+	levels *Levels
+	module string
+}
+
+func (core *moduleCore) Enabled(lvl zapcore.Level) bool {
+	return core.levels.Enabled(core.module, lvl) && core.Core.Enabled(lvl)
+}
+
+func (core *moduleCore) With(fields []zapcore.Field) zapcore.Core {
+	return &moduleCore{Core: core.Core.With(fields), levels: core.levels, module: core.module}
+}
+
+func (core *moduleCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !core.levels.Enabled(core.module, entry.Level) {
+		return checked
+	}
+
+	return core.Core.Check(entry, checked)
+}
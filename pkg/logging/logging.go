@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"context"
+	"espresso/pkg/logging/internal"
+	"github.com/dan-and-dna/minilog"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type ModuleLevels = internal.Levels
+
+// New 独立的模块日志级别，不和全局的共享，比如每个App一个
+func New() *ModuleLevels {
+	return internal.New()
+}
+
+// Default 全局的模块日志级别，ctx里没有注入时用它
+func Default() *ModuleLevels {
+	return internal.GetSingleInst()
+}
+
+// Inject 注入模块日志级别，请求的子日志按它过滤
+func Inject(ctx context.Context, levels *ModuleLevels) context.Context {
+	return internal.Inject(ctx, levels)
+}
+
+// FromContext ctx里的模块日志级别，没有注入则是全局的
+func FromContext(ctx context.Context) *ModuleLevels {
+	return internal.FromContext(ctx)
+}
+
+// 下面的包函数作用于全局的模块日志级别
+
+// SetLevel 设置模块的日志级别 debug、info、warn、error
+func SetLevel(module, level string) error {
+	return internal.GetSingleInst().Set(module, level)
+}
+
+// ResetLevel 取消模块的日志级别
+func ResetLevel(module string) {
+	internal.GetSingleInst().Reset(module)
+}
+
+// Levels 全部设置过的模块日志级别
+func Levels() map[string]string {
+	return internal.GetSingleInst().All()
+}
+
+// With 模块的子日志，带上fields，并按模块日志级别过滤
+func With(logger *minilog.MiniLog, module string, fields ...zap.Field) *minilog.MiniLog {
+	return internal.GetSingleInst().With(logger, module, fields...)
+}
+
+// RegisterAdmin 注册模块日志级别的管理接口
+func RegisterAdmin(router gin.IRouter) {
+	internal.GetSingleInst().RegisterAdmin(router)
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	ErrorNoPushTarget = errors.New("no push target")
+)
+
+type options struct {
+	pushgateway string
+	remoteWrite string
+	job         string
+	instance    string
+	interval    time.Duration
+	gatherer    prometheus.Gatherer
+	client      *http.Client
+	headers     http.Header
+}
+
+type Option func(opts *options)
+
+// Pushgateway 推送到Pushgateway，按job和instance分组覆盖
+func Pushgateway(url string) Option {
+	return func(opts *options) {
+		opts.pushgateway = url
+	}
+}
+
+// RemoteWrite 推送到支持prometheus remote write的地址，比如 http://localhost:9090/api/v1/write
+func RemoteWrite(url string) Option {
+	return func(opts *options) {
+		opts.remoteWrite = url
+	}
+}
+
+// Job job标签，默认espresso
+func Job(job string) Option {
+	return func(opts *options) {
+		opts.job = job
+	}
+}
+
+// Instance instance标签，默认主机名
+func Instance(instance string) Option {
+	return func(opts *options) {
+		opts.instance = instance
+	}
+}
+
+// Interval 推送间隔，默认15秒
+func Interval(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.interval = interval
+	}
+}
+
+// Gatherer 推送的数据来源，一般是app的registry
+func Gatherer(gatherer prometheus.Gatherer) Option {
+	return func(opts *options) {
+		opts.gatherer = gatherer
+	}
+}
+
+// Client 推送用的http客户端，默认10秒超时
+func Client(client *http.Client) Option {
+	return func(opts *options) {
+		opts.client = client
+	}
+}
+
+// Header 推送时带上的http头，比如鉴权
+func Header(key, value string) Option {
+	return func(opts *options) {
+		if opts.headers == nil {
+			opts.headers = make(http.Header)
+		}
+		opts.headers.Add(key, value)
+	}
+}
+
+// Pusher 定时把metric推送出去，适合抓取不到的场景，比如短任务和内网实例
+type Pusher struct {
+	opts *options
+}
+
+func New(applyOptions ...Option) *Pusher {
+	pusher := &Pusher{
+		opts: &options{
+			job:      "espresso",
+			interval: 15 * time.Second,
+			gatherer: prometheus.DefaultGatherer,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		},
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(pusher.opts)
+	}
+
+	if pusher.opts.instance == "" {
+		pusher.opts.instance, _ = os.Hostname()
+	}
+
+	return pusher
+}
+
+// Run 每隔interval推送一次，直到ctx结束；推送失败只记录日志，退出前由调用者再 Push 一次
+func (pusher *Pusher) Run(ctx context.Context) error {
+	logger := ctxhelper.FetchLogger(ctx)
+
+	if pusher.opts.pushgateway == "" && pusher.opts.remoteWrite == "" {
+		logger.Error("service run", zap.Error(ErrorNoPushTarget), zap.String("service", "metricPusher"), zap.Bool("result", false))
+		return ErrorNoPushTarget
+	}
+
+	logger.Info("service run", zap.String("pushgateway", pusher.opts.pushgateway), zap.String("remoteWrite", pusher.opts.remoteWrite),
+		zap.String("job", pusher.opts.job), zap.String("instance", pusher.opts.instance), zap.Duration("interval", pusher.opts.interval),
+		zap.Bool("result", true), zap.String("service", "metricPusher"))
+
+	ticker := time.NewTicker(pusher.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("service exit", zap.String("service", "metricPusher"), zap.Bool("result", true))
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := pusher.Push(ctx); err != nil {
+			logger.Error("push metric", zap.Bool("result", false), zap.Error(err))
+		}
+	}
+}
+
+// Push 马上推送一次
+func (pusher *Pusher) Push(ctx context.Context) error {
+	var errs []error
+
+	if pusher.opts.pushgateway != "" {
+		if err := pusher.pushgateway(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if pusher.opts.remoteWrite != "" {
+		if err := pusher.remoteWrite(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (pusher *Pusher) pushgateway(ctx context.Context) error {
+	p := push.New(pusher.opts.pushgateway, pusher.opts.job).
+		Grouping("instance", pusher.opts.instance).
+		Gatherer(pusher.opts.gatherer).
+		Client(pusher.opts.client)
+	if len(pusher.opts.headers) != 0 {
+		p = p.Header(pusher.opts.headers)
+	}
+
+	return p.PushContext(ctx)
+}
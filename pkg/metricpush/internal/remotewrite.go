@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+type timeSeries struct {
+	labels  []label
+	samples []sample
+}
+
+func (pusher *Pusher) remoteWrite(ctx context.Context) error {
+	families, err := pusher.opts.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	series := toTimeSeries(families, time.Now().UnixMilli(), label{"instance", pusher.opts.instance}, label{"job", pusher.opts.job})
+	body := snappyEncode(encodeWriteRequest(series))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, pusher.opts.remoteWrite, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range pusher.opts.headers {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	response, err := pusher.opts.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("remote write %s: %s %s", pusher.opts.remoteWrite, response.Status, bytes.TrimSpace(message))
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	return nil
+}
+
+// toTimeSeries 展开成remote write的时间序列，summary和histogram按抓取时的格式拆成多个序列
+func toTimeSeries(families []*dto.MetricFamily, timestamp int64, extra ...label) []timeSeries {
+	var series []timeSeries
+
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			ts := timestamp
+			if metric.TimestampMs != nil {
+				ts = metric.GetTimestampMs()
+			}
+
+			labels := make([]label, 0, len(metric.GetLabel())+len(extra)+2)
+			for _, pair := range metric.GetLabel() {
+				labels = append(labels, label{pair.GetName(), pair.GetValue()})
+			}
+
+			add := func(name string, value float64, more ...label) {
+				all := make([]label, 0, len(labels)+len(extra)+len(more)+1)
+				all = append(all, label{"__name__", name})
+				all = append(all, labels...)
+				all = append(all, more...)
+				// 自带的job、instance标签优先
+				for _, l := range extra {
+					if !hasLabel(all, l.name) {
+						all = append(all, l)
+					}
+				}
+				sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+				series = append(series, timeSeries{labels: all, samples: []sample{{value, ts}}})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, quantile.GetValue(), label{"quantile", formatFloat(quantile.GetQuantile())})
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), label{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				add(name+"_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+func hasLabel(labels []label, name string) bool {
+	for _, l := range labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest prometheus.WriteRequest的protobuf编码
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var buf, ts, field []byte
+
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			field = field[:0]
+			field = protowire.AppendTag(field, 1, protowire.BytesType)
+			field = protowire.AppendString(field, l.name)
+			field = protowire.AppendTag(field, 2, protowire.BytesType)
+			field = protowire.AppendString(field, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, field)
+		}
+		for _, sm := range s.samples {
+			field = field[:0]
+			field = protowire.AppendTag(field, 1, protowire.Fixed64Type)
+			field = protowire.AppendFixed64(field, math.Float64bits(sm.value))
+			field = protowire.AppendTag(field, 2, protowire.VarintType)
+			field = protowire.AppendVarint(field, uint64(sm.timestamp))
+
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, field)
+		}
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+
+	return buf
+}
+
+// snappyEncode snappy块格式，只用literal不压缩，任何snappy解码器都能解
+func snappyEncode(src []byte) []byte {
+	dst := protowire.AppendVarint(make([]byte, 0, len(src)+len(src)/65536*3+16), uint64(len(src)))
+
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > 65536 {
+			chunk = chunk[:65536]
+		}
+		src = src[len(chunk):]
+
+		n := len(chunk) - 1
+		if n < 60 {
+			dst = append(dst, byte(n<<2))
+		} else if n < 256 {
+			dst = append(dst, 60<<2, byte(n))
+		} else {
+			dst = append(dst, 61<<2, byte(n), byte(n>>8))
+		}
+		dst = append(dst, chunk...)
+	}
+
+	return dst
+}
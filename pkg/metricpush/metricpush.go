@@ -0,0 +1,59 @@
+package metricpush
+
+import (
+	"espresso/pkg/metricpush/internal"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"time"
+)
+
+type Pusher = internal.Pusher
+type Option = internal.Option
+
+var (
+	ErrorNoPushTarget = internal.ErrorNoPushTarget
+)
+
+// Pushgateway 推送到Pushgateway，按job和instance分组覆盖
+func Pushgateway(url string) Option {
+	return internal.Pushgateway(url)
+}
+
+// RemoteWrite 推送到支持prometheus remote write的地址，比如 http://localhost:9090/api/v1/write
+func RemoteWrite(url string) Option {
+	return internal.RemoteWrite(url)
+}
+
+// Job job标签，默认espresso
+func Job(job string) Option {
+	return internal.Job(job)
+}
+
+// Instance instance标签，默认主机名
+func Instance(instance string) Option {
+	return internal.Instance(instance)
+}
+
+// Interval 推送间隔，默认15秒
+func Interval(interval time.Duration) Option {
+	return internal.Interval(interval)
+}
+
+// Gatherer 推送的数据来源，一般是app的registry
+func Gatherer(gatherer prometheus.Gatherer) Option {
+	return internal.Gatherer(gatherer)
+}
+
+// Client 推送用的http客户端
+func Client(client *http.Client) Option {
+	return internal.Client(client)
+}
+
+// Header 推送时带上的http头，比如鉴权
+func Header(key, value string) Option {
+	return internal.Header(key, value)
+}
+
+func New(options ...Option) *Pusher {
+	return internal.New(options...)
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrorNoSuchModule = errors.New("no such module")
+var ErrorModuleDependsDisabled = errors.New("module depends disabled")
+var ErrorModuleUIDMismatch = errors.New("module uid mismatch")
+
+// catalogEntry 模块目录里的一项，每个App安装时调用factory拿到自己的模块实例
+type catalogEntry struct {
+	uid     string
+	factory func() Module
+}
+
+var (
+	catalog  []catalogEntry
+	catalogM sync.RWMutex
+)
+
+// Provide 把模块加到全局目录，一般在init里调用；登记时不调用factory，App从目录里挑选模块，每个App调用一次factory
+func Provide(uid string, factory func() Module) {
+	if uid == "" {
+		panic(fmt.Errorf("bad uid: %s", uid))
+	}
+
+	entry := catalogEntry{uid: uid, factory: factory}
+
+	catalogM.Lock()
+	for _, provided := range catalog {
+		if provided.uid == uid {
+			catalogM.Unlock()
+			panic(fmt.Errorf("uid dunplicated: %s", uid))
+		}
+	}
+	catalog = append(catalog, entry)
+	catalogM.Unlock()
+
+	// 全局的模块管理器已经建好时补上
+	defaultM.Lock()
+	defer defaultM.Unlock()
+	if modulesSingleInst != nil {
+		module, err := entry.build()
+		if err != nil {
+			panic(err)
+		}
+		modulesSingleInst.Register(module)
+	}
+}
+
+// build 新建模块实例，uid要和登记的一致
+func (entry catalogEntry) build() (Module, error) {
+	module := entry.factory()
+	if uid := module.ModuleUID(); uid != entry.uid {
+		return nil, fmt.Errorf("%w: provided %s, got %s", ErrorModuleUIDMismatch, entry.uid, uid)
+	}
+
+	return module, nil
+}
+
+// Catalog 目录里全部模块的uid，按注册顺序
+func Catalog() []string {
+	catalogM.RLock()
+	defer catalogM.RUnlock()
+
+	uids := make([]string, 0, len(catalog))
+	for _, entry := range catalog {
+		uids = append(uids, entry.uid)
+	}
+
+	return uids
+}
+
+// FromCatalog 按uid从目录里拿到新的模块实例，uids为空则全部，按注册顺序
+func FromCatalog(uids ...string) ([]Module, error) {
+	catalogM.RLock()
+	defer catalogM.RUnlock()
+
+	wanted := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		wanted[uid] = false
+	}
+
+	var modules []Module
+	for _, entry := range catalog {
+		if len(uids) != 0 {
+			if _, ok := wanted[entry.uid]; !ok {
+				continue
+			}
+			wanted[entry.uid] = true
+		}
+		module, err := entry.build()
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, module)
+	}
+
+	for _, uid := range uids {
+		if !wanted[uid] {
+			return nil, fmt.Errorf("%w: %s", ErrorNoSuchModule, uid)
+		}
+	}
+
+	return modules, nil
+}
+
+// Select 从目录里挑选模块，enable为空则全部，去掉disable；uid不在目录里，或者选中的模块依赖了被去掉的模块都返回错误
+func Select(enable, disable []string) ([]Module, error) {
+	known := make(map[string]bool)
+	for _, uid := range Catalog() {
+		known[uid] = true
+	}
+
+	disabled := make(map[string]bool, len(disable))
+	for _, uid := range disable {
+		if !known[uid] {
+			return nil, fmt.Errorf("%w: %s", ErrorNoSuchModule, uid)
+		}
+		disabled[uid] = true
+	}
+
+	uids := enable
+	if len(uids) == 0 {
+		uids = Catalog()
+	}
+
+	selected := make([]string, 0, len(uids))
+	selectedSet := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		if disabled[uid] || selectedSet[uid] {
+			continue
+		}
+		selected = append(selected, uid)
+		selectedSet[uid] = true
+	}
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	modules, err := FromCatalog(selected...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 依赖的模块在目录里却没选中，初始化时拿不到
+	for _, module := range modules {
+		depender, ok := module.(Depender)
+		if !ok {
+			continue
+		}
+		for _, depend := range depender.ModuleDepends() {
+			if known[depend] && !selectedSet[depend] {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrorModuleDependsDisabled, module.ModuleUID(), depend)
+			}
+		}
+	}
+
+	return modules, nil
+}
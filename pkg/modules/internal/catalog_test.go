@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProvide(t *testing.T) {
+	built := 0
+	Provide("catalog_test_a", func() Module {
+		built++
+		return &reconfigureModule{uid: "catalog_test_a"}
+	})
+	// 登记时不构造
+	if built != 0 {
+		t.Fatalf("built = %d", built)
+	}
+
+	first, err := FromCatalog("catalog_test_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := FromCatalog("catalog_test_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built != 2 || first[0] == second[0] {
+		t.Fatalf("built = %d, each call should build a new instance", built)
+	}
+}
+
+func TestProvideUIDMismatch(t *testing.T) {
+	Provide("catalog_test_b", func() Module {
+		return &reconfigureModule{uid: "other"}
+	})
+
+	if _, err := FromCatalog("catalog_test_b"); !errors.Is(err, ErrorModuleUIDMismatch) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestProvideDuplicated(t *testing.T) {
+	Provide("catalog_test_c", func() Module { return &reconfigureModule{uid: "catalog_test_c"} })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic")
+		}
+	}()
+	Provide("catalog_test_c", func() Module { return &reconfigureModule{uid: "catalog_test_c"} })
+}
@@ -8,3 +8,18 @@ type Module interface {
 	ModuleExit(ctx context.Context) error
 	ModuleClean(ctx context.Context) error
 }
+
+// Depender 可选接口，声明依赖的模块uid，被依赖的模块先初始化、后退出
+type Depender interface {
+	ModuleDepends() []string
+}
+
+// Configurable 可选接口，返回带默认值的配置结构体指针，安装前用配置里模块uid对应的部分填充并校验
+type Configurable interface {
+	ModuleConfig() any
+}
+
+// Reconfigurable 可选接口，配置热更新时调用，old和new都是 ModuleConfig() 类型的结构体指针，返回错误则回滚
+type Reconfigurable interface {
+	ModuleReconfigure(ctx context.Context, old, new any) error
+}
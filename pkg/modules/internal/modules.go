@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"espresso/pkg/ctxhelper"
+	"espresso/pkg/panics"
 	"fmt"
 	"go.uber.org/zap"
 	"sync"
@@ -10,7 +11,7 @@ import (
 
 var (
 	modulesSingleInst *Modules = nil
-	once              sync.Once
+	defaultM          sync.Mutex
 )
 
 type Modules struct {
@@ -20,6 +21,13 @@ type Modules struct {
 	modulesInits  []func(context.Context) error
 	modulesExits  []func(context.Context) error
 	modulesCleans []func(context.Context) error
+
+	// 模块当前的配置
+	configs  map[string]any
+	configsM sync.RWMutex
+
+	// 模块的后台循环
+	supervisors supervisors
 }
 
 type Summary struct {
@@ -28,20 +36,47 @@ type Summary struct {
 	Error  error
 }
 
+// contextKey 模块初始化时注入自己，模块里用 FromContext 拿到安装自己的模块管理器
+var contextKey = ctxhelper.NewKey[*Modules]("core_modules")
+
+// GetModules 全局的模块管理器，安装目录里的全部模块，给没有自己mvc的旧用法
 func GetModules() *Modules {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
 	if modulesSingleInst == nil {
-		once.Do(func() {
-			modulesSingleInst = new(Modules)
-			modulesSingleInst.init()
-		})
+		modulesSingleInst = New()
+
+		installs, _ := FromCatalog()
+		for _, module := range installs {
+			modulesSingleInst.Register(module)
+		}
 	}
 
 	return modulesSingleInst
 }
 
-// init 模块管理器初始化
-func (modules *Modules) init() {
-	modulesSingleInst.modules = make(map[string]Module)
+// New 独立的模块管理器，不和全局的共享
+func New() *Modules {
+	return &Modules{
+		modules:    make(map[string]Module),
+		moduleUIDs: make(map[string]struct{}),
+		configs:    make(map[string]any),
+	}
+}
+
+// Inject 注入模块管理器
+func Inject(ctx context.Context, modules *Modules) context.Context {
+	return contextKey.Inject(ctx, modules)
+}
+
+// FromContext 安装模块的模块管理器，没有注入则是全局的
+func FromContext(ctx context.Context) *Modules {
+	if modules, ok := contextKey.Fetch(ctx); ok && modules != nil {
+		return modules
+	}
+
+	return GetModules()
 }
 
 // Register 注册模块
@@ -74,6 +109,7 @@ func (modules *Modules) Register(module Module) {
 	}
 
 	modules.modules[uid] = module
+	modules.moduleUIDs[uid] = struct{}{}
 	modules.modulesOrders = append(modules.modulesOrders, uid)
 	modules.modulesInits = append(modules.modulesInits, initWithPanic)
 	modules.modulesExits = append(modules.modulesExits, exitWithRecover)
@@ -86,9 +122,90 @@ func recoverWithStacktrace(ctx context.Context, moduleName string) {
 
 	if r != nil {
 		logger := ctxhelper.FetchLogger(ctx)
-		err := fmt.Errorf("%v", r)
-		logger.Error("module panic", zap.String("module", moduleName), zap.Error(err))
+		p := panics.FromContext(ctx).Capture(panics.SourceModule, r, moduleName, "")
+		logger.Error("module panic", zap.String("module", moduleName), zap.Error(p), zap.String("fingerprint", p.Fingerprint), zap.String("stack", p.Stack))
+	}
+}
+
+// sortByDepends 按依赖关系排序，没有依赖关系的保持注册顺序
+func (modules *Modules) sortByDepends(ctx context.Context) {
+	logger := ctxhelper.FetchLogger(ctx)
+
+	indexes := make(map[string]int, len(modules.modulesOrders))
+	for index, uid := range modules.modulesOrders {
+		indexes[uid] = index
+	}
+
+	// 0 未访问 1 访问中 2 已完成
+	states := make(map[string]int, len(modules.modulesOrders))
+	orders := make([]int, 0, len(modules.modulesOrders))
+
+	var visit func(uid string)
+	visit = func(uid string) {
+		switch states[uid] {
+		case 1:
+			logger.Error("module depends cycle", zap.String("module", uid))
+			return
+		case 2:
+			return
+		}
+
+		states[uid] = 1
+		if depender, ok := modules.modules[uid].(Depender); ok {
+			for _, depend := range depender.ModuleDepends() {
+				if _, ok := indexes[depend]; !ok {
+					logger.Error("module depends not installed", zap.String("module", uid), zap.String("depend", depend))
+					continue
+				}
+				visit(depend)
+			}
+		}
+		states[uid] = 2
+		orders = append(orders, indexes[uid])
 	}
+
+	for _, uid := range modules.modulesOrders {
+		visit(uid)
+	}
+
+	modulesOrders := make([]string, 0, len(orders))
+	modulesInits := make([]func(context.Context) error, 0, len(orders))
+	modulesExits := make([]func(context.Context) error, 0, len(orders))
+	modulesCleans := make([]func(context.Context) error, 0, len(orders))
+	for _, index := range orders {
+		modulesOrders = append(modulesOrders, modules.modulesOrders[index])
+		modulesInits = append(modulesInits, modules.modulesInits[index])
+		modulesExits = append(modulesExits, modules.modulesExits[index])
+		modulesCleans = append(modulesCleans, modules.modulesCleans[index])
+	}
+
+	modules.modulesOrders = modulesOrders
+	modules.modulesInits = modulesInits
+	modules.modulesExits = modulesExits
+	modules.modulesCleans = modulesCleans
+}
+
+// withModuleConfig 解析模块配置并注入ctx
+func (modules *Modules) withModuleConfig(ctx context.Context, uid string) (context.Context, error) {
+	configurable, ok := modules.modules[uid].(Configurable)
+	if !ok {
+		return ctx, nil
+	}
+
+	moduleConfig := configurable.ModuleConfig()
+	if moduleConfig == nil {
+		return ctx, nil
+	}
+
+	if err := ctxhelper.FetchConfig(ctx).Decode(uid, moduleConfig); err != nil {
+		return ctx, err
+	}
+
+	modules.configsM.Lock()
+	modules.configs[uid] = moduleConfig
+	modules.configsM.Unlock()
+
+	return ctxhelper.InjectModuleConfig(ctx, moduleConfig), nil
 }
 
 // ModulesInit 初始化全部模块
@@ -96,9 +213,38 @@ func (modules *Modules) ModulesInit(ctx context.Context) {
 	logger := ctxhelper.FetchLogger(ctx)
 	var summaries []Summary
 
+	// 模块里通过ctx拿到安装自己的模块管理器
+	ctx = Inject(ctx, modules)
+
+	// 被依赖的模块先初始化
+	modules.sortByDepends(ctx)
+
+	modules.supervisors.m.Lock()
+	modules.supervisors.appCtx = ctx
+	modules.supervisors.m.Unlock()
+
 	for index, moduleName := range modules.modulesOrders {
 		moduleInit := modules.modulesInits[index]
-		if err := moduleInit(ctx); err != nil {
+
+		// 模块配置
+		moduleCtx, err := modules.withModuleConfig(ctxhelper.InjectModuleUID(ctx, moduleName), moduleName)
+		if err != nil {
+			logger.Error("installing a new module", zap.String("module", moduleName), zap.Bool("result", false), zap.Error(err))
+			summaries = append(summaries, Summary{Module: moduleName, Ok: false, Error: err})
+			continue
+		}
+
+		modules.supervisors.m.Lock()
+		modules.supervisors.initModule, modules.supervisors.initCtx = moduleName, moduleCtx
+		modules.supervisors.m.Unlock()
+
+		err = moduleInit(moduleCtx)
+
+		modules.supervisors.m.Lock()
+		modules.supervisors.initModule, modules.supervisors.initCtx = "", nil
+		modules.supervisors.m.Unlock()
+
+		if err != nil {
 			logger.Error("installing a new module", zap.String("module", moduleName), zap.Bool("result", false), zap.Error(err))
 			summaries = append(summaries, Summary{Module: moduleName, Ok: false, Error: err})
 			continue
@@ -145,10 +291,21 @@ func (modules *Modules) ModulesClean(ctx context.Context) {
 func (modules *Modules) ModulesExit(ctx context.Context) {
 	logger := ctxhelper.FetchLogger(ctx)
 
+	// 不属于模块的循环可能用到任何模块，先停
+	if err := modules.stopSupervised(ctx, ""); err != nil {
+		logger.Error("stop supervised", zap.Bool("result", false), zap.Error(err))
+	}
+
 	count := len(modules.modulesOrders)
 	for index := count - 1; index >= 0; index-- {
 		moduleName := modules.modulesOrders[index]
 		modulesExit := modules.modulesExits[index]
+
+		// 模块的循环先停，退出时资源还可用
+		if err := modules.stopSupervised(ctx, moduleName); err != nil {
+			logger.Error("stop supervised", zap.String("module", moduleName), zap.Bool("result", false), zap.Error(err))
+		}
+
 		if err := modulesExit(ctx); err != nil {
 			logger.Error("uninstalling a module", zap.String("module", moduleName), zap.Bool("result", false), zap.Error(err))
 			continue
@@ -166,3 +323,21 @@ func (modules *Modules) GetModule(name string) Module {
 
 	return m
 }
+
+// Installed 安装的模块uid，初始化之后按依赖顺序
+func (modules *Modules) Installed() []string {
+	installed := make([]string, len(modules.modulesOrders))
+	copy(installed, modules.modulesOrders)
+
+	return installed
+}
+
+// Registered 全部注册的模块，按注册顺序
+func (modules *Modules) Registered() []Module {
+	registered := make([]Module, 0, len(modules.modulesOrders))
+	for _, uid := range modules.modulesOrders {
+		registered = append(registered, modules.modules[uid])
+	}
+
+	return registered
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/config"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/panics"
+	"fmt"
+	"go.uber.org/zap"
+	"reflect"
+)
+
+// Change 模块配置变化
+type Change struct {
+	Module string
+	Old    any
+	New    any
+}
+
+// ModuleConfig 模块当前的配置
+func (modules *Modules) ModuleConfig(uid string) any {
+	modules.configsM.RLock()
+	defer modules.configsM.RUnlock()
+
+	return modules.configs[uid]
+}
+
+// Reconfigure 用新配置解析模块配置，和模块当前的配置对比（配置文件没变但环境变量变了也算），变化的模块先全部解析校验，再依次通知，任意失败都回滚
+func (modules *Modules) Reconfigure(ctx context.Context, old, new *config.Config) ([]Change, error) {
+	logger := ctxhelper.FetchLogger(ctx)
+
+	modules.configsM.Lock()
+	defer modules.configsM.Unlock()
+
+	// 解析校验
+	var changes []Change
+	for _, uid := range modules.modulesOrders {
+		configurable, ok := modules.modules[uid].(Configurable)
+		if !ok {
+			continue
+		}
+
+		current, ok := modules.configs[uid]
+		if !ok {
+			// 没有安装成功
+			continue
+		}
+
+		// 解析后再比较，配置文件没变但环境变量变了也算
+		moduleConfig := configurable.ModuleConfig()
+		if err := new.Decode(uid, moduleConfig); err != nil {
+			return nil, err
+		}
+
+		if reflect.DeepEqual(current, moduleConfig) {
+			continue
+		}
+
+		changes = append(changes, Change{Module: uid, Old: current, New: moduleConfig})
+	}
+
+	// 通知模块
+	for index, change := range changes {
+		reconfigurable, ok := modules.modules[change.Module].(Reconfigurable)
+		if !ok {
+			continue
+		}
+
+		if err := reconfigureWithRecover(ctx, change.Module, reconfigurable, change.Old, change.New); err != nil {
+			logger.Error("reconfigure a module", zap.String("module", change.Module), zap.Bool("result", false), zap.Error(err))
+
+			// 已经通知的模块改回旧配置
+			for rollback := index - 1; rollback >= 0; rollback-- {
+				applied := changes[rollback]
+				if r, ok := modules.modules[applied.Module].(Reconfigurable); ok {
+					if err := reconfigureWithRecover(ctx, applied.Module, r, applied.New, applied.Old); err != nil {
+						logger.Error("rollback a module", zap.String("module", applied.Module), zap.Bool("result", false), zap.Error(err))
+					}
+				}
+			}
+
+			return nil, fmt.Errorf("reconfigure module %s: %w", change.Module, err)
+		}
+	}
+
+	// 生效
+	for _, change := range changes {
+		modules.configs[change.Module] = change.New
+		logger.Info("reconfigure a module", zap.String("module", change.Module), zap.Bool("result", true))
+	}
+
+	return changes, nil
+}
+
+func reconfigureWithRecover(ctx context.Context, uid string, module Reconfigurable, old, new any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("module reconfigure panic: %w", panics.FromContext(ctx).Capture(panics.SourceModule, r, uid, "ModuleReconfigure"))
+		}
+	}()
+
+	return module.ModuleReconfigure(ctx, old, new)
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/config"
+	"espresso/pkg/ctxhelper"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap/zaptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type reconfigureConfig struct {
+	Value int `yaml:"value" binding:"gte=0"`
+}
+
+// reconfigureModule 记录收到的配置，fail为true时拒绝新配置
+type reconfigureModule struct {
+	uid     string
+	fail    bool
+	applied []int
+}
+
+func (module *reconfigureModule) ModuleUID() string                     { return module.uid }
+func (module *reconfigureModule) ModuleInit(ctx context.Context) error  { return nil }
+func (module *reconfigureModule) ModuleExit(ctx context.Context) error  { return nil }
+func (module *reconfigureModule) ModuleClean(ctx context.Context) error { return nil }
+func (module *reconfigureModule) ModuleConfig() any                     { return &reconfigureConfig{} }
+
+func (module *reconfigureModule) ModuleReconfigure(ctx context.Context, old, new any) error {
+	if module.fail {
+		return errors.New("rejected")
+	}
+	module.applied = append(module.applied, new.(*reconfigureConfig).Value)
+	return nil
+}
+
+func loadConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "modules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(config.File(path), config.Env("ESPRESSO_TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestReconfigure(t *testing.T) {
+	old := loadConfig(t, "modules:\n  a:\n    value: 1\n  b:\n    value: 1\n")
+
+	tests := []struct {
+		name     string
+		new      string
+		failB    bool
+		wantErr  bool
+		wantA    []int
+		wantB    []int
+		currentA int
+		currentB int
+	}{
+		{"unchanged", "modules:\n  a:\n    value: 1\n  b:\n    value: 1\n", false, false, nil, nil, 1, 1},
+		// 配置文件里没有a，用默认值也算变化
+		{"removed", "modules:\n  b:\n    value: 1\n", false, false, []int{0}, nil, 0, 1},
+		{"changed", "modules:\n  a:\n    value: 2\n  b:\n    value: 1\n", false, false, []int{2}, nil, 2, 1},
+		// b拒绝，已经通知的a改回旧配置
+		{"rollback", "modules:\n  a:\n    value: 2\n  b:\n    value: 3\n", true, true, []int{2, 1}, nil, 1, 1},
+		// 校验失败，谁都不通知
+		{"invalid", "modules:\n  a:\n    value: 2\n  b:\n    value: -1\n", false, true, nil, nil, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := ctxhelper.InjectLogger(context.Background(), &minilog.MiniLog{Logger: zaptest.NewLogger(t)})
+			ctx = ctxhelper.InjectConfig(ctx, old)
+
+			a := &reconfigureModule{uid: "a"}
+			b := &reconfigureModule{uid: "b", fail: test.failB}
+			modules := New()
+			modules.Register(a)
+			modules.Register(b)
+			modules.ModulesInit(ctx)
+
+			changes, err := modules.Reconfigure(ctx, old, loadConfig(t, test.new))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v", err)
+			}
+			if err != nil && changes != nil {
+				t.Fatalf("changes = %+v", changes)
+			}
+
+			if !equalInts(a.applied, test.wantA) || !equalInts(b.applied, test.wantB) {
+				t.Fatalf("applied a = %v, b = %v", a.applied, b.applied)
+			}
+			if value := modules.ModuleConfig("a").(*reconfigureConfig).Value; value != test.currentA {
+				t.Fatalf("current a = %d", value)
+			}
+			if value := modules.ModuleConfig("b").(*reconfigureConfig).Value; value != test.currentB {
+				t.Fatalf("current b = %d", value)
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReconfigureEnv(t *testing.T) {
+	content := "modules:\n  a:\n    value: 1\n"
+	old := loadConfig(t, content)
+
+	ctx := ctxhelper.InjectLogger(context.Background(), &minilog.MiniLog{Logger: zaptest.NewLogger(t)})
+	ctx = ctxhelper.InjectConfig(ctx, old)
+
+	a := &reconfigureModule{uid: "a"}
+	modules := New()
+	modules.Register(a)
+	modules.ModulesInit(ctx)
+
+	// 配置文件没变，环境变量变了
+	t.Setenv("ESPRESSO_TEST_MODULES_A_VALUE", "5")
+	changes, err := modules.Reconfigure(ctx, old, loadConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !equalInts(a.applied, []int{5}) {
+		t.Fatalf("changes = %+v, applied = %v", changes, a.applied)
+	}
+}
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/panics"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Restart int
+
+const (
+	RestartAlways    Restart = iota // 正常返回也重启，比如一直运行的消费者
+	RestartOnFailure                // 返回错误或者崩溃时重启
+	RestartNever                    // 不重启
+)
+
+// Policy 重启策略，没有设置的字段使用默认值
+type Policy struct {
+	Restart     Restart
+	MinBackoff  time.Duration // 第一次重启前等待，之后翻倍，默认100ms
+	MaxBackoff  time.Duration // 最长等待，默认30s
+	MaxRestarts int           // Window内最多重启次数，超过后不再重启并把模块标记为不健康，默认5
+	Window      time.Duration // 默认1m，一次运行超过Window则退避重新计算
+}
+
+func (policy Policy) withDefaults() Policy {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 30 * time.Second
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = policy.MinBackoff
+	}
+	if policy.MaxRestarts <= 0 {
+		policy.MaxRestarts = 5
+	}
+	if policy.Window <= 0 {
+		policy.Window = time.Minute
+	}
+	return policy
+}
+
+// Supervised 被监督的后台循环的状态
+type Supervised struct {
+	Module    string `json:"module"`
+	Name      string `json:"name"`
+	Running   bool   `json:"running"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+type supervised struct {
+	module string
+	name   string
+	fn     func(ctx context.Context) error
+	policy Policy
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// 状态
+	running   bool
+	restarts  int
+	lastError error
+	statusM   sync.Mutex
+}
+
+// supervisors 按模块管理后台循环，模块退出前停掉
+type supervisors struct {
+	// ModuleInit 期间的模块和ctx，Supervise绑定到这个模块
+	initModule string
+	initCtx    context.Context
+	appCtx     context.Context
+
+	loops     []*supervised
+	unhealthy map[string]string
+	m         sync.Mutex
+}
+
+// detached 保留日志、配置等值，但不跟着启动时的ctx取消，只在模块退出前取消
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// Supervise 监督一个后台循环，崩溃或者返回后按策略一对一重启；在ModuleInit里调用时绑定到这个模块，模块退出前取消ctx并等待，
+// 否则在全部模块退出前停掉；Window内重启次数超过上限后不再重启，模块标记为不健康
+func (modules *Modules) Supervise(name string, fn func(ctx context.Context) error, policy Policy) {
+	sups := &modules.supervisors
+
+	sups.m.Lock()
+	module, ctx := sups.initModule, sups.initCtx
+	if ctx == nil {
+		ctx = sups.appCtx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	loop := &supervised{
+		module: module,
+		name:   name,
+		fn:     fn,
+		policy: policy.withDefaults(),
+		done:   make(chan struct{}),
+	}
+	ctx, loop.cancel = context.WithCancel(detached{ctx})
+	sups.loops = append(sups.loops, loop)
+	sups.m.Unlock()
+
+	go modules.supervise(ctx, loop)
+}
+
+func (modules *Modules) supervise(ctx context.Context, loop *supervised) {
+	defer close(loop.done)
+
+	logger := ctxhelper.FetchLogger(ctx)
+	if logger == nil {
+		logger = &minilog.MiniLog{Logger: zap.NewNop()}
+	}
+	policy := loop.policy
+	backoff := policy.MinBackoff
+	var restarts []time.Time
+
+	for {
+		startTime := time.Now()
+		loop.setRunning(true, nil)
+		err := runSupervised(ctx, loop)
+		loop.setRunning(false, err)
+
+		if ctx.Err() != nil {
+			logger.Info("supervised exit", zap.String("module", loop.module), zap.String("name", loop.name), zap.Bool("result", true))
+			return
+		}
+
+		if err != nil {
+			logger.Error("supervised exit", zap.String("module", loop.module), zap.String("name", loop.name), zap.Bool("result", false), zap.Error(err))
+		} else {
+			logger.Warn("supervised exit", zap.String("module", loop.module), zap.String("name", loop.name), zap.Bool("result", true))
+		}
+
+		switch {
+		case policy.Restart == RestartNever:
+			return
+		case policy.Restart == RestartOnFailure && err == nil:
+			return
+		}
+
+		// 运行够久说明已经恢复，退避重新计算
+		now := time.Now()
+		if now.Sub(startTime) >= policy.Window {
+			backoff = policy.MinBackoff
+		}
+
+		// 只保留Window内的重启
+		recent := restarts[:0]
+		for _, restart := range restarts {
+			if now.Sub(restart) < policy.Window {
+				recent = append(recent, restart)
+			}
+		}
+		restarts = recent
+
+		if len(restarts) >= policy.MaxRestarts {
+			reason := fmt.Sprintf("%s restarted %d times in %s", loop.name, len(restarts), policy.Window)
+			if err != nil {
+				reason += ": " + err.Error()
+			}
+			modules.setUnhealthy(loop.module, reason)
+			logger.Error("supervised give up", zap.String("module", loop.module), zap.String("name", loop.name), zap.Int("restarts", len(restarts)), zap.Duration("window", policy.Window))
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		restarts = append(restarts, time.Now())
+		loop.statusM.Lock()
+		loop.restarts++
+		loop.statusM.Unlock()
+		logger.Warn("supervised restart", zap.String("module", loop.module), zap.String("name", loop.name), zap.Duration("backoff", backoff))
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func runSupervised(ctx context.Context, loop *supervised) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("supervised panic: %w", panics.FromContext(ctx).Capture(panics.SourceModule, r, loop.module, loop.name))
+		}
+	}()
+
+	return loop.fn(ctx)
+}
+
+func (loop *supervised) setRunning(running bool, err error) {
+	loop.statusM.Lock()
+	defer loop.statusM.Unlock()
+
+	loop.running = running
+	if err != nil {
+		loop.lastError = err
+	}
+}
+
+func (modules *Modules) setUnhealthy(module, reason string) {
+	sups := &modules.supervisors
+
+	sups.m.Lock()
+	defer sups.m.Unlock()
+
+	if sups.unhealthy == nil {
+		sups.unhealthy = make(map[string]string)
+	}
+	sups.unhealthy[module] = reason
+}
+
+// Unhealthy 不健康的模块和原因，不在模块里监督的循环模块名为空
+func (modules *Modules) Unhealthy() map[string]string {
+	sups := &modules.supervisors
+
+	sups.m.Lock()
+	defer sups.m.Unlock()
+
+	unhealthy := make(map[string]string, len(sups.unhealthy))
+	for module, reason := range sups.unhealthy {
+		unhealthy[module] = reason
+	}
+	return unhealthy
+}
+
+// Supervised 全部被监督的循环，按模块和名字排序
+func (modules *Modules) Supervised() []Supervised {
+	sups := &modules.supervisors
+
+	sups.m.Lock()
+	loops := append([]*supervised(nil), sups.loops...)
+	sups.m.Unlock()
+
+	stats := make([]Supervised, 0, len(loops))
+	for _, loop := range loops {
+		loop.statusM.Lock()
+		stat := Supervised{Module: loop.module, Name: loop.name, Running: loop.running, Restarts: loop.restarts}
+		if loop.lastError != nil {
+			stat.LastError = loop.lastError.Error()
+		}
+		loop.statusM.Unlock()
+		stats = append(stats, stat)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Module != stats[j].Module {
+			return stats[i].Module < stats[j].Module
+		}
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats
+}
+
+// stopSupervised 取消模块的循环并等待退出，ctx超时后不再等待
+func (modules *Modules) stopSupervised(ctx context.Context, module string) error {
+	sups := &modules.supervisors
+
+	sups.m.Lock()
+	var stopping []*supervised
+	loops := sups.loops[:0]
+	for _, loop := range sups.loops {
+		if loop.module == module {
+			stopping = append(stopping, loop)
+			continue
+		}
+		loops = append(loops, loop)
+	}
+	sups.loops = loops
+	sups.m.Unlock()
+
+	var errs []error
+	for _, loop := range stopping {
+		loop.cancel()
+	}
+	for _, loop := range stopping {
+		select {
+		case <-loop.done:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("stop supervised %s: %w", loop.name, ctx.Err()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
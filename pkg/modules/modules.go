@@ -5,22 +5,100 @@ import (
 	"espresso/pkg/modules/internal"
 )
 
+type Modules = internal.Modules
 type Module = internal.Module
+type Depender = internal.Depender
+type Configurable = internal.Configurable
+type Reconfigurable = internal.Reconfigurable
+type Change = internal.Change
+type Policy = internal.Policy
+type Restart = internal.Restart
+type Supervised = internal.Supervised
 
+const (
+	RestartAlways    = internal.RestartAlways
+	RestartOnFailure = internal.RestartOnFailure
+	RestartNever     = internal.RestartNever
+)
+
+// New 独立的模块管理器，不和全局的共享，比如测试
+func New() *Modules {
+	return internal.New()
+}
+
+// Default 全局的模块管理器，安装目录里的全部模块，不属于任何App，ctx里没有注入时包函数用它
+func Default() *Modules {
+	return internal.GetModules()
+}
+
+// Inject 注入模块管理器
+func Inject(ctx context.Context, modules *Modules) context.Context {
+	return internal.Inject(ctx, modules)
+}
+
+// FromContext 安装模块的模块管理器，在ModuleInit里用ctx拿到，没有注入则是全局的
+func FromContext(ctx context.Context) *Modules {
+	return internal.FromContext(ctx)
+}
+
+var ErrorNoSuchModule = internal.ErrorNoSuchModule
+var ErrorModuleDependsDisabled = internal.ErrorModuleDependsDisabled
+var ErrorModuleUIDMismatch = internal.ErrorModuleUIDMismatch
+
+// Register 把模块实例加到全局目录，每个App安装的是同一个实例；需要每个App一个实例用 Provide
 func Register(module Module) {
-	internal.GetModules().Register(module)
+	internal.Provide(module.ModuleUID(), func() Module { return module })
+}
+
+// Provide 把模块的构造函数加到全局目录，一般在init里调用，登记时不构造，每个App安装时构造自己的实例；
+// factory返回的模块uid要和uid一致
+func Provide(uid string, factory func() Module) {
+	internal.Provide(uid, factory)
+}
+
+// Catalog 目录里全部模块的uid，按注册顺序
+func Catalog() []string {
+	return internal.Catalog()
+}
+
+// FromCatalog 按uid从目录里拿到新的模块实例，uids为空则全部
+func FromCatalog(uids ...string) ([]Module, error) {
+	return internal.FromCatalog(uids...)
+}
+
+// Select 从目录里挑选模块的新实例，enable为空则全部，去掉disable
+func Select(enable, disable []string) ([]Module, error) {
+	return internal.Select(enable, disable)
+}
+
+// GetModule 安装在ctx里的模块管理器上的模块，未安装时panic
+func GetModule(ctx context.Context, name string) Module {
+	return internal.FromContext(ctx).GetModule(name)
+}
+
+// Registered 目录里全部模块的新实例，按注册顺序
+func Registered() []Module {
+	registered, _ := internal.FromCatalog()
+	return registered
+}
+
+// ModuleConfig 模块当前的配置
+func ModuleConfig(ctx context.Context, uid string) any {
+	return internal.FromContext(ctx).ModuleConfig(uid)
 }
 
-func Init(ctx context.Context) {
-	internal.GetModules().ModulesInit(ctx)
+// Supervise 监督模块的后台循环，比如消费者、文件监听；崩溃或者返回后按策略退避重启，在ModuleInit里用它的ctx调用时模块退出前取消ctx并等待；
+// Window内重启次数超过上限后模块标记为不健康，/health/ready返回503
+func Supervise(ctx context.Context, name string, fn func(ctx context.Context) error, policy Policy) {
+	internal.FromContext(ctx).Supervise(name, fn, policy)
 }
 
-func Exit(ctx context.Context) {
-	internal.GetModules().ModulesExit(ctx)
-	internal.GetModules().ModulesClean(ctx)
+// Unhealthy 不健康的模块和原因
+func Unhealthy(ctx context.Context) map[string]string {
+	return internal.FromContext(ctx).Unhealthy()
 }
 
-// GetModule 获得模块，未安装返回 nil
-func GetModule(name string) Module {
-	return internal.GetModules().GetModule(name)
+// ListSupervised 全部被监督的循环和重启次数
+func ListSupervised(ctx context.Context) []Supervised {
+	return internal.FromContext(ctx).Supervised()
 }
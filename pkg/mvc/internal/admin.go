@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"espresso/pkg/protocol"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type timerJobsResponse struct {
+	protocol.BaseResponse
+	Jobs []TimerJob `json:"jobs"`
+}
+
+type timerJobSpecRequest struct {
+	Spec string `json:"spec" form:"spec" binding:"required"`
+}
+
+// RegisterTimerAdmin 注册定时任务的管理接口
+func (mvc *Mvc) RegisterTimerAdmin(router gin.IRouter) {
+	group := router.Group("/cron")
+
+	// 列出全部定时任务，?owner=模块uid 只列出这个模块的
+	group.GET("/jobs", func(c *gin.Context) {
+		jobs := mvc.TimerJobs()
+		if owner := c.Query("owner"); owner != "" {
+			owned := jobs[:0]
+			for _, job := range jobs {
+				if job.Owner == owner {
+					owned = append(owned, job)
+				}
+			}
+			jobs = owned
+		}
+		sort.Slice(jobs, func(i, j int) bool {
+			return jobs[i].Id < jobs[j].Id
+		})
+
+		c.JSON(http.StatusOK, timerJobsResponse{Jobs: jobs})
+	})
+
+	// 马上执行一次
+	group.POST("/jobs/:id/trigger", timerAdminHandler(func(c *gin.Context, id cron.EntryID) error {
+		return mvc.TriggerTimerJob(id)
+	}))
+
+	// 暂停
+	group.POST("/jobs/:id/pause", timerAdminHandler(func(c *gin.Context, id cron.EntryID) error {
+		return mvc.PauseTimerJob(id)
+	}))
+
+	// 恢复
+	group.POST("/jobs/:id/resume", timerAdminHandler(func(c *gin.Context, id cron.EntryID) error {
+		return mvc.ResumeTimerJob(id)
+	}))
+
+	// 修改执行时间
+	group.POST("/jobs/:id/spec", timerAdminHandler(func(c *gin.Context, id cron.EntryID) error {
+		request := &timerJobSpecRequest{}
+		if err := c.ShouldBind(request); err != nil {
+			return err
+		}
+
+		return mvc.UpdateTimerJobSpec(id, request.Spec)
+	}))
+}
+
+func timerAdminHandler(handle func(*gin.Context, cron.EntryID) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: err.Error()})
+			return
+		}
+
+		if err := handle(c, cron.EntryID(id)); err != nil {
+			c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, protocol.BaseResponse{Code: protocol.CodeOk})
+	}
+}
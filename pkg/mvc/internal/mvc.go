@@ -7,15 +7,23 @@ import (
 	"fmt"
 	eventbus "github.com/asaskevich/EventBus"
 	dispatcher "github.com/dan-and-dna/gin-dispatcher"
+	"github.com/dan-and-dna/minilog"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
 
 	"espresso/pkg/ctxhelper"
+	"espresso/pkg/election"
 	"espresso/pkg/modules"
 	"espresso/pkg/protocol"
+	"espresso/pkg/tasks"
+	"espresso/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -28,15 +36,48 @@ var (
 type Plugin = dispatcher.Plugin
 type Handler = dispatcher.Handler
 
+var (
+	ErrorNoSuchMessage   = errors.New("no such message")
+	ErrorMessageMismatch = errors.New("message handler mismatch")
+)
+
+// contextKey 模块初始化、处理函数、任务的ctx里注入自己，事件按ctx发布到对应的mvc
+var contextKey = ctxhelper.NewKey[*Mvc]("core_mvc")
+
 type Mvc struct {
 	networkDispatcher *dispatcher.Messages
 	modules           map[string]map[string]any
+	handlers          map[string]reflect.Value
+	handlersM         sync.RWMutex
+	plugins           []Plugin
+
+	// 模块管理器
+	installer *modules.Modules
+
+	// 事件观察者，收到全部发布的事件
+	observers  []func(event string, args []any)
+	observersM sync.RWMutex
 
 	// 事件管理器
 	eventDispatcher eventbus.Bus
 
 	// 定时器
-	timer *cron.Cron
+	timer      *cron.Cron
+	timerJobs  map[cron.EntryID]*timerJob
+	timerJobsM sync.RWMutex
+	// 手动触发的定时任务
+	timerTriggers sync.WaitGroup
+	timerStopped  bool
+
+	// 延迟和重试任务
+	tasks *tasks.Queue
+
+	// 选举
+	elector *election.Elector
+
+	logger *minilog.MiniLog
+	// Run的ctx，定时任务用它找到App的崩溃记录和链路追踪
+	ctx context.Context
 
 	// 暂停
 	stop  bool
@@ -46,7 +87,7 @@ type Mvc struct {
 func GetSingleInst() *Mvc {
 	if singleInst == nil {
 		once.Do(func() {
-			singleInst = new(Mvc)
+			singleInst = &Mvc{installer: modules.Default()}
 			singleInst.Init()
 		})
 	}
@@ -54,9 +95,54 @@ func GetSingleInst() *Mvc {
 	return singleInst
 }
 
+// New 独立的mvc，有自己的模块管理器、消息派发、事件和定时器，不和全局的共享
+func New() *Mvc {
+	mvc := &Mvc{installer: modules.New()}
+	mvc.Init()
+
+	return mvc
+}
+
+// Inject 注入mvc
+func Inject(ctx context.Context, mvc *Mvc) context.Context {
+	return contextKey.Inject(ctx, mvc)
+}
+
+// FromContext ctx里的mvc，没有注入则是全局的
+func FromContext(ctx context.Context) *Mvc {
+	if ctx != nil {
+		if mvc, ok := contextKey.Fetch(ctx); ok && mvc != nil {
+			return mvc
+		}
+	}
+
+	return GetSingleInst()
+}
+
+// Modules 模块管理器
+func (mvc *Mvc) Modules() *modules.Modules {
+	return mvc.installer
+}
+
 func (mvc *Mvc) NewHttp() gin.HandlerFunc {
 	// 派发消息
-	return dispatcher.GinDispatcher(mvc.networkDispatcher)
+	dispatch := dispatcher.GinDispatcher(mvc.networkDispatcher)
+
+	return func(c *gin.Context) {
+		// 没安装的模块或者没注册的消息返回404，不是空回复
+		route := c.Param("module") + "::" + c.Param("message")
+		mvc.handlersM.RLock()
+		_, ok := mvc.handlers[route]
+		mvc.handlersM.RUnlock()
+		if !ok {
+			response := ErrorResponse(fmt.Errorf("%w: %s", ErrorNoSuchMessage, route))
+			response.RequestId = ctxhelper.FetchRequestId(c)
+			c.AbortWithStatusJSON(http.StatusNotFound, response)
+			return
+		}
+
+		dispatch(c)
+	}
 }
 
 func (mvc *Mvc) Init() {
@@ -89,81 +175,87 @@ func (mvc *Mvc) Init() {
 	}
 
 	messages.HandleError = func(c *gin.Context, err error) {
-		requestId, _ := ctxhelper.Get(c, "requestId").(string)
-
-		if _, ok := err.(*json.InvalidUnmarshalError); ok {
-			c.JSON(http.StatusOK, protocol.BaseResponse{
-				Code:      protocol.CodeInvalidJsonParam,
-				Msg:       "非法json对象",
-				RequestId: requestId,
-			})
-			return
-		}
-
-		if _, ok := err.(*json.UnmarshalTypeError); ok {
-			c.JSON(http.StatusOK, protocol.BaseResponse{
-				Code:      protocol.CodeInvalidJsonParam,
-				Msg:       "非法json对象",
-				RequestId: requestId,
-			})
-			return
-		}
+		response := ErrorResponse(err)
+		response.RequestId = ctxhelper.FetchRequestId(c)
 
-		// 参数校验错误
-		if _, ok := err.(*validator.InvalidValidationError); ok {
-			c.JSON(http.StatusOK, protocol.BaseResponse{
-				Code:      protocol.CodeInvalidRequest,
-				Msg:       "非法参数",
-				RequestId: requestId,
-			})
-			return
-		}
-
-		// 参数校验错误
-		if _, ok := err.(validator.ValidationErrors); ok {
-			c.JSON(http.StatusOK, protocol.BaseResponse{
-				Code:      protocol.CodeInvalidRequest,
-				Msg:       "非法参数",
-				RequestId: requestId,
-			})
-			return
-		}
-
-		c.JSON(http.StatusOK, protocol.BaseResponse{
-			Code:      protocol.CodeInvalidRequest,
-			Msg:       err.Error(),
-			RequestId: requestId,
-		})
+		c.JSON(http.StatusOK, response)
 	}
 
 	// 网络
 	mvc.networkDispatcher = messages
+	mvc.handlers = make(map[string]reflect.Value)
+	mvc.SetPlugins()
 
 	// 事件
 	mvc.eventDispatcher = eventbus.New()
 
 	// 定时器
 	mvc.timer = cron.New()
+	mvc.timerJobs = make(map[cron.EntryID]*timerJob)
+
+	// 任务队列
+	mvc.tasks = tasks.New()
 }
 
 func (mvc *Mvc) Run(ctx context.Context) {
+	mvc.logger = ctxhelper.FetchLogger(ctx)
+
+	// 模块里通过ctx拿到自己的mvc
+	ctx = Inject(ctx, mvc)
+	mvc.ctx = ctx
+
 	// 初始化模块
-	modules.Init(ctx)
+	mvc.installer.ModulesInit(ctx)
+	// 启动任务队列
+	if err := mvc.tasks.Start(ctx); err != nil {
+		mvc.logger.Error("start tasks fail", zap.Error(err))
+	}
 	// 启动定时器
 	mvc.timer.Start()
 }
 
 func (mvc *Mvc) Stop(ctx context.Context) {
-	// 等待正在运行的定时任务完成
-	<-mvc.timer.Stop().Done()
+	_ = mvc.StopTimer(ctx)
+	_ = mvc.StopTasks(ctx)
+	mvc.Exit(ctx)
+}
 
+// StopTimer 不再触发定时任务，等待正在运行的定时任务（包括手动触发的）完成，ctx超时后放弃等待
+func (mvc *Mvc) StopTimer(ctx context.Context) error {
+	mvc.timerJobsM.Lock()
+	mvc.timerStopped = true
+	mvc.timerJobsM.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		<-mvc.timer.Stop().Done()
+		mvc.timerTriggers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopTasks 处理完已经到期的任务
+func (mvc *Mvc) StopTasks(ctx context.Context) error {
+	return mvc.tasks.Stop(ctx)
+}
+
+// Exit 模块按依赖顺序退出
+func (mvc *Mvc) Exit(ctx context.Context) {
 	// 锁住不再能发布消息，避免模块忘了取消订阅消息
 	mvc.stopM.Lock()
 	defer mvc.stopM.Unlock()
 	mvc.stop = true
 
 	// 模块退出
-	modules.Exit(ctx)
+	mvc.installer.ModulesExit(ctx)
+	mvc.installer.ModulesClean(ctx)
 }
 
 func (mvc *Mvc) Register(module, message string, handler any) {
@@ -180,8 +272,69 @@ func (mvc *Mvc) Register(module, message string, handler any) {
 		mvc.modules[module] = make(map[string]any)
 	}
 
-	mvc.networkDispatcher.Register(strings.Join([]string{module, message}, "::"), handler)
+	route := strings.Join([]string{module, message}, "::")
+	mvc.networkDispatcher.Register(route, handler)
 	mvc.modules[module][message] = struct{}{}
+
+	mvc.handlersM.Lock()
+	mvc.handlers[route] = reflect.ValueOf(handler)
+	mvc.handlersM.Unlock()
+}
+
+// Call 进程内调用 module::message 的处理函数，经过全部插件，request和response是处理函数参数的类型
+func (mvc *Mvc) Call(ctx context.Context, module, message string, request, response any) error {
+	route := strings.Join([]string{module, message}, "::")
+
+	mvc.handlersM.RLock()
+	handler, ok := mvc.handlers[route]
+	mvc.handlersM.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrorNoSuchMessage, route)
+	}
+
+	ctx = Inject(ctx, mvc)
+	handlerType := handler.Type()
+	if !reflect.TypeOf(ctx).AssignableTo(handlerType.In(0)) {
+		return fmt.Errorf("%w: %s wants %s", ErrorMessageMismatch, route, handlerType.In(0))
+	}
+	if reflect.TypeOf(request) != handlerType.In(1) || reflect.TypeOf(response) != handlerType.In(2) {
+		return fmt.Errorf("%w: %s wants %s, %s", ErrorMessageMismatch, route, handlerType.In(1), handlerType.In(2))
+	}
+
+	withPlugins := dispatcher.Chain(dispatcher.NopPlugin(), mvc.plugins...)(
+		func(ctx context.Context, request any, response any) error {
+			outs := handler.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(request), reflect.ValueOf(response)})
+			err, _ := outs[0].Interface().(error)
+			return err
+		})
+
+	return withPlugins(ctx, request, response)
+}
+
+// ErrorResponse 处理函数或者参数解析的错误对应的协议code
+func ErrorResponse(err error) protocol.BaseResponse {
+	switch err.(type) {
+	case *json.InvalidUnmarshalError, *json.UnmarshalTypeError:
+		return protocol.BaseResponse{Code: protocol.CodeInvalidJsonParam, Msg: "非法json对象"}
+	case *validator.InvalidValidationError, validator.ValidationErrors:
+		// 参数校验错误
+		return protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: "非法参数"}
+	}
+
+	return protocol.BaseResponse{Code: protocol.CodeInvalidRequest, Msg: err.Error()}
+}
+
+// Routes 全部注册的消息，格式 module::message，按字母排序
+func (mvc *Mvc) Routes() []string {
+	routes := make([]string, 0)
+	for module, messages := range mvc.modules {
+		for message := range messages {
+			routes = append(routes, module+"::"+message)
+		}
+	}
+	sort.Strings(routes)
+
+	return routes
 }
 
 func (mvc *Mvc) Publish(event string, args ...any) {
@@ -192,9 +345,33 @@ func (mvc *Mvc) Publish(event string, args ...any) {
 		// 退出阶段就不派发消息，免得模块资源释放，消息还是需要处理导致的问题
 		return
 	}
+
+	mvc.observersM.RLock()
+	for _, observer := range mvc.observers {
+		observer(event, args)
+	}
+	mvc.observersM.RUnlock()
+
+	// 第一个参数是context的事件，事件处理也记录span
+	if len(args) != 0 {
+		if ctx, ok := args[0].(context.Context); ok {
+			ctx, end := tracing.Start(ctx, "event "+event)
+			defer end(nil)
+
+			args = append([]any{ctx}, args[1:]...)
+		}
+	}
 	mvc.eventDispatcher.Publish(event, args...)
 }
 
+// Observe 收到之后发布的全部事件，在发布的协程里同步调用，不要在里面发布事件
+func (mvc *Mvc) Observe(observer func(event string, args []any)) {
+	mvc.observersM.Lock()
+	defer mvc.observersM.Unlock()
+
+	mvc.observers = append(mvc.observers, observer)
+}
+
 func (mvc *Mvc) Subscribe(event string, callback any) error {
 	return mvc.eventDispatcher.Subscribe(event, callback)
 }
@@ -203,18 +380,94 @@ func (mvc *Mvc) UnSubscribe(event string, callback any) error {
 	return mvc.eventDispatcher.Unsubscribe(event, callback)
 }
 
-func (mvc *Mvc) OnTimeDo(spec string, cmd func()) (cron.EntryID, error) {
-	return mvc.timer.AddFunc(spec, cmd)
+func (mvc *Mvc) OnTimeDo(spec string, cmd func(), applyOptions ...TimerOption) (cron.EntryID, error) {
+	return mvc.addTimerJob(spec, func() error {
+		cmd()
+		return nil
+	}, applyOptions...)
+}
+
+func (mvc *Mvc) OnTimeDoWithError(spec string, cmd func() error, applyOptions ...TimerOption) (cron.EntryID, error) {
+	return mvc.addTimerJob(spec, cmd, applyOptions...)
 }
 
 func (mvc *Mvc) StopOnTimeDo(id cron.EntryID) {
-	mvc.timer.Remove(id)
+	mvc.removeTimerJob(id)
+}
+
+func (mvc *Mvc) SetTasks(options ...tasks.Option) {
+	mvc.tasks = tasks.New(options...)
+}
+
+func (mvc *Mvc) RegisterTask(name string, handler tasks.Handler) {
+	mvc.tasks.Register(name, handler)
+}
+
+func (mvc *Mvc) Enqueue(name string, payload any, options ...tasks.EnqueueOption) (string, error) {
+	return mvc.tasks.Enqueue(name, payload, options...)
+}
+
+func (mvc *Mvc) SetElector(elector *election.Elector) {
+	mvc.elector = elector
 }
 
+// SetPlugins 设置插件，每个插件和处理函数都有自己的span
 func (mvc *Mvc) SetPlugins(plugins ...Plugin) {
 	if mvc == nil || mvc.networkDispatcher == nil {
 		return
 	}
 
-	mvc.networkDispatcher.SetPlugins(plugins...)
+	traced := make([]Plugin, 0, len(plugins)+1)
+	for index, plugin := range plugins {
+		traced = append(traced, tracePlugin(fmt.Sprintf("mvc plugin %d", index), plugin))
+	}
+	traced = append(traced, traceHandler())
+
+	mvc.plugins = traced
+	mvc.networkDispatcher.SetPlugins(traced...)
+}
+
+// tracePlugin 插件的span，包含后面的插件和处理函数
+func tracePlugin(name string, plugin Plugin) Plugin {
+	return func(next Handler) Handler {
+		handler := plugin(next)
+		return func(ctx context.Context, request any, response any) (err error) {
+			ctx, end := tracing.Start(ctx, name)
+			defer func() {
+				if r := recover(); r != nil {
+					end(fmt.Errorf("%v", r))
+					panic(r)
+				}
+				end(err)
+			}()
+
+			return handler(ctx, request, response)
+		}
+	}
+}
+
+// traceHandler 处理函数的span，处理函数拿到的是 *gin.Context，span放在Request的context里
+func traceHandler() Plugin {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, request any, response any) (err error) {
+			name := "mvc handler"
+			var attrs []attribute.KeyValue
+			if c, ok := ctx.(*gin.Context); ok {
+				module, message := c.Param("module"), c.Param("message")
+				name = "mvc " + module + "::" + message
+				attrs = append(attrs, attribute.String("module", module), attribute.String("message", message))
+			}
+
+			ctx, end := tracing.Start(ctx, name, attrs...)
+			defer func() {
+				if r := recover(); r != nil {
+					end(fmt.Errorf("%v", r))
+					panic(r)
+				}
+				end(err)
+			}()
+
+			return next(ctx, request, response)
+		}
+	}
 }
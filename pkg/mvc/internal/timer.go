@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/panics"
+	"espresso/pkg/tracing"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrorNoSuchTimerJob = errors.New("no such timer job")
+	ErrorTimerStopped   = errors.New("timer stopped")
+)
+
+type timerOptions struct {
+	singleton bool
+	owner     string
+}
+
+type TimerOption func(opts *timerOptions)
+
+// Singleton 多实例部署时只有leader执行
+func Singleton() TimerOption {
+	return func(opts *timerOptions) {
+		opts.singleton = true
+	}
+}
+
+// Owner 定时任务所属模块，默认是注册时ctx里的模块uid
+func Owner(owner string) TimerOption {
+	return func(opts *timerOptions) {
+		opts.owner = owner
+	}
+}
+
+// TimerJob 定时任务快照
+type TimerJob struct {
+	Id        cron.EntryID `json:"id"`
+	Owner     string       `json:"owner"`
+	Spec      string       `json:"spec"`
+	Singleton bool         `json:"singleton"`
+	Paused    bool         `json:"paused"`
+	Next      time.Time    `json:"next"`
+	Prev      time.Time    `json:"prev"`
+	LastError string       `json:"lastError,omitempty"`
+}
+
+// timerJob 定时任务，id对外不变，改spec后entryId会变
+type timerJob struct {
+	mvc       *Mvc
+	id        cron.EntryID
+	entryId   cron.EntryID
+	owner     string
+	spec      string
+	singleton bool
+	cmd       func() error
+	paused    atomic.Bool
+
+	prev      time.Time
+	lastError error
+	lastM     sync.Mutex
+}
+
+// Run 实现cron.Job
+func (job *timerJob) Run() {
+	if job.paused.Load() {
+		return
+	}
+
+	// 没有开启选举当作单实例部署
+	if job.singleton && job.mvc.elector != nil && !job.mvc.elector.IsLeader() {
+		return
+	}
+
+	job.run()
+}
+
+func (job *timerJob) run() {
+	startTime := time.Now()
+	ctx := job.mvc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, end := tracing.Start(ctx, "cron "+job.owner, attribute.Int("id", int(job.id)))
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("timer job panic: %w", panics.FromContext(ctx).Capture(panics.SourceTimer, r, job.owner, ""))
+			}
+		}()
+
+		return job.cmd()
+	}()
+	end(err)
+
+	job.lastM.Lock()
+	job.prev = startTime
+	job.lastError = err
+	job.lastM.Unlock()
+
+	if err != nil {
+		job.mvc.logger.Error("timer job fail", zap.Int("id", int(job.id)), zap.String("owner", job.owner), zap.Error(err))
+	}
+}
+
+func (mvc *Mvc) addTimerJob(spec string, cmd func() error, applyOptions ...TimerOption) (cron.EntryID, error) {
+	opts := &timerOptions{}
+	for _, applyOption := range applyOptions {
+		applyOption(opts)
+	}
+
+	job := &timerJob{
+		mvc:       mvc,
+		owner:     opts.owner,
+		spec:      spec,
+		singleton: opts.singleton,
+		cmd:       cmd,
+	}
+
+	mvc.timerJobsM.Lock()
+	defer mvc.timerJobsM.Unlock()
+
+	entryId, err := mvc.timer.AddJob(spec, job)
+	if err != nil {
+		return 0, err
+	}
+
+	job.id = entryId
+	job.entryId = entryId
+	mvc.timerJobs[job.id] = job
+
+	return job.id, nil
+}
+
+func (mvc *Mvc) removeTimerJob(id cron.EntryID) {
+	mvc.timerJobsM.Lock()
+	defer mvc.timerJobsM.Unlock()
+
+	job, ok := mvc.timerJobs[id]
+	if !ok {
+		return
+	}
+
+	mvc.timer.Remove(job.entryId)
+	delete(mvc.timerJobs, id)
+}
+
+func (mvc *Mvc) getTimerJob(id cron.EntryID) (*timerJob, error) {
+	mvc.timerJobsM.RLock()
+	defer mvc.timerJobsM.RUnlock()
+
+	job, ok := mvc.timerJobs[id]
+	if !ok {
+		return nil, ErrorNoSuchTimerJob
+	}
+
+	return job, nil
+}
+
+// TimerJobs 全部定时任务
+func (mvc *Mvc) TimerJobs() []TimerJob {
+	mvc.timerJobsM.RLock()
+	defer mvc.timerJobsM.RUnlock()
+
+	jobs := make([]TimerJob, 0, len(mvc.timerJobs))
+	for _, job := range mvc.timerJobs {
+		entry := mvc.timer.Entry(job.entryId)
+
+		info := TimerJob{
+			Id:        job.id,
+			Owner:     job.owner,
+			Spec:      job.spec,
+			Singleton: job.singleton,
+			Paused:    job.paused.Load(),
+			Next:      entry.Next,
+		}
+
+		job.lastM.Lock()
+		info.Prev = job.prev
+		if job.lastError != nil {
+			info.LastError = job.lastError.Error()
+		}
+		job.lastM.Unlock()
+
+		jobs = append(jobs, info)
+	}
+
+	return jobs
+}
+
+// TriggerTimerJob 马上执行一次，不管是否暂停和是否leader；StopTimer 会等待手动触发的执行完成
+func (mvc *Mvc) TriggerTimerJob(id cron.EntryID) error {
+	mvc.timerJobsM.Lock()
+	defer mvc.timerJobsM.Unlock()
+
+	if mvc.timerStopped {
+		return ErrorTimerStopped
+	}
+
+	job, ok := mvc.timerJobs[id]
+	if !ok {
+		return ErrorNoSuchTimerJob
+	}
+
+	mvc.timerTriggers.Add(1)
+	go func() {
+		defer mvc.timerTriggers.Done()
+		job.run()
+	}()
+
+	return nil
+}
+
+// PauseTimerJob 暂停，到点跳过执行
+func (mvc *Mvc) PauseTimerJob(id cron.EntryID) error {
+	job, err := mvc.getTimerJob(id)
+	if err != nil {
+		return err
+	}
+
+	job.paused.Store(true)
+	return nil
+}
+
+// ResumeTimerJob 恢复
+func (mvc *Mvc) ResumeTimerJob(id cron.EntryID) error {
+	job, err := mvc.getTimerJob(id)
+	if err != nil {
+		return err
+	}
+
+	job.paused.Store(false)
+	return nil
+}
+
+// UpdateTimerJobSpec 修改执行时间，id不变
+func (mvc *Mvc) UpdateTimerJobSpec(id cron.EntryID, spec string) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return err
+	}
+
+	mvc.timerJobsM.Lock()
+	defer mvc.timerJobsM.Unlock()
+
+	job, ok := mvc.timerJobs[id]
+	if !ok {
+		return ErrorNoSuchTimerJob
+	}
+
+	mvc.timer.Remove(job.entryId)
+	job.entryId = mvc.timer.Schedule(schedule, job)
+	job.spec = spec
+
+	return nil
+}
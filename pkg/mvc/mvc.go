@@ -2,50 +2,142 @@ package mvc
 
 import (
 	"context"
+	"espresso/pkg/ctxhelper"
 	"espresso/pkg/mvc/internal"
-	"github.com/gin-gonic/gin"
+	"espresso/pkg/protocol"
+	"espresso/pkg/tasks"
 	"github.com/robfig/cron/v3"
 )
 
+type Mvc = internal.Mvc
 type Plugin = internal.Plugin
 type Handler = internal.Handler
+type TimerOption = internal.TimerOption
+type TimerJob = internal.TimerJob
 
-func NewHttp() gin.HandlerFunc {
-	return internal.GetSingleInst().NewHttp()
+var (
+	ErrorNoSuchMessage   = internal.ErrorNoSuchMessage
+	ErrorMessageMismatch = internal.ErrorMessageMismatch
+	ErrorNoSuchTimerJob  = internal.ErrorNoSuchTimerJob
+	ErrorTimerStopped    = internal.ErrorTimerStopped
+)
+
+// New 独立的mvc，有自己的模块管理器、消息派发、事件和定时器，不和全局的共享，比如测试
+func New() *Mvc {
+	return internal.New()
+}
+
+// Default 全局的mvc，ctx里没有注入mvc时包函数用它，不属于任何App
+func Default() *Mvc {
+	return internal.GetSingleInst()
+}
+
+// Inject 注入mvc，之后用这个ctx发布的事件都发到这个mvc
+func Inject(ctx context.Context, mvc *Mvc) context.Context {
+	return internal.Inject(ctx, mvc)
+}
+
+// FromContext 安装模块的mvc，在ModuleInit、处理函数和任务里用ctx拿到，没有注入则是全局的
+func FromContext(ctx context.Context) *Mvc {
+	return internal.FromContext(ctx)
 }
 
-func Run(ctx context.Context) {
-	internal.GetSingleInst().Run(ctx)
+// ErrorResponse 处理函数返回的错误对应的协议code
+func ErrorResponse(err error) protocol.BaseResponse {
+	return internal.ErrorResponse(err)
 }
 
-func Stop(ctx context.Context) {
-	internal.GetSingleInst().Stop(ctx)
+// 下面的包函数都作用于ctx里的mvc（ModuleInit、处理函数和任务的ctx里是安装模块的App的mvc），
+// App的生命周期（Run、Exit、SetPlugins等）用 FromContext(ctx) 或者 New() 拿到的*Mvc的方法
+
+func Register(ctx context.Context, module, message string, handler any) {
+	internal.FromContext(ctx).Register(module, message, handler)
 }
 
-func Register(module, message string, handler any) {
-	internal.GetSingleInst().Register(module, message, handler)
+// Routes 全部注册的消息，格式 module::message
+func Routes(ctx context.Context) []string {
+	return internal.FromContext(ctx).Routes()
 }
 
+// Publish 发布事件，第一个参数是ctx时发到ctx里的mvc
 func Publish(event string, args ...any) {
+	if len(args) != 0 {
+		if ctx, ok := args[0].(context.Context); ok {
+			internal.FromContext(ctx).Publish(event, args...)
+			return
+		}
+	}
+
 	internal.GetSingleInst().Publish(event, args...)
 }
 
-func Subscribe(event string, callback any) error {
-	return internal.GetSingleInst().Subscribe(event, callback)
+func Subscribe(ctx context.Context, event string, callback any) error {
+	return internal.FromContext(ctx).Subscribe(event, callback)
+}
+
+func UnSubscribe(ctx context.Context, event string, callback any) error {
+	return internal.FromContext(ctx).UnSubscribe(event, callback)
+}
+
+// OnTimeDo 添加定时任务，所属模块是ctx里的模块uid，可以用 Owner 指定
+func OnTimeDo(ctx context.Context, spec string, cmd func(), options ...TimerOption) (cron.EntryID, error) {
+	return internal.FromContext(ctx).OnTimeDo(spec, cmd, withOwner(ctx, options)...)
+}
+
+func OnTimeDoWithError(ctx context.Context, spec string, cmd func() error, options ...TimerOption) (cron.EntryID, error) {
+	return internal.FromContext(ctx).OnTimeDoWithError(spec, cmd, withOwner(ctx, options)...)
+}
+
+// withOwner ctx里的模块uid作为默认的所属模块，放在前面让调用者的 Owner 覆盖
+func withOwner(ctx context.Context, options []TimerOption) []TimerOption {
+	uid := ctxhelper.FetchModuleUID(ctx)
+	if uid == "" {
+		return options
+	}
+
+	return append([]TimerOption{internal.Owner(uid)}, options...)
+}
+
+// Singleton 定时任务只在leader上执行
+func Singleton() TimerOption {
+	return internal.Singleton()
+}
+
+// Owner 定时任务所属模块
+func Owner(owner string) TimerOption {
+	return internal.Owner(owner)
+}
+
+func TimerJobs(ctx context.Context) []TimerJob {
+	return internal.FromContext(ctx).TimerJobs()
+}
+
+func TriggerTimerJob(ctx context.Context, id cron.EntryID) error {
+	return internal.FromContext(ctx).TriggerTimerJob(id)
+}
+
+func PauseTimerJob(ctx context.Context, id cron.EntryID) error {
+	return internal.FromContext(ctx).PauseTimerJob(id)
+}
+
+func ResumeTimerJob(ctx context.Context, id cron.EntryID) error {
+	return internal.FromContext(ctx).ResumeTimerJob(id)
 }
 
-func UnSubscribe(event string, callback any) error {
-	return internal.GetSingleInst().UnSubscribe(event, callback)
+func UpdateTimerJobSpec(ctx context.Context, id cron.EntryID, spec string) error {
+	return internal.FromContext(ctx).UpdateTimerJobSpec(id, spec)
 }
 
-func OnTimeDo(spec string, cmd func()) (cron.EntryID, error) {
-	return internal.GetSingleInst().OnTimeDo(spec, cmd)
+func StopOnTimeDo(ctx context.Context, id cron.EntryID) {
+	internal.FromContext(ctx).StopOnTimeDo(id)
 }
 
-func StopOnTimeDo(id cron.EntryID) {
-	internal.GetSingleInst().StopOnTimeDo(id)
+// RegisterTask 注册任务处理函数
+func RegisterTask(ctx context.Context, name string, handler tasks.Handler) {
+	internal.FromContext(ctx).RegisterTask(name, handler)
 }
 
-func SetPlugins(plugins ...Plugin) {
-	internal.GetSingleInst().SetPlugins(plugins...)
+// Enqueue 投递延迟或者重试任务
+func Enqueue(ctx context.Context, name string, payload any, options ...tasks.EnqueueOption) (string, error) {
+	return internal.FromContext(ctx).Enqueue(name, payload, options...)
 }
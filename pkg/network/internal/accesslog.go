@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/protocol"
+	"github.com/dan-and-dna/minilog"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "******"
+
+// AccessLogConfig 访问日志配置
+type AccessLogConfig struct {
+	Logger      *minilog.MiniLog // 单独的日志，为空使用app的日志
+	SampleRate  float64          // 正常请求的采样率 0~1，错误和慢请求总是记录，默认1
+	SlowTime    time.Duration    // 超过则算慢请求，默认1秒
+	CaptureBody bool             // 是否记录请求和回复内容
+	MaxBodySize int              // 记录内容的最大长度，默认4096
+	Redact      []string         // 需要脱敏的json和表单字段，默认 password、token、secret
+}
+
+// NewAccessLogger 访问日志单独写到文件，json格式，info级别
+func NewAccessLogger(filename string, maxSize, maxBackups, maxAge int) *minilog.MiniLog {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05.000")
+
+	writer := zapcore.AddSync(&lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	})
+
+	return &minilog.MiniLog{
+		Logger: zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), writer, zapcore.InfoLevel)),
+	}
+}
+
+func AccessLog(config AccessLogConfig) Option {
+	return func(opts *options) {
+		if config.SampleRate <= 0 || config.SampleRate > 1 {
+			config.SampleRate = 1
+		}
+		if config.SlowTime <= 0 {
+			config.SlowTime = time.Second
+		}
+		if config.MaxBodySize <= 0 {
+			config.MaxBodySize = 4096
+		}
+		if config.Redact == nil {
+			config.Redact = []string{"password", "token", "secret"}
+		}
+
+		opts.accessLog = &config
+	}
+}
+
+// bodyWriter 记录回复内容的前面一部分
+type bodyWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *bodyWriter) Write(data []byte) (int, error) {
+	if remain := w.limit - w.body.Len(); remain > 0 {
+		if len(data) > remain {
+			w.body.Write(data[:remain])
+		} else {
+			w.body.Write(data)
+		}
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// countReader 统计请求内容的大小
+type countReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// GinAccessLog 访问日志，错误和慢请求总是记录，其他按采样率记录
+func GinAccessLog(config *AccessLogConfig, appLogger *minilog.MiniLog) gin.HandlerFunc {
+	logger := config.Logger
+	if logger == nil {
+		logger = appLogger
+	}
+
+	redact := make(map[string]struct{}, len(config.Redact))
+	for _, key := range config.Redact {
+		redact[strings.ToLower(key)] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// 请求内容
+		var requestBody []byte
+		body := &countReader{ReadCloser: http.NoBody}
+		if c.Request.Body != nil {
+			body.ReadCloser = c.Request.Body
+			if config.CaptureBody {
+				requestBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, int64(config.MaxBodySize)))
+				body.ReadCloser = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(requestBody), c.Request.Body), c.Request.Body}
+			}
+			c.Request.Body = body
+		}
+
+		writer := &bodyWriter{ResponseWriter: c.Writer, limit: config.MaxBodySize}
+		c.Writer = writer
+
+		c.Next()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		code, ok := protocolCode(writer.body.Bytes())
+		if !ok && status == http.StatusOK {
+			code = protocol.CodeOk
+		}
+
+		failed := status >= http.StatusInternalServerError || code != protocol.CodeOk
+		slow := latency >= config.SlowTime
+		if !failed && !slow && config.SampleRate < 1 && rand.Float64() >= config.SampleRate {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("module", c.Param("module")),
+			zap.String("message", c.Param("message")),
+			zap.Int("status", status),
+			zap.Int("code", code),
+			zap.Duration("latency", latency),
+			zap.Int64("bytesIn", body.n),
+			zap.Int("bytesOut", c.Writer.Size()),
+			zap.String("clientIp", c.ClientIP()),
+			zap.String("requestId", ctxhelper.FetchRequestId(c)),
+			zap.Bool("slow", slow),
+		}
+		if config.CaptureBody {
+			fields = append(fields,
+				zap.String("requestBody", redactBody(c.ContentType(), requestBody, redact)),
+				zap.String("responseBody", redactBody(c.Writer.Header().Get("Content-Type"), writer.body.Bytes(), redact)),
+			)
+		}
+
+		switch {
+		case failed:
+			logger.Error("access", fields...)
+		case slow:
+			logger.Warn("access", fields...)
+		default:
+			logger.Info("access", fields...)
+		}
+	}
+}
+
+// protocolCode 取回复json第一层的code，内容被截断也能取到前面的code
+func protocolCode(body []byte) (int, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return 0, false
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, false
+		}
+
+		if key, _ := token.(string); key == "code" {
+			var code int
+			if err := decoder.Decode(&code); err != nil {
+				return 0, false
+			}
+			return code, true
+		}
+
+		// 跳过值
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// redactBody json和表单内容按字段名脱敏，其他没法解析的内容（比如被截断的json）只记录大小
+func redactBody(contentType string, body []byte, redact map[string]struct{}) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	size := "<" + strconv.Itoa(len(body)) + " bytes>"
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if strings.TrimSpace(strings.ToLower(mediaType)) == binding.MIMEPOSTForm {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return size
+		}
+
+		for key := range values {
+			if _, ok := redact[strings.ToLower(key)]; ok {
+				values[key] = []string{redacted}
+			}
+		}
+
+		return values.Encode()
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return size
+	}
+
+	data, err := json.Marshal(redactValue(value, redact))
+	if err != nil {
+		return size
+	}
+
+	return string(data)
+}
+
+func redactValue(value any, redact map[string]struct{}) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if _, ok := redact[strings.ToLower(key)]; ok {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(field, redact)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, redact)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	redact := map[string]struct{}{"password": {}, "token": {}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"empty", "application/json", "", ""},
+		{"json", "application/json", `{"name":"a","password":"hunter2"}`, `{"name":"a","password":"******"}`},
+		{"json key case", "application/json", `{"Password":"hunter2"}`, `{"Password":"******"}`},
+		{"json nested", "application/json", `{"users":[{"token":"t","id":1}]}`, `{"users":[{"id":1,"token":"******"}]}`},
+		{"json without content type", "", `{"token":"t"}`, `{"token":"******"}`},
+		{"truncated json", "application/json", `{"name":"a","pass`, "<17 bytes>"},
+		{"form", "application/x-www-form-urlencoded", "name=a&password=hunter2&token=t", "name=a&password=%2A%2A%2A%2A%2A%2A&token=%2A%2A%2A%2A%2A%2A"},
+		{"form with charset", "application/x-www-form-urlencoded; charset=utf-8", "password=hunter2", "password=%2A%2A%2A%2A%2A%2A"},
+		{"bad form", "application/x-www-form-urlencoded", "password=%zz", "<12 bytes>"},
+		{"plain text", "text/plain", "password=hunter2", "<16 bytes>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := redactBody(test.contentType, []byte(test.body), redact); got != test.want {
+				t.Fatalf("redactBody() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
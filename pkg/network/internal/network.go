@@ -2,24 +2,26 @@ package internal
 
 import (
 	"context"
-	"crypto/sha256"
+	"errors"
 	"espresso/pkg/ctxhelper"
 	"espresso/pkg/events"
-	"espresso/pkg/gosafe"
+	"espresso/pkg/logging"
+	"espresso/pkg/panics"
 	"espresso/pkg/protocol"
+	"espresso/pkg/requestid"
+	"espresso/pkg/tracing"
 	"fmt"
 	ginprom "github.com/dan-and-dna/gin-prom"
 	"github.com/dan-and-dna/minilog"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
-	"github.com/kamilsk/tracer"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"math"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -29,22 +31,38 @@ type Network struct {
 
 	httpRoute *gin.Engine
 	httpSrv   *http.Server
+	ready     atomic.Bool
+
+	// 不监听端口时Run等到Shutdown
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 type options struct {
-	httpAddress    string
-	enablePProf    bool
-	logger         *minilog.MiniLog
-	registry       *prometheus.Registry
-	mvcHttpPlugin  gin.HandlerFunc
-	injects        []gin.HandlerFunc
-	moduleContexts []func(context.Context) context.Context
+	enableHttp      bool
+	httpAddress     string
+	enablePProf     bool
+	logger          *minilog.MiniLog
+	registry        *prometheus.Registry
+	mvcHttpPlugin   gin.HandlerFunc
+	injects         []gin.HandlerFunc
+	moduleContexts  []func(context.Context) context.Context
+	requestContexts []func(context.Context) context.Context
+	accessLog       *AccessLogConfig
+	opsRoutes       bool
+	readyChecks     []func() error
+	healthModules   func() []string
+
+	requestIdGenerator requestid.Generator
+	trustRequestId     bool
 }
 
 type Option func(options *options)
 
+// Http http服务，address为空时只创建处理，不监听端口，用 Handler 在进程内调用
 func Http(address string, mvcHttpPlugin gin.HandlerFunc) Option {
 	return func(opts *options) {
+		opts.enableHttp = true
 		opts.httpAddress = address
 		opts.mvcHttpPlugin = mvcHttpPlugin
 	}
@@ -74,11 +92,54 @@ func ModuleContext(f ...func(ctx context.Context) context.Context) Option {
 	}
 }
 
+// RequestContext 每个请求最先注入的context，在recover和模块调用事件之前，比如App自己的mvc
+func RequestContext(f ...func(context.Context) context.Context) Option {
+	return func(opts *options) {
+		opts.requestContexts = append(opts.requestContexts, f...)
+	}
+}
+
+// RequestId 请求id生成器，trustInbound为true时沿用上游传过来的可用的X-Request-Id
+func RequestId(generator requestid.Generator, trustInbound bool) Option {
+	return func(opts *options) {
+		if generator != nil {
+			opts.requestIdGenerator = generator
+		}
+		opts.trustRequestId = trustInbound
+	}
+}
+
+// OpsRoutes 公开端口上的/metric和健康检查，默认打开，有单独的管理端口时关闭
+func OpsRoutes(enable bool) Option {
+	return func(opts *options) {
+		opts.opsRoutes = enable
+	}
+}
+
+// ReadyCheck 就绪检查，返回错误时/health/ready返回503，比如有模块不健康
+func ReadyCheck(checks ...func() error) Option {
+	return func(opts *options) {
+		opts.readyChecks = append(opts.readyChecks, checks...)
+	}
+}
+
+// HealthModules 健康检查的回复里带上安装的模块
+func HealthModules(modules func() []string) Option {
+	return func(opts *options) {
+		opts.healthModules = modules
+	}
+}
+
 func New(applyOptions ...Option) *Network {
 	gin.SetMode(gin.ReleaseMode)
 
 	network := &Network{
-		opts: &options{},
+		stopCh: make(chan struct{}),
+		opts: &options{
+			requestIdGenerator: requestid.ULID(),
+			trustRequestId:     true,
+			opsRoutes:          true,
+		},
 	}
 
 	// 应用全部配置
@@ -86,36 +147,62 @@ func New(applyOptions ...Option) *Network {
 		applyOption(network.opts)
 	}
 
+	// 没有http服务时不挂公开端口的路由
+	if !network.opts.enableHttp {
+		return network
+	}
+
 	// http监听端口
-	if network.opts.httpAddress != "" {
-		network.httpRoute = gin.New()
+	network.httpRoute = gin.New()
 
-		network.httpSrv = &http.Server{
-			Addr:    network.opts.httpAddress,
-			Handler: network.httpRoute,
-		}
+	network.httpSrv = &http.Server{
+		Addr:    network.opts.httpAddress,
+		Handler: network.httpRoute,
+	}
 
-		// pprof
-		if network.opts.enablePProf {
-			pprof.Register(network.httpRoute)
-		}
+	// pprof
+	if network.opts.enablePProf {
+		pprof.Register(network.httpRoute)
+	}
+
+	// 健康检查
+	if network.opts.opsRoutes {
+		network.RegisterHealth(network.httpRoute)
+	}
+
+	// 最先注入，崩溃和模块调用的事件也能拿到
+	if len(network.opts.requestContexts) != 0 {
+		network.httpRoute.Use(func(c *gin.Context) {
+			for _, requestContext := range network.opts.requestContexts {
+				requestContext(c)
+			}
+			c.Next()
+		})
+	}
+
+	// 访问日志，放在recover前面才能记录panic的请求
+	if network.opts.accessLog != nil {
+		network.httpRoute.Use(GinAccessLog(network.opts.accessLog, network.opts.logger))
 	}
 
 	// 路由
-	var count atomic.Uint64
 	var plugins []gin.HandlerFunc
 	if network.opts.registry != nil {
 		// metric插件
 		metrics := ginprom.NewMetrics("default", network.opts.registry)
-		network.httpRoute.GET("/metric", GinPromHandler(promhttp.HandlerFor(network.opts.registry, promhttp.HandlerOpts{})))
+		if network.opts.opsRoutes {
+			network.httpRoute.GET("/metric", GinPromHandler(promhttp.HandlerFor(network.opts.registry, promhttp.HandlerOpts{})))
+		}
 
 		// 其他插件
 		network.httpRoute.Use(GinSetRecover())
 		if network.opts.mvcHttpPlugin != nil {
 			plugins = append(plugins,
-				GinSetLogger(network.opts.logger),
 				ginprom.Export(metrics),
-				GinWitheRequestId(&count),
+				GinTrace(),
+				GinWitheRequestId(network.opts.requestIdGenerator, network.opts.trustRequestId),
+				GinModuleCall(),
+				GinSetLogger(network.opts.logger),
 				GinFetchMetadata(),
 				func(c *gin.Context) {
 					for _, moduleContext := range network.opts.moduleContexts {
@@ -136,8 +223,10 @@ func New(applyOptions ...Option) *Network {
 		network.httpRoute.Use(GinSetRecover())
 		if network.opts.mvcHttpPlugin != nil {
 			plugins = append(plugins,
+				GinTrace(),
+				GinWitheRequestId(network.opts.requestIdGenerator, network.opts.trustRequestId),
+				GinModuleCall(),
 				GinSetLogger(network.opts.logger),
-				GinWitheRequestId(&count),
 				GinFetchMetadata(),
 				func(c *gin.Context) {
 					for _, moduleContext := range network.opts.moduleContexts {
@@ -157,53 +246,114 @@ func New(applyOptions ...Option) *Network {
 	return network
 }
 
+// Run 监听http请求，直到Shutdown或者监听失败
 func (network *Network) Run(ctx context.Context) error {
 	logger := network.opts.logger
-	if err := network.httpRoute.SetTrustedProxies(nil); err != nil {
-		logger.Error("service run", zap.Error(err), zap.String("service", "network"), zap.Bool("result", false))
+	if network.httpRoute != nil {
+		if err := network.httpRoute.SetTrustedProxies(nil); err != nil {
+			logger.Error("service run", zap.Error(err), zap.String("service", "network"), zap.Bool("result", false))
+			return err
+		}
+	}
+
+	// 没有http服务或者只在进程内调用
+	if !network.opts.enableHttp || network.opts.httpAddress == "" {
+		logger.Info("service run", zap.Bool("listen", false), zap.Bool("result", true), zap.String("service", "network"))
+		<-network.stopCh
+		logger.Info("service exit", zap.String("service", "network"), zap.Bool("result", true))
+		return nil
+	}
+
+	logger.Info("service run", zap.String("listenAddress", network.opts.httpAddress), zap.Bool("result", true), zap.String("service", "network"))
+	if err := network.httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("service run", zap.Error(err), zap.Bool("result", false), zap.String("service", "network"))
 		return err
 	}
 
-	// 监听http请求
-	gosafe.GoSafe(ctx,
-		// 协程逻辑
-		func(ctx context.Context) {
-			logger.Info("service run", zap.String("listenAddress", network.opts.httpAddress), zap.Bool("result", true), zap.String("service", "network"))
-			if err := network.httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Error("service run", zap.Error(err), zap.Bool("result", false), zap.String("service", "network"))
-				return
-			}
-		},
-		// 退出前钩子
-		func(ctx context.Context, err error) {
-			if err != nil {
-				logger.Error("service exit", zap.Error(err), zap.String("service", "network"), zap.Bool("result", false))
-				return
-			}
+	logger.Info("service exit", zap.String("service", "network"), zap.Bool("result", true))
+	return nil
+}
 
-			logger.Info("service exit", zap.String("service", "network"), zap.Bool("result", true))
-		})
+// Shutdown 不再接收新请求，等待进行中的请求完成，ctx超时后强制关闭
+func (network *Network) Shutdown(ctx context.Context) error {
+	logger := network.opts.logger
+	logger.Warn("service shutdown", zap.String("service", "network"))
+	network.stopOnce.Do(func() {
+		close(network.stopCh)
+	})
+
+	if network.httpSrv == nil {
+		logger.Info("service shutdown", zap.String("service", "network"), zap.Bool("result", true))
+		return nil
+	}
 
-	select {
-	case <-ctx.Done():
-		logger.Warn("receive stop signal....")
-		logger.Warn("service shutdown", zap.String("service", "network"))
-		// 不再接收新请求，等待5秒如果还没有完成，直接强制关闭这些请求
-		func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			// 不再接收http请求
-			if err := network.httpSrv.Shutdown(ctx); err != nil {
-				logger.Error("service shutdown", zap.Error(err), zap.String("service", "network"), zap.Bool("result", false))
+	if err := network.httpSrv.Shutdown(ctx); err != nil {
+		logger.Error("service shutdown", zap.Error(err), zap.String("service", "network"), zap.Bool("result", false))
+		_ = network.httpSrv.Close()
+		return err
+	}
+
+	logger.Info("service shutdown", zap.String("service", "network"), zap.Bool("result", true))
+	return nil
+}
+
+// healthResponse 健康检查的回复
+type healthResponse struct {
+	protocol.BaseResponse
+	Modules []string `json:"modules,omitempty"`
+}
+
+// RegisterHealth 注册健康检查，live表示进程存活，ready表示可以接收请求
+func (network *Network) RegisterHealth(router gin.IRouter) {
+	health := func(c *gin.Context, status int, msg string) {
+		response := healthResponse{BaseResponse: protocol.BaseResponse{Code: protocol.CodeOk, Msg: msg}}
+		if status != http.StatusOK {
+			response.Code = protocol.CodeInternalError
+		}
+		if network.opts.healthModules != nil {
+			response.Modules = network.opts.healthModules()
+		}
+		c.JSON(status, response)
+	}
+
+	router.GET("/health/live", func(c *gin.Context) {
+		health(c, http.StatusOK, "")
+	})
+	router.GET("/health/ready", func(c *gin.Context) {
+		if !network.ready.Load() {
+			health(c, http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		for _, check := range network.opts.readyChecks {
+			if err := check(); err != nil {
+				health(c, http.StatusServiceUnavailable, err.Error())
 				return
 			}
+		}
+		health(c, http.StatusOK, "")
+	})
+}
 
-			logger.Info("service shutdown", zap.String("service", "network"), zap.Bool("result", true))
-		}()
+// Handler 公开端口的http处理，比如用httptest测试；没有http服务时全部返回404
+func (network *Network) Handler() http.Handler {
+	if network.httpRoute == nil {
+		return http.NotFoundHandler()
 	}
 
-	return nil
+	return network.httpRoute
+}
+
+// Routes 公开端口上的全部路由
+func (network *Network) Routes() gin.RoutesInfo {
+	if network.httpRoute == nil {
+		return nil
+	}
+	return network.httpRoute.Routes()
+}
+
+// SetReady 设置是否就绪，未就绪时负载均衡不再转发新请求
+func (network *Network) SetReady(ready bool) {
+	network.ready.Store(ready)
 }
 
 func GinPromHandler(handler http.Handler) gin.HandlerFunc {
@@ -212,13 +362,55 @@ func GinPromHandler(handler http.Handler) gin.HandlerFunc {
 	}
 }
 
+// GinSetLogger 注入请求的子日志，带上requestId、客户端ip、模块、消息和traceId，按模块日志级别过滤
 func GinSetLogger(logger *minilog.MiniLog) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctxhelper.InjectLogger(c, logger)
+		module := c.Param("module")
+		fields := []zap.Field{
+			zap.String("requestId", ctxhelper.FetchRequestId(c)),
+			zap.String("clientIp", c.ClientIP()),
+			zap.String("module", module),
+			zap.String("message", c.Param("message")),
+		}
+		if traceId := tracing.TraceId(c); traceId != "" {
+			fields = append(fields, zap.String("traceId", traceId))
+		}
+
+		ctxhelper.InjectLogger(c, logging.FromContext(c).With(logger, module, fields...))
 		c.Next()
 	}
 }
 
+// GinTrace 入口span，继承上游的W3C traceparent，回复里带上traceparent
+func GinTrace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(tracing.Extract(c.Request.Context(), c.Request.Header))
+
+		_, end := tracing.StartWithKind(c, "HTTP "+c.Request.Method+" "+c.FullPath(), trace.SpanKindServer,
+			semconv.HTTPMethod(c.Request.Method),
+			semconv.HTTPRoute(c.FullPath()),
+			semconv.HTTPClientIP(c.ClientIP()),
+		)
+		defer func() {
+			if r := recover(); r != nil {
+				end(fmt.Errorf("%v", r))
+				panic(r)
+			}
+		}()
+		tracing.Inject(c, c.Writer.Header())
+
+		c.Next()
+
+		status := c.Writer.Status()
+		tracing.SpanFromContext(c).SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			end(errors.New(http.StatusText(status)))
+			return
+		}
+		end(nil)
+	}
+}
+
 func GinSetRecover() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
@@ -226,9 +418,9 @@ func GinSetRecover() gin.HandlerFunc {
 			if r != nil {
 				module := c.Param("module")
 				message := c.Param("message")
-				requestId, _ := ctxhelper.Get(c, "requestId").(string)
-				err := fmt.Errorf("%v", r)
-				events.PublishModuleCallPanic(c, module, message, err)
+				requestId := ctxhelper.FetchRequestId(c)
+				p := panics.FromContext(c).Capture(panics.SourceHttp, r, module, message)
+				events.PublishModuleCallPanic(c, module, message, p)
 				c.JSON(500, protocol.BaseResponse{
 					Code:      protocol.CodeInternalError,
 					Msg:       "internal error",
@@ -241,29 +433,64 @@ func GinSetRecover() gin.HandlerFunc {
 	}
 }
 
-func GinWitheRequestId(count *atomic.Uint64) gin.HandlerFunc {
+// GinWitheRequestId 请求id，上游传过来的可用就沿用，否则生成新的，回复header里带上请求id
+func GinWitheRequestId(generator requestid.Generator, trustInbound bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		count.Add(1)
-		count.CompareAndSwap(math.MaxUint64, 0)
+		requestId := ""
+		if trustInbound {
+			if inbound := c.GetHeader(requestid.Header); requestid.Valid(inbound) {
+				requestId = inbound
+			}
+		}
+		if requestId == "" {
+			requestId = generator.Generate()
+		}
 
-		// FIXME 不是很高效
-		paths := []string{c.FullPath(), c.ClientIP(), strconv.FormatInt(time.Now().UnixNano(), 10), strconv.FormatUint(count.Load(), 10)}
-		requestId := fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(paths, "_"))))
 		// 注入请求id
 		ctxhelper.InjectRequestId(c, requestId)
+		c.Header(requestid.Header, requestId)
 
-		// FIXME 精简这种操作
-		ctx := tracer.Inject(context.Background(), make([]*tracer.Call, 0, 30))
-		t := tracer.Fetch(ctx)
-
-		ctxhelper.InjectTrace(c, t)
+		c.Next()
+	}
+}
 
+// GinModuleCall 发布模块调用的开始和结束事件，带上耗时、协议code、请求和回复大小
+func GinModuleCall() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		module := c.Param("module")
 		message := c.Param("message")
-		defer events.PublishModuleCall(c, module, message, time.Now().UnixNano())
+		startTime := time.Now()
+
+		writer := &bodyWriter{ResponseWriter: c.Writer, limit: 256}
+		c.Writer = writer
+
+		events.PublishModuleCallStart(c, module, message)
+		defer func() {
+			event := events.EventModuleCall{
+				Module:   module,
+				Function: message,
+				CostTime: time.Since(startTime).Nanoseconds(),
+			}
+			if c.Request.ContentLength > 0 {
+				event.RequestSize = c.Request.ContentLength
+			}
+			if size := c.Writer.Size(); size > 0 {
+				event.ResponseSize = int64(size)
+			}
+
+			r := recover()
+			if r != nil {
+				event.Code = protocol.CodeInternalError
+			} else if code, ok := protocolCode(writer.body.Bytes()); ok {
+				event.Code = code
+			}
+
+			events.PublishModuleCall(c, event)
+			if r != nil {
+				panic(r)
+			}
+		}()
 
-		call := t.Start(requestId)
-		defer call.Stop()
 		c.Next()
 	}
 }
@@ -275,6 +502,10 @@ func GinFetchMetadata() gin.HandlerFunc {
 		for key, val := range c.Request.Header {
 			metadata[key] = val
 		}
+		// 用实际的请求id，上游传过来的可能没通过校验或者没有
+		if requestId := ctxhelper.FetchRequestId(c); requestId != "" {
+			metadata[requestid.Header] = []string{requestId}
+		}
 
 		metadata["FullPath"] = []string{c.FullPath()}
 		metadata["Uri"] = []string{c.Request.RequestURI}
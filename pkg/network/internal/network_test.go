@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewWithoutHttp(t *testing.T) {
+	// 只有运维相关的选项，没有 Http
+	network := New(PProf(true), OpsRoutes(true), ReadyCheck(func() error { return nil }))
+
+	recorder := httptest.NewRecorder()
+	network.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("status = %d", recorder.Code)
+	}
+	if routes := network.Routes(); len(routes) != 0 {
+		t.Fatalf("routes = %+v", routes)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- network.Run(context.Background())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := network.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Run not return after Shutdown")
+	}
+}
+
+func TestNewInProcess(t *testing.T) {
+	network := New(Http("", nil))
+
+	recorder := httptest.NewRecorder()
+	network.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d", recorder.Code)
+	}
+}
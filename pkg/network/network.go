@@ -3,6 +3,7 @@ package network
 import (
 	"context"
 	"espresso/pkg/network/internal"
+	"espresso/pkg/requestid"
 	"github.com/dan-and-dna/minilog"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,6 +11,7 @@ import (
 
 type Network = internal.Network
 type Option = internal.Option
+type AccessLogConfig = internal.AccessLogConfig
 
 func PProf(enable bool) Option {
 	return internal.PProf(enable)
@@ -23,6 +25,7 @@ func Metric(registry *prometheus.Registry) Option {
 	return internal.Metric(registry)
 }
 
+// Http http服务，address为空时只创建处理，不监听端口
 func Http(address string, mvc gin.HandlerFunc) Option {
 	return internal.Http(address, mvc)
 }
@@ -31,6 +34,41 @@ func ModuleContext(f ...func(ctx context.Context) context.Context) Option {
 	return internal.ModuleContext(f...)
 }
 
+// OpsRoutes 公开端口上的/metric和健康检查，默认打开，有单独的管理端口时关闭
+func OpsRoutes(enable bool) Option {
+	return internal.OpsRoutes(enable)
+}
+
+// RequestContext 每个请求最先注入的context，在recover和模块调用事件之前
+func RequestContext(f ...func(ctx context.Context) context.Context) Option {
+	return internal.RequestContext(f...)
+}
+
+// ReadyCheck 就绪检查，返回错误时/health/ready返回503
+func ReadyCheck(checks ...func() error) Option {
+	return internal.ReadyCheck(checks...)
+}
+
+// HealthModules 健康检查的回复里带上安装的模块
+func HealthModules(modules func() []string) Option {
+	return internal.HealthModules(modules)
+}
+
 func New(options ...Option) *Network {
 	return internal.New(options...)
 }
+
+// AccessLog 访问日志，错误和慢请求总是记录，其他按采样率记录
+func AccessLog(config AccessLogConfig) Option {
+	return internal.AccessLog(config)
+}
+
+// NewAccessLogger 单独的访问日志文件
+func NewAccessLogger(filename string, maxSize, maxBackups, maxAge int) *minilog.MiniLog {
+	return internal.NewAccessLogger(filename, maxSize, maxBackups, maxAge)
+}
+
+// RequestId 请求id生成器，默认ULID，trustInbound为true时沿用上游传过来的X-Request-Id
+func RequestId(generator requestid.Generator, trustInbound bool) Option {
+	return internal.RequestId(generator, trustInbound)
+}
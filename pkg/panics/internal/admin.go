@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"espresso/pkg/protocol"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RegisterAdmin 注册崩溃分组的查看接口
+func (panics *Panics) RegisterAdmin(router gin.IRouter) {
+	router.GET("/panics", func(c *gin.Context) {
+		c.JSON(http.StatusOK, struct {
+			protocol.BaseResponse
+			Groups []Group `json:"groups"`
+		}{
+			BaseResponse: protocol.BaseResponse{Code: protocol.CodeOk},
+			Groups:       panics.Groups(),
+		})
+	})
+}
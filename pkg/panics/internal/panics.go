@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"espresso/pkg/ctxhelper"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SourceHttp     = "http"
+	SourceGoSafe   = "gosafe"
+	SourceModule   = "module"
+	SourceTimer    = "timer"
+	SourceTask     = "task"
+	SourceShutdown = "shutdown"
+)
+
+// 分组数上限，超过后新的分组只计数不保存
+const maxGroups = 1000
+
+// Panic 一次崩溃，实现error，Unwrap得到原始的error
+type Panic struct {
+	Source      string    `json:"source"`
+	Module      string    `json:"module,omitempty"`
+	Function    string    `json:"function,omitempty"`
+	Message     string    `json:"message"`
+	Stack       string    `json:"stack"`
+	Fingerprint string    `json:"fingerprint"`
+	Count       int64     `json:"count"` // 同一分组到目前为止的次数
+	Time        time.Time `json:"time"`
+
+	value  any
+	report bool
+}
+
+func (p *Panic) Error() string {
+	return p.Message
+}
+
+func (p *Panic) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
+
+// Value recover()拿到的原始值
+func (p *Panic) Value() any {
+	return p.value
+}
+
+// Group 按调用栈指纹分组
+type Group struct {
+	Fingerprint string    `json:"fingerprint"`
+	Count       int64     `json:"count"`
+	First       time.Time `json:"first"`
+	Last        time.Time `json:"last"`
+	Sample      *Panic    `json:"sample"` // 第一次的崩溃
+	reported    time.Time
+}
+
+// Reporter 崩溃的去处，比如sentry类的http服务、webhook、本地文件
+type Reporter interface {
+	Report(ctx context.Context, p *Panic) error
+}
+
+type Panics struct {
+	groups  map[string]*Group
+	dropped int64
+	groupsM sync.Mutex
+
+	reporters      []Reporter
+	hooks          []func(p *Panic)
+	reportInterval time.Duration
+	logger         *minilog.MiniLog
+	optionsM       sync.RWMutex
+
+	queue  chan *Panic
+	wait   sync.WaitGroup
+	closed bool // 受optionsM保护，关闭后只记录分组，不再上报
+	done   chan struct{}
+}
+
+var (
+	singleInst *Panics
+	once       sync.Once
+	contextKey = ctxhelper.NewKey[*Panics]("core_panics")
+)
+
+func GetSingleInst() *Panics {
+	if singleInst == nil {
+		once.Do(func() {
+			singleInst = New()
+		})
+	}
+	return singleInst
+}
+
+// New 独立的崩溃记录，有自己的分组、钩子和上报，比如每个App一个
+func New() *Panics {
+	panics := &Panics{
+		groups:         make(map[string]*Group),
+		reportInterval: time.Minute,
+		logger:         &minilog.MiniLog{Logger: zap.NewNop()},
+		queue:          make(chan *Panic, 256),
+		done:           make(chan struct{}),
+	}
+	go panics.loop()
+
+	return panics
+}
+
+// Inject 注入崩溃记录，之后用这个ctx捕获的崩溃都记到这里
+func Inject(ctx context.Context, panics *Panics) context.Context {
+	return contextKey.Inject(ctx, panics)
+}
+
+// FromContext ctx里的崩溃记录，没有注入则是全局的
+func FromContext(ctx context.Context) *Panics {
+	if ctx != nil {
+		if panics, ok := contextKey.Fetch(ctx); ok && panics != nil {
+			return panics
+		}
+	}
+
+	return GetSingleInst()
+}
+
+// AddReporter 添加崩溃的去处
+func (panics *Panics) AddReporter(reporters ...Reporter) {
+	panics.optionsM.Lock()
+	defer panics.optionsM.Unlock()
+
+	panics.reporters = append(panics.reporters, reporters...)
+}
+
+// OnPanic 每次崩溃都在后台调用，不受上报间隔影响
+func (panics *Panics) OnPanic(hook func(p *Panic)) {
+	panics.optionsM.Lock()
+	defer panics.optionsM.Unlock()
+
+	panics.hooks = append(panics.hooks, hook)
+}
+
+// SetReportInterval 同一分组上报的最短间隔，第一次总是上报，默认1分钟
+func (panics *Panics) SetReportInterval(interval time.Duration) {
+	panics.optionsM.Lock()
+	defer panics.optionsM.Unlock()
+
+	panics.reportInterval = interval
+}
+
+// SetLogger 上报失败的日志
+func (panics *Panics) SetLogger(logger *minilog.MiniLog) {
+	panics.optionsM.Lock()
+	defer panics.optionsM.Unlock()
+
+	panics.logger = logger
+}
+
+// Capture 在recover之后调用，记录调用栈、分组计数，在后台上报；r是recover()的返回值
+func (panics *Panics) Capture(source string, r any, module, function string) *Panic {
+	frames := panicFrames()
+
+	p := &Panic{
+		Source:      source,
+		Module:      module,
+		Function:    function,
+		Message:     fmt.Sprintf("%v", r),
+		Stack:       formatFrames(frames),
+		Fingerprint: fingerprint(frames),
+		Time:        time.Now(),
+		value:       r,
+	}
+
+	panics.optionsM.RLock()
+	reportInterval := panics.reportInterval
+	panics.optionsM.RUnlock()
+
+	panics.groupsM.Lock()
+	group, ok := panics.groups[p.Fingerprint]
+	if !ok {
+		if len(panics.groups) >= maxGroups {
+			panics.dropped++
+			group = &Group{}
+		} else {
+			group = &Group{Fingerprint: p.Fingerprint, First: p.Time, Sample: p}
+			panics.groups[p.Fingerprint] = group
+		}
+	}
+	group.Count++
+	group.Last = p.Time
+	p.Count = group.Count
+	if group.reported.IsZero() || p.Time.Sub(group.reported) >= reportInterval {
+		group.reported = p.Time
+		p.report = true
+	}
+	panics.groupsM.Unlock()
+
+	// 队列满了不阻塞崩溃的地方；先Add，后台处理完Done时计数不会变成负数
+	panics.optionsM.RLock()
+	if !panics.closed {
+		panics.wait.Add(1)
+		select {
+		case panics.queue <- p:
+		default:
+			panics.wait.Done()
+		}
+	}
+	panics.optionsM.RUnlock()
+
+	return p
+}
+
+// Groups 全部分组，次数多的在前面
+func (panics *Panics) Groups() []Group {
+	panics.groupsM.Lock()
+	defer panics.groupsM.Unlock()
+
+	groups := make([]Group, 0, len(panics.groups))
+	for _, group := range panics.groups {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Count > groups[j].Count
+	})
+
+	return groups
+}
+
+// Flush 等待已经捕获的崩溃上报完，或者ctx超时
+func (panics *Panics) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		panics.wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 处理完已经捕获的崩溃后停止后台上报，之后的崩溃只记录分组；可以重复调用
+func (panics *Panics) Close() {
+	panics.optionsM.Lock()
+	defer panics.optionsM.Unlock()
+
+	if panics.closed {
+		return
+	}
+	panics.closed = true
+	close(panics.done)
+}
+
+func (panics *Panics) loop() {
+	for {
+		select {
+		case p := <-panics.queue:
+			panics.handle(p)
+		case <-panics.done:
+			// 关闭前入队的还要处理完
+			for {
+				select {
+				case p := <-panics.queue:
+					panics.handle(p)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (panics *Panics) handle(p *Panic) {
+	defer panics.wait.Done()
+
+	panics.optionsM.RLock()
+	hooks, reporters, logger := panics.hooks, panics.reporters, panics.logger
+	panics.optionsM.RUnlock()
+
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("panic hook panic", zap.Any("reason", r))
+				}
+			}()
+			hook(p)
+		}()
+	}
+
+	if !p.report {
+		return
+	}
+
+	for _, reporter := range reporters {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := reporter.Report(ctx, p); err != nil {
+			logger.Error("report panic", zap.String("fingerprint", p.Fingerprint), zap.Bool("result", false), zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// panicFrames 崩溃处的调用栈，跳过recover相关的部分
+func panicFrames() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+
+	var frames []runtime.Frame
+	all := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := all.Next()
+		frames = append(frames, frame)
+		if !more {
+			break
+		}
+	}
+
+	// 最后一个runtime.gopanic之后才是最初崩溃的地方，前面的是recover后又panic的中间件
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].Function == "runtime.gopanic" {
+			return frames[i+1:]
+		}
+	}
+	return frames
+}
+
+func formatFrames(frames []runtime.Frame) string {
+	var builder strings.Builder
+	for _, frame := range frames {
+		fmt.Fprintf(&builder, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return builder.String()
+}
+
+// fingerprint 前几层非runtime的函数名，不带行号，改代码后同一个地方的崩溃还在一组
+func fingerprint(frames []runtime.Frame) string {
+	hash := sha1.New()
+	count := 0
+	for _, frame := range frames {
+		if strings.HasPrefix(frame.Function, "runtime.") {
+			continue
+		}
+		hash.Write([]byte(frame.Function))
+		hash.Write([]byte{'\n'})
+
+		count++
+		if count == 8 {
+			break
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// httpReporter 把崩溃的json发到http服务，比如自建的sentry类服务
+type httpReporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func HttpReporter(url string, headers map[string]string) Reporter {
+	return &httpReporter{url: url, headers: headers, client: &http.Client{}}
+}
+
+func (reporter *httpReporter) Report(ctx context.Context, p *Panic) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, reporter.client, reporter.url, reporter.headers, body)
+}
+
+// webhookReporter 钉钉、企业微信机器人的文本消息
+type webhookReporter struct {
+	url    string
+	client *http.Client
+}
+
+func WebhookReporter(url string) Reporter {
+	return &webhookReporter{url: url, client: &http.Client{}}
+}
+
+func (reporter *webhookReporter) Report(ctx context.Context, p *Panic) error {
+	content := fmt.Sprintf("panic [%s] %s %s\nmessage: %s\nfingerprint: %s count: %d\n%s",
+		p.Source, p.Module, p.Function, p.Message, p.Fingerprint, p.Count, p.Stack)
+
+	body, err := json.Marshal(map[string]any{
+		"msgtype": "text",
+		"text":    map[string]string{"content": content},
+	})
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, reporter.client, reporter.url, nil, body)
+}
+
+// fileReporter 每行一个json追加到本地文件
+type fileReporter struct {
+	path string
+	m    sync.Mutex
+}
+
+func FileReporter(path string) Reporter {
+	return &fileReporter{path: path}
+}
+
+func (reporter *fileReporter) Report(ctx context.Context, p *Panic) error {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	reporter.m.Lock()
+	defer reporter.m.Unlock()
+
+	file, err := os.OpenFile(reporter.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("report panic %s: %s %s", url, response.Status, bytes.TrimSpace(message))
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	return nil
+}
@@ -0,0 +1,98 @@
+package panics
+
+import (
+	"context"
+	"espresso/pkg/panics/internal"
+	"github.com/dan-and-dna/minilog"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+type Panics = internal.Panics
+type Panic = internal.Panic
+type Group = internal.Group
+type Reporter = internal.Reporter
+
+const (
+	SourceHttp     = internal.SourceHttp
+	SourceGoSafe   = internal.SourceGoSafe
+	SourceModule   = internal.SourceModule
+	SourceTimer    = internal.SourceTimer
+	SourceTask     = internal.SourceTask
+	SourceShutdown = internal.SourceShutdown
+)
+
+// New 独立的崩溃记录，有自己的分组、钩子和上报，不和全局的共享，比如每个App一个；不用时Close
+func New() *Panics {
+	return internal.New()
+}
+
+// Default 全局的崩溃记录，ctx里没有注入时用它
+func Default() *Panics {
+	return internal.GetSingleInst()
+}
+
+// Inject 注入崩溃记录，之后用这个ctx捕获的崩溃都记到这里
+func Inject(ctx context.Context, panics *Panics) context.Context {
+	return internal.Inject(ctx, panics)
+}
+
+// FromContext ctx里的崩溃记录，没有注入则是全局的；有ctx的recover处用 FromContext(ctx).Capture
+func FromContext(ctx context.Context) *Panics {
+	return internal.FromContext(ctx)
+}
+
+// Capture 在recover之后调用，记到全局的崩溃记录，按指纹分组计数，在后台上报；返回的*Panic实现error
+func Capture(source string, r any, module, function string) *Panic {
+	return internal.GetSingleInst().Capture(source, r, module, function)
+}
+
+// AddReporter 添加崩溃的去处
+func AddReporter(reporters ...Reporter) {
+	internal.GetSingleInst().AddReporter(reporters...)
+}
+
+// OnPanic 每次崩溃都在后台调用，不受上报间隔影响
+func OnPanic(hook func(p *Panic)) {
+	internal.GetSingleInst().OnPanic(hook)
+}
+
+// SetReportInterval 同一分组上报的最短间隔，第一次总是上报，默认1分钟
+func SetReportInterval(interval time.Duration) {
+	internal.GetSingleInst().SetReportInterval(interval)
+}
+
+// SetLogger 上报失败的日志
+func SetLogger(logger *minilog.MiniLog) {
+	internal.GetSingleInst().SetLogger(logger)
+}
+
+// Groups 全部分组，次数多的在前面
+func Groups() []Group {
+	return internal.GetSingleInst().Groups()
+}
+
+// Flush 等待已经捕获的崩溃上报完
+func Flush(ctx context.Context) error {
+	return internal.GetSingleInst().Flush(ctx)
+}
+
+// RegisterAdmin 注册 GET /panics 查看崩溃分组
+func RegisterAdmin(router gin.IRouter) {
+	internal.GetSingleInst().RegisterAdmin(router)
+}
+
+// HttpReporter 把崩溃的json发到http服务，比如自建的sentry类服务
+func HttpReporter(url string, headers map[string]string) Reporter {
+	return internal.HttpReporter(url, headers)
+}
+
+// WebhookReporter 钉钉、企业微信机器人的文本消息
+func WebhookReporter(url string) Reporter {
+	return internal.WebhookReporter(url)
+}
+
+// FileReporter 每行一个json追加到本地文件
+func FileReporter(path string) Reporter {
+	return internal.FileReporter(path)
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrorNoProfileTarget = errors.New("no profile target")
+)
+
+const (
+	ProfileCPU       = "cpu"
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+	ProfileMutex     = "mutex"
+)
+
+// Profile 一份采集结果，pprof格式（gzip）
+type Profile struct {
+	Kind   string
+	Reason string
+	Start  time.Time
+	End    time.Time
+	Data   []byte
+}
+
+// Sink 采集结果的去处
+type Sink interface {
+	Write(ctx context.Context, profile *Profile) error
+}
+
+type options struct {
+	sinks              []Sink
+	profiles           []string
+	interval           time.Duration
+	cpuDuration        time.Duration
+	heapThreshold      uint64
+	goroutineThreshold uint64
+	checkInterval      time.Duration
+	cooldown           time.Duration
+}
+
+type Option func(opts *options)
+
+// Dir 写到本地目录，最多保留maxFiles个文件，旧的删掉
+func Dir(dir string, maxFiles int) Option {
+	return func(opts *options) {
+		opts.sinks = append(opts.sinks, newDirSink(dir, maxFiles))
+	}
+}
+
+// Pyroscope 推送到pyroscope兼容的地址，比如 http://localhost:4040
+func Pyroscope(url, appName string, tags map[string]string) Option {
+	return func(opts *options) {
+		opts.sinks = append(opts.sinks, newPyroscopeSink(url, appName, tags))
+	}
+}
+
+// WithSink 自定义去处
+func WithSink(sink Sink) Option {
+	return func(opts *options) {
+		opts.sinks = append(opts.sinks, sink)
+	}
+}
+
+// Profiles 采集的种类 cpu、heap、goroutine、mutex，默认全部
+func Profiles(kinds ...string) Option {
+	return func(opts *options) {
+		opts.profiles = kinds
+	}
+}
+
+// Interval 定时采集的间隔，默认1分钟，小于等于0不定时采集，只在异常时采集
+func Interval(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.interval = interval
+	}
+}
+
+// CPUDuration 每次cpu采集的时长，默认10秒
+func CPUDuration(duration time.Duration) Option {
+	return func(opts *options) {
+		opts.cpuDuration = duration
+	}
+}
+
+// HeapThreshold 堆内存超过bytes时采集，0不检查
+func HeapThreshold(bytes uint64) Option {
+	return func(opts *options) {
+		opts.heapThreshold = bytes
+	}
+}
+
+// GoroutineThreshold goroutine数超过n时采集，0不检查
+func GoroutineThreshold(n uint64) Option {
+	return func(opts *options) {
+		opts.goroutineThreshold = n
+	}
+}
+
+// Cooldown 异常触发的采集最短间隔，避免连续的慢调用和崩溃一直采集，默认1分钟
+func Cooldown(cooldown time.Duration) Option {
+	return func(opts *options) {
+		opts.cooldown = cooldown
+	}
+}
+
+// Profiler 持续采集cpu、heap、goroutine和mutex，慢调用、崩溃或者超过阈值时马上采集一次
+type Profiler struct {
+	opts   *options
+	logger *minilog.MiniLog
+
+	capturing   atomic.Bool
+	lastTrigger atomic.Int64
+	wait        sync.WaitGroup
+
+	// 当前Run的ctx，Trigger的采集跟着Run退出
+	ctx  context.Context
+	ctxM sync.RWMutex
+}
+
+func New(applyOptions ...Option) *Profiler {
+	profiler := &Profiler{
+		opts: &options{
+			profiles:      []string{ProfileCPU, ProfileHeap, ProfileGoroutine, ProfileMutex},
+			interval:      time.Minute,
+			cpuDuration:   10 * time.Second,
+			checkInterval: 5 * time.Second,
+			cooldown:      time.Minute,
+		},
+		logger: &minilog.MiniLog{Logger: zap.NewNop()},
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(profiler.opts)
+	}
+
+	return profiler
+}
+
+// Run 定时采集和检查阈值，直到ctx结束，等进行中的采集写完才返回
+func (profiler *Profiler) Run(ctx context.Context) error {
+	logger := ctxhelper.FetchLogger(ctx)
+	profiler.logger = logger
+
+	if len(profiler.opts.sinks) == 0 {
+		logger.Error("service run", zap.Error(ErrorNoProfileTarget), zap.String("service", "profiler"), zap.Bool("result", false))
+		return ErrorNoProfileTarget
+	}
+
+	profiler.ctxM.Lock()
+	profiler.ctx = ctx
+	profiler.ctxM.Unlock()
+
+	// mutex默认不采样
+	if profiler.enabled(ProfileMutex) {
+		old := runtime.SetMutexProfileFraction(5)
+		defer runtime.SetMutexProfileFraction(old)
+	}
+
+	logger.Info("service run", zap.Strings("profiles", profiler.opts.profiles), zap.Duration("interval", profiler.opts.interval),
+		zap.Uint64("heapThreshold", profiler.opts.heapThreshold), zap.Uint64("goroutineThreshold", profiler.opts.goroutineThreshold),
+		zap.Bool("result", true), zap.String("service", "profiler"))
+
+	var tick <-chan time.Time
+	if profiler.opts.interval > 0 {
+		ticker := time.NewTicker(profiler.opts.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var check <-chan time.Time
+	if profiler.opts.heapThreshold > 0 || profiler.opts.goroutineThreshold > 0 {
+		ticker := time.NewTicker(profiler.opts.checkInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	// 超过阈值只在越过的时候触发一次
+	var overHeap, overGoroutine bool
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/sched/goroutines:goroutines"},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 不再接受Trigger，等进行中的采集写完
+			profiler.ctxM.Lock()
+			profiler.ctx = nil
+			profiler.ctxM.Unlock()
+			profiler.wait.Wait()
+			logger.Info("service exit", zap.String("service", "profiler"), zap.Bool("result", true))
+			return nil
+		case <-tick:
+			profiler.capture(ctx, "periodic")
+		case <-check:
+			metrics.Read(samples)
+			heap, goroutines := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+
+			if threshold := profiler.opts.heapThreshold; threshold > 0 {
+				if heap >= threshold && !overHeap {
+					logger.Warn("heap over threshold", zap.Uint64("heap", heap), zap.Uint64("threshold", threshold))
+					profiler.Trigger("heap")
+				}
+				overHeap = heap >= threshold
+			}
+
+			if threshold := profiler.opts.goroutineThreshold; threshold > 0 {
+				if goroutines >= threshold && !overGoroutine {
+					logger.Warn("goroutine over threshold", zap.Uint64("goroutines", goroutines), zap.Uint64("threshold", threshold))
+					profiler.Trigger("goroutine")
+				}
+				overGoroutine = goroutines >= threshold
+			}
+		}
+	}
+}
+
+// Trigger 异常时马上在后台采集一次，冷却时间内或者正在采集时忽略，返回是否开始采集
+func (profiler *Profiler) Trigger(reason string) bool {
+	profiler.ctxM.RLock()
+	defer profiler.ctxM.RUnlock()
+
+	ctx := profiler.ctx
+	if ctx == nil || profiler.capturing.Load() {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	last := profiler.lastTrigger.Load()
+	if now-last < profiler.opts.cooldown.Nanoseconds() || !profiler.lastTrigger.CompareAndSwap(last, now) {
+		return false
+	}
+
+	profiler.wait.Add(1)
+	go func() {
+		defer profiler.wait.Done()
+		profiler.capture(ctx, reason)
+	}()
+	return true
+}
+
+// capture 依次采集全部种类，同一时间只有一个采集
+func (profiler *Profiler) capture(ctx context.Context, reason string) {
+	if !profiler.capturing.CompareAndSwap(false, true) {
+		return
+	}
+	defer profiler.capturing.Store(false)
+
+	for _, kind := range profiler.opts.profiles {
+		profile, err := profiler.collect(ctx, kind)
+		if err != nil {
+			profiler.logger.Error("collect profile", zap.String("kind", kind), zap.String("reason", reason), zap.Bool("result", false), zap.Error(err))
+			continue
+		}
+		profile.Reason = reason
+
+		for _, sink := range profiler.opts.sinks {
+			// 退出时也把采集到的写完
+			writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := sink.Write(writeCtx, profile); err != nil {
+				profiler.logger.Error("write profile", zap.String("kind", kind), zap.String("reason", reason), zap.Bool("result", false), zap.Error(err))
+			}
+			cancel()
+		}
+	}
+
+	profiler.logger.Info("capture profiles", zap.String("reason", reason), zap.Bool("result", true))
+}
+
+func (profiler *Profiler) collect(ctx context.Context, kind string) (*Profile, error) {
+	var buf bytes.Buffer
+	profile := &Profile{Kind: kind, Start: time.Now()}
+
+	switch kind {
+	case ProfileCPU:
+		// 已经有cpu采集时（比如/debug/pprof/profile）会失败
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(profiler.opts.cpuDuration)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(kind)
+		if p == nil {
+			return nil, errors.New("unknown profile " + kind)
+		}
+		if err := p.WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	profile.End = time.Now()
+	profile.Data = buf.Bytes()
+	return profile, nil
+}
+
+func (profiler *Profiler) enabled(kind string) bool {
+	for _, k := range profiler.opts.profiles {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dirSink 写到本地目录，文件名 时间-原因-种类.pb.gz，按文件名排序删掉旧的
+type dirSink struct {
+	dir      string
+	maxFiles int
+	m        sync.Mutex
+}
+
+func newDirSink(dir string, maxFiles int) *dirSink {
+	if maxFiles <= 0 {
+		maxFiles = 100
+	}
+	return &dirSink{dir: dir, maxFiles: maxFiles}
+}
+
+func (sink *dirSink) Write(ctx context.Context, profile *Profile) error {
+	sink.m.Lock()
+	defer sink.m.Unlock()
+
+	if err := os.MkdirAll(sink.dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.pb.gz", profile.Start.Format("20060102-150405.000"), sanitize(profile.Reason), profile.Kind)
+	if err := os.WriteFile(filepath.Join(sink.dir, name), profile.Data, 0644); err != nil {
+		return err
+	}
+
+	return sink.rotate()
+}
+
+func (sink *dirSink) rotate() error {
+	entries, err := os.ReadDir(sink.dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".pb.gz") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) <= sink.maxFiles {
+		return nil
+	}
+
+	sort.Strings(names)
+	for _, name := range names[:len(names)-sink.maxFiles] {
+		if err := os.Remove(filepath.Join(sink.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pyroscopeSink 推送到pyroscope的/ingest，pprof格式
+type pyroscopeSink struct {
+	url     string
+	appName string
+	tags    map[string]string
+	client  *http.Client
+}
+
+func newPyroscopeSink(url, appName string, tags map[string]string) *pyroscopeSink {
+	return &pyroscopeSink{
+		url:     strings.TrimSuffix(url, "/"),
+		appName: appName,
+		tags:    tags,
+		client:  &http.Client{},
+	}
+}
+
+func (sink *pyroscopeSink) Write(ctx context.Context, profile *Profile) error {
+	// 名字 app.cpu{reason=slow,k=v}
+	tags := []string{"reason=" + sanitize(profile.Reason)}
+	for key, value := range sink.tags {
+		tags = append(tags, sanitize(key)+"="+sanitize(value))
+	}
+	sort.Strings(tags)
+
+	query := url.Values{}
+	query.Set("name", sink.appName+"."+profile.Kind+"{"+strings.Join(tags, ",")+"}")
+	query.Set("from", strconv.FormatInt(profile.Start.Unix(), 10))
+	query.Set("until", strconv.FormatInt(profile.End.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+	if profile.Kind == ProfileCPU {
+		query.Set("sampleRate", "100")
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(profile.Data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url+"/ingest?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	response, err := sink.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("pyroscope %s: %s %s", sink.url, response.Status, bytes.TrimSpace(message))
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	return nil
+}
+
+// sanitize 文件名和标签里只保留字母数字和 - _ .
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
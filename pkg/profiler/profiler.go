@@ -0,0 +1,71 @@
+package profiler
+
+import (
+	"espresso/pkg/profiler/internal"
+	"time"
+)
+
+type Profiler = internal.Profiler
+type Profile = internal.Profile
+type Sink = internal.Sink
+type Option = internal.Option
+
+const (
+	ProfileCPU       = internal.ProfileCPU
+	ProfileHeap      = internal.ProfileHeap
+	ProfileGoroutine = internal.ProfileGoroutine
+	ProfileMutex     = internal.ProfileMutex
+)
+
+var (
+	ErrorNoProfileTarget = internal.ErrorNoProfileTarget
+)
+
+// Dir 写到本地目录，最多保留maxFiles个文件，旧的删掉
+func Dir(dir string, maxFiles int) Option {
+	return internal.Dir(dir, maxFiles)
+}
+
+// Pyroscope 推送到pyroscope兼容的地址，比如 http://localhost:4040
+func Pyroscope(url, appName string, tags map[string]string) Option {
+	return internal.Pyroscope(url, appName, tags)
+}
+
+// WithSink 自定义去处
+func WithSink(sink Sink) Option {
+	return internal.WithSink(sink)
+}
+
+// Profiles 采集的种类 cpu、heap、goroutine、mutex，默认全部
+func Profiles(kinds ...string) Option {
+	return internal.Profiles(kinds...)
+}
+
+// Interval 定时采集的间隔，默认1分钟，小于等于0不定时采集
+func Interval(interval time.Duration) Option {
+	return internal.Interval(interval)
+}
+
+// CPUDuration 每次cpu采集的时长，默认10秒
+func CPUDuration(duration time.Duration) Option {
+	return internal.CPUDuration(duration)
+}
+
+// HeapThreshold 堆内存超过bytes时采集，0不检查
+func HeapThreshold(bytes uint64) Option {
+	return internal.HeapThreshold(bytes)
+}
+
+// GoroutineThreshold goroutine数超过n时采集，0不检查
+func GoroutineThreshold(n uint64) Option {
+	return internal.GoroutineThreshold(n)
+}
+
+// Cooldown 异常触发的采集最短间隔，默认1分钟
+func Cooldown(cooldown time.Duration) Option {
+	return internal.Cooldown(cooldown)
+}
+
+func New(options ...Option) *Profiler {
+	return internal.New(options...)
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// crockford ulid使用的base32字母表
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// random 批量读取随机数，减少系统调用
+type random struct {
+	buf [4096]byte
+	off int
+	m   sync.Mutex
+}
+
+func (r *random) read(p []byte) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.off == 0 || r.off+len(p) > len(r.buf) {
+		if _, err := rand.Read(r.buf[:]); err != nil {
+			panic(err)
+		}
+		r.off = 0
+	}
+
+	r.off += copy(p, r.buf[r.off:])
+}
+
+// ULID 26个字符，按时间排序
+type ULID struct {
+	random random
+}
+
+func NewULID() *ULID {
+	return &ULID{}
+}
+
+func (generator *ULID) Generate() string {
+	var data [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	data[0] = byte(ms >> 40)
+	data[1] = byte(ms >> 32)
+	data[2] = byte(ms >> 24)
+	data[3] = byte(ms >> 16)
+	data[4] = byte(ms >> 8)
+	data[5] = byte(ms)
+	generator.random.read(data[6:])
+
+	// 128位，每5位一个字符，最高2位补0
+	var id [26]byte
+	hi := binary.BigEndian.Uint64(data[:8])
+	lo := binary.BigEndian.Uint64(data[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(id[:])
+}
+
+// UUIDv7 RFC 9562 version 7，按时间排序
+type UUIDv7 struct {
+	random random
+}
+
+func NewUUIDv7() *UUIDv7 {
+	return &UUIDv7{}
+}
+
+func (generator *UUIDv7) Generate() string {
+	var data [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	data[0] = byte(ms >> 40)
+	data[1] = byte(ms >> 32)
+	data[2] = byte(ms >> 24)
+	data[3] = byte(ms >> 16)
+	data[4] = byte(ms >> 8)
+	data[5] = byte(ms)
+	generator.random.read(data[6:])
+	data[6] = data[6]&0x0f | 0x70 // version 7
+	data[8] = data[8]&0x3f | 0x80 // variant 10
+
+	var id [36]byte
+	hex.Encode(id[0:8], data[0:4])
+	id[8] = '-'
+	hex.Encode(id[9:13], data[4:6])
+	id[13] = '-'
+	hex.Encode(id[14:18], data[6:8])
+	id[18] = '-'
+	hex.Encode(id[19:23], data[8:10])
+	id[23] = '-'
+	hex.Encode(id[24:], data[10:])
+
+	return string(id[:])
+}
+
+// snowflakeEpoch 2023-01-01 00:00:00 UTC
+const snowflakeEpoch = 1672531200000
+
+// Snowflake 41位毫秒时间、10位节点、12位序号，多实例部署时每个实例的节点不能相同
+type Snowflake struct {
+	node     int64
+	lastMs   int64
+	sequence int64
+	m        sync.Mutex
+}
+
+// NewSnowflake node取值 0~1023
+func NewSnowflake(node int64) *Snowflake {
+	return &Snowflake{node: node & 0x3ff}
+}
+
+func (generator *Snowflake) Generate() string {
+	generator.m.Lock()
+	ms := time.Now().UnixMilli() - snowflakeEpoch
+	if ms <= generator.lastMs {
+		// 同一毫秒或者时钟回拨，沿用上次的时间
+		ms = generator.lastMs
+		generator.sequence = (generator.sequence + 1) & 0xfff
+		if generator.sequence == 0 {
+			// 序号用完，借用下一毫秒
+			ms++
+		}
+	} else {
+		generator.sequence = 0
+	}
+	generator.lastMs = ms
+	id := ms<<22 | generator.node<<12 | generator.sequence
+	generator.m.Unlock()
+
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeneratorFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator Generator
+		format    *regexp.Regexp
+	}{
+		{"ulid", NewULID(), regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+		// 版本7，variant 10
+		{"uuidv7", NewUUIDv7(), regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{"snowflake", NewSnowflake(1), regexp.MustCompile(`^[1-9][0-9]*$`)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen := make(map[string]struct{})
+			for i := 0; i < 1000; i++ {
+				id := test.generator.Generate()
+				if !test.format.MatchString(id) {
+					t.Fatalf("bad id %q", id)
+				}
+				if !Valid(id) {
+					t.Fatalf("id %q is not valid", id)
+				}
+				if _, ok := seen[id]; ok {
+					t.Fatalf("duplicated id %q", id)
+				}
+				seen[id] = struct{}{}
+			}
+		})
+	}
+}
+
+func TestGeneratorTimeOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator Generator
+	}{
+		{"ulid", NewULID()},
+		{"uuidv7", NewUUIDv7()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 前面是毫秒时间，跨毫秒的id按字符串排序
+			first := test.generator.Generate()
+			time.Sleep(2 * time.Millisecond)
+			second := test.generator.Generate()
+			if first >= second {
+				t.Fatalf("%q should sort before %q", first, second)
+			}
+		})
+	}
+}
+
+func TestSnowflakeMonotonic(t *testing.T) {
+	generator := NewSnowflake(1023)
+
+	// 超过一毫秒4096个序号，借用下一毫秒也要递增
+	last := int64(-1)
+	for i := 0; i < 20000; i++ {
+		id, err := strconv.ParseInt(generator.Generate(), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("id %d after %d", id, last)
+		}
+		if node := id >> 12 & 0x3ff; node != 1023 {
+			t.Fatalf("node = %d", node)
+		}
+		last = id
+	}
+}
+
+func TestSnowflakeNode(t *testing.T) {
+	// 超过10位的节点截断
+	id, _ := strconv.ParseInt(NewSnowflake(1024+5).Generate(), 10, 64)
+	if node := id >> 12 & 0x3ff; node != 5 {
+		t.Fatalf("node = %d", node)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"01HZX3J5Q8K9V2M4N6P7R8S9T0", true},
+		{"0190a6f2-1b7c-7d3e-8f4a-5b6c7d8e9f00", true},
+		{"svc-a_1.2:3", true},
+		{"", false},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{"中文", false},
+	}
+
+	for _, test := range tests {
+		if valid := Valid(test.id); valid != test.valid {
+			t.Errorf("Valid(%q) = %v, want %v", test.id, valid, test.valid)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/ctxhelper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// UnaryClientInterceptor 调用其他grpc服务时带上请求id
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, request, reply any, conn *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx), method, request, reply, conn, opts...)
+	}
+}
+
+// StreamClientInterceptor 建立grpc流时带上请求id
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, conn *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, conn, method, opts...)
+	}
+}
+
+// outgoingContext 请求id写到outgoing metadata，已经有了则不覆盖
+func outgoingContext(ctx context.Context) context.Context {
+	requestId := ctxhelper.FetchRequestId(ctx)
+	if requestId == "" {
+		return ctx
+	}
+
+	key := strings.ToLower(Header)
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(key)) != 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, key, requestId)
+}
+
+// UnaryServerInterceptor 自己的grpc服务读取上游metadata里的请求id，校验不通过或者没有则生成，注入ctx并写回回复header
+func UnaryServerInterceptor(generator Generator, trustInbound bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, requestId := incomingContext(ctx, generator, trustInbound)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(Header), requestId))
+
+		return handler(ctx, request)
+	}
+}
+
+// StreamServerInterceptor 同 UnaryServerInterceptor，用于grpc流
+func StreamServerInterceptor(generator Generator, trustInbound bool) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, requestId := incomingContext(stream.Context(), generator, trustInbound)
+		_ = stream.SetHeader(metadata.Pairs(strings.ToLower(Header), requestId))
+
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// incomingContext 取上游的请求id或者生成一个，注入ctx
+func incomingContext(ctx context.Context, generator Generator, trustInbound bool) (context.Context, string) {
+	requestId := ""
+	if trustInbound {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(strings.ToLower(Header)); len(values) != 0 && Valid(values[0]) {
+				requestId = values[0]
+			}
+		}
+	}
+	if requestId == "" {
+		requestId = generator.Generate()
+	}
+
+	return ctxhelper.InjectRequestId(ctx, requestId), requestId
+}
+
+// serverStream 替换流的ctx
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStream) Context() context.Context {
+	return stream.ctx
+}
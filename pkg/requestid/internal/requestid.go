@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/ctxhelper"
+	"net/http"
+)
+
+// Header 请求id的http header，grpc metadata里是小写
+const Header = "X-Request-Id"
+
+// Generator 请求id生成器
+type Generator interface {
+	Generate() string
+}
+
+// Valid 上游传过来的请求id是否可用，1~64个字母、数字或者 -_.:
+func Valid(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Inject 把请求id写到header，调用其他服务时使用
+func Inject(ctx context.Context, header http.Header) {
+	if requestId := ctxhelper.FetchRequestId(ctx); requestId != "" {
+		header.Set(Header, requestId)
+	}
+}
+
+// InjectMetadata 把请求id写到grpc metadata
+func InjectMetadata(ctx context.Context, metadata map[string][]string) {
+	if requestId := ctxhelper.FetchRequestId(ctx); requestId != "" {
+		metadata["x-request-id"] = []string{requestId}
+	}
+}
+
+// Transport 调用其他http服务时带上请求id
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripper(func(request *http.Request) (*http.Response, error) {
+		if requestId := ctxhelper.FetchRequestId(request.Context()); requestId != "" && request.Header.Get(Header) == "" {
+			request = request.Clone(request.Context())
+			request.Header.Set(Header, requestId)
+		}
+
+		return base.RoundTrip(request)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
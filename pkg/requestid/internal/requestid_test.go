@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/ctxhelper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(Header)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+
+	tests := []struct {
+		name      string
+		requestId string
+		header    string
+		want      string
+	}{
+		{"from ctx", "id-1", "", "id-1"},
+		{"no id", "", "", ""},
+		// 调用方自己设置的不覆盖
+		{"keep header", "id-1", "own", "own"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.requestId != "" {
+				ctx = ctxhelper.InjectRequestId(ctx, test.requestId)
+			}
+
+			request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			if test.header != "" {
+				request.Header.Set(Header, test.header)
+			}
+
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = response.Body.Close()
+
+			if got := <-received; got != test.want {
+				t.Fatalf("header = %q, want %q", got, test.want)
+			}
+			// 不修改调用方的请求
+			if test.header == "" && request.Header.Get(Header) != "" {
+				t.Fatal("request header modified")
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"from ctx", ctxhelper.InjectRequestId(context.Background(), "id-1"), []string{"id-1"}},
+		{"no id", context.Background(), nil},
+		{"keep metadata", metadata.AppendToOutgoingContext(ctxhelper.InjectRequestId(context.Background(), "id-1"), "x-request-id", "own"), []string{"own"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			invoker := func(ctx context.Context, method string, request, reply any, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				got = md.Get("x-request-id")
+				return nil
+			}
+
+			if err := interceptor(test.ctx, "/svc/Method", nil, nil, nil, invoker); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) || (len(got) != 0 && got[0] != test.want[0]) {
+				t.Fatalf("metadata = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+type fixedGenerator string
+
+func (generator fixedGenerator) Generate() string {
+	return string(generator)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name         string
+		inbound      string
+		trustInbound bool
+		want         string
+	}{
+		{"trust inbound", "up-1", true, "up-1"},
+		{"invalid inbound", "bad id", true, "generated"},
+		{"no inbound", "", true, "generated"},
+		{"ignore inbound", "up-1", false, "generated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.inbound != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", test.inbound))
+			}
+
+			interceptor := UnaryServerInterceptor(fixedGenerator("generated"), test.trustInbound)
+			var got string
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}, func(ctx context.Context, request any) (any, error) {
+				got = ctxhelper.FetchRequestId(ctx)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("request id = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package requestid
+
+import (
+	"context"
+	"espresso/pkg/requestid/internal"
+	"google.golang.org/grpc"
+	"net/http"
+)
+
+// Header 请求id的http header
+const Header = internal.Header
+
+type Generator = internal.Generator
+
+// ULID 26个字符，按时间排序，默认使用
+func ULID() Generator {
+	return internal.NewULID()
+}
+
+// UUIDv7 按时间排序的uuid
+func UUIDv7() Generator {
+	return internal.NewUUIDv7()
+}
+
+// Snowflake 数字id，node取值 0~1023，多实例部署时每个实例不能相同
+func Snowflake(node int64) Generator {
+	return internal.NewSnowflake(node)
+}
+
+// Valid 上游传过来的请求id是否可用
+func Valid(id string) bool {
+	return internal.Valid(id)
+}
+
+func Inject(ctx context.Context, header http.Header) {
+	internal.Inject(ctx, header)
+}
+
+func InjectMetadata(ctx context.Context, metadata map[string][]string) {
+	internal.InjectMetadata(ctx, metadata)
+}
+
+// UnaryClientInterceptor 调用其他grpc服务时带上请求id，grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor())
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return internal.UnaryClientInterceptor()
+}
+
+// StreamClientInterceptor 建立grpc流时带上请求id，grpc.WithChainStreamInterceptor(requestid.StreamClientInterceptor())
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return internal.StreamClientInterceptor()
+}
+
+// UnaryServerInterceptor 自己的grpc服务读取或者生成请求id，注入ctx并写回回复header，
+// grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor(requestid.ULID(), true))
+func UnaryServerInterceptor(generator Generator, trustInbound bool) grpc.UnaryServerInterceptor {
+	return internal.UnaryServerInterceptor(generator, trustInbound)
+}
+
+// StreamServerInterceptor 同 UnaryServerInterceptor，用于grpc流
+func StreamServerInterceptor(generator Generator, trustInbound bool) grpc.StreamServerInterceptor {
+	return internal.StreamServerInterceptor(generator, trustInbound)
+}
+
+// Transport 调用其他http服务时带上请求id，http.Client{Transport: requestid.Transport(tracing.Transport(nil))}
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return internal.Transport(base)
+}
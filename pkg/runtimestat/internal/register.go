@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"errors"
+	"github.com/arl/statsviz"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"net/http"
+)
+
+// Register 把statsviz和运行时快照挂到路由上，比如管理端口
+func Register(router gin.IRouter) {
+	mux := http.NewServeMux()
+	_ = statsviz.Register(mux)
+
+	router.GET("/debug/statsviz/*filepath", gin.WrapH(mux))
+	router.GET(SnapshotPath, gin.WrapF(SnapshotHandler))
+}
+
+// RegisterCollectors 把go运行时（gc暂停、调度延迟、堆内存分类等runtime/metrics）和进程的metric加到registry，已经注册过的跳过
+func RegisterCollectors(registry prometheus.Registerer) error {
+	goCollector := collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC,
+			collectors.MetricsMemory,
+			collectors.MetricsScheduler,
+		),
+	)
+	processCollector := collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})
+
+	for _, collector := range []prometheus.Collector{goCollector, processCollector} {
+		if err := registry.Register(collector); err != nil {
+			var registered prometheus.AlreadyRegisteredError
+			if errors.As(err, &registered) {
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"time"
+)
+
+const SnapshotPath = "/debug/runtime"
+
+var startTime = time.Now()
+
+// Histogram runtime/metrics的分布，只保留分位数
+type Histogram struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Snapshot 运行时快照，metrics是全部runtime/metrics，key是metric名字
+type Snapshot struct {
+	Time       time.Time      `json:"time"`
+	Pid        int            `json:"pid"`
+	GoVersion  string         `json:"goVersion"`
+	Uptime     string         `json:"uptime"`
+	NumCPU     int            `json:"numCpu"`
+	GOMAXPROCS int            `json:"gomaxprocs"`
+	Goroutines int            `json:"goroutines"`
+	Metrics    map[string]any `json:"metrics"`
+}
+
+// TakeSnapshot 读取全部runtime/metrics
+func TakeSnapshot() Snapshot {
+	descriptions := metrics.All()
+	samples := make([]metrics.Sample, len(descriptions))
+	for i, description := range descriptions {
+		samples[i].Name = description.Name
+	}
+	metrics.Read(samples)
+
+	snapshot := Snapshot{
+		Time:       time.Now(),
+		Pid:        os.Getpid(),
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(startTime).Round(time.Second).String(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		Metrics:    make(map[string]any, len(samples)),
+	}
+
+	for _, sample := range samples {
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			snapshot.Metrics[sample.Name] = sample.Value.Uint64()
+		case metrics.KindFloat64:
+			snapshot.Metrics[sample.Name] = finite(sample.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			snapshot.Metrics[sample.Name] = toHistogram(sample.Value.Float64Histogram())
+		}
+	}
+
+	return snapshot
+}
+
+// SnapshotHandler 返回json格式的运行时快照
+func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	encoder := json.NewEncoder(w)
+	if r.URL.Query().Has("pretty") {
+		encoder.SetIndent("", "  ")
+	}
+	_ = encoder.Encode(TakeSnapshot())
+}
+
+func toHistogram(h *metrics.Float64Histogram) Histogram {
+	var histogram Histogram
+	for _, count := range h.Counts {
+		histogram.Count += count
+	}
+	if histogram.Count == 0 {
+		return histogram
+	}
+
+	// 分位数取所在桶的上界，+Inf的桶取下界
+	quantile := func(q float64) float64 {
+		target := uint64(math.Ceil(q * float64(histogram.Count)))
+		var seen uint64
+		for i, count := range h.Counts {
+			seen += count
+			if seen >= target {
+				return bound(h.Buckets, i)
+			}
+		}
+		return bound(h.Buckets, len(h.Counts)-1)
+	}
+
+	histogram.P50 = quantile(0.5)
+	histogram.P90 = quantile(0.9)
+	histogram.P99 = quantile(0.99)
+	for i := len(h.Counts) - 1; i >= 0; i-- {
+		if h.Counts[i] != 0 {
+			histogram.Max = bound(h.Buckets, i)
+			break
+		}
+	}
+
+	return histogram
+}
+
+// bound 第i个桶的上界，buckets比counts多一个
+func bound(buckets []float64, i int) float64 {
+	if upper := buckets[i+1]; !math.IsInf(upper, 0) {
+		return upper
+	}
+	return finite(buckets[i])
+}
+
+func finite(f float64) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
@@ -2,10 +2,17 @@ package runtimestat
 
 import (
 	"espresso/pkg/runtimestat/internal"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
 )
 
 type RuntimeStat = internal.RuntimeStat
 type Option = internal.Option
+type Snapshot = internal.Snapshot
+type Histogram = internal.Histogram
+
+const SnapshotPath = internal.SnapshotPath
 
 func Address(address string) Option {
 	return internal.Address(address)
@@ -14,3 +21,23 @@ func Address(address string) Option {
 func New(option ...Option) *RuntimeStat {
 	return internal.New(option...)
 }
+
+// Register 把statsviz（/debug/statsviz）和运行时快照（/debug/runtime）挂到路由上，比如管理端口
+func Register(router gin.IRouter) {
+	internal.Register(router)
+}
+
+// RegisterCollectors 把go运行时和进程的metric加到registry，已经注册过的跳过
+func RegisterCollectors(registry prometheus.Registerer) error {
+	return internal.RegisterCollectors(registry)
+}
+
+// TakeSnapshot 读取全部runtime/metrics
+func TakeSnapshot() Snapshot {
+	return internal.TakeSnapshot()
+}
+
+// SnapshotHandler 返回json格式的运行时快照，带上?pretty缩进
+func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	internal.SnapshotHandler(w, r)
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileBackend 本地文件存储，一个任务一个文件，重启后恢复未完成的任务
+type FileBackend struct {
+	pendingDir string
+	deadDir    string
+}
+
+func NewFileBackend(dir string) (*FileBackend, error) {
+	backend := &FileBackend{
+		pendingDir: filepath.Join(dir, "pending"),
+		deadDir:    filepath.Join(dir, "dead"),
+	}
+
+	if err := os.MkdirAll(backend.pendingDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(backend.deadDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func (backend *FileBackend) Save(task *Task) error {
+	return writeTask(backend.pendingDir, task)
+}
+
+func (backend *FileBackend) Remove(id string) error {
+	if err := os.Remove(filepath.Join(backend.pendingDir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (backend *FileBackend) DeadLetter(task *Task) error {
+	if err := writeTask(backend.deadDir, task); err != nil {
+		return err
+	}
+
+	return backend.Remove(task.Id)
+}
+
+func (backend *FileBackend) Load() ([]*Task, error) {
+	return readTasks(backend.pendingDir)
+}
+
+func (backend *FileBackend) DeadLetters() ([]*Task, error) {
+	return readTasks(backend.deadDir)
+}
+
+// writeTask 先写临时文件再改名，避免崩溃留下半个文件
+func writeTask(dir string, task *Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, task.Id+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func readTasks(dir string) ([]*Task, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []*Task
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		task := &Task{}
+		if err := json.Unmarshal(data, task); err != nil {
+			// 损坏的文件跳过
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
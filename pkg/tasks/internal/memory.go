@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"sync"
+)
+
+// MemoryBackend 内存存储，进程退出后未完成的任务丢失；存取的都是副本，worker修改任务不影响存储里的
+type MemoryBackend struct {
+	tasks       map[string]*Task
+	deadLetters []*Task
+	m           sync.Mutex
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		tasks: make(map[string]*Task),
+	}
+}
+
+func (backend *MemoryBackend) Save(task *Task) error {
+	backend.m.Lock()
+	defer backend.m.Unlock()
+
+	backend.tasks[task.Id] = task.clone()
+	return nil
+}
+
+func (backend *MemoryBackend) Remove(id string) error {
+	backend.m.Lock()
+	defer backend.m.Unlock()
+
+	delete(backend.tasks, id)
+	return nil
+}
+
+func (backend *MemoryBackend) DeadLetter(task *Task) error {
+	backend.m.Lock()
+	defer backend.m.Unlock()
+
+	delete(backend.tasks, task.Id)
+	backend.deadLetters = append(backend.deadLetters, task.clone())
+	return nil
+}
+
+func (backend *MemoryBackend) Load() ([]*Task, error) {
+	backend.m.Lock()
+	defer backend.m.Unlock()
+
+	tasks := make([]*Task, 0, len(backend.tasks))
+	for _, task := range backend.tasks {
+		tasks = append(tasks, task.clone())
+	}
+
+	return tasks, nil
+}
+
+func (backend *MemoryBackend) DeadLetters() ([]*Task, error) {
+	backend.m.Lock()
+	defer backend.m.Unlock()
+
+	deadLetters := make([]*Task, 0, len(backend.deadLetters))
+	for _, task := range backend.deadLetters {
+		deadLetters = append(deadLetters, task.clone())
+	}
+
+	return deadLetters, nil
+}
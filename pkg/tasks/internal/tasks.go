@@ -0,0 +1,636 @@
+package internal
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"espresso/pkg/gosafe"
+	"espresso/pkg/panics"
+	"espresso/pkg/tracing"
+	"fmt"
+	"github.com/dan-and-dna/minilog"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorNoSuchTaskHandler = errors.New("no such task handler")
+	ErrorTaskQueueStopped  = errors.New("task queue stopped")
+)
+
+// Task 任务
+type Task struct {
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	ETA         time.Time       `json:"eta"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// Bind 解析任务参数
+func (task *Task) Bind(v any) error {
+	if len(task.Payload) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(task.Payload, v)
+}
+
+// clone 副本，Payload不会被修改，共用
+func (task *Task) clone() *Task {
+	copied := *task
+	return &copied
+}
+
+// Handler 任务处理函数，返回错误会按退避时间重试
+type Handler func(ctx context.Context, task *Task) error
+
+// Backend 任务存储
+type Backend interface {
+	// Save 保存新任务或者更新重试信息
+	Save(task *Task) error
+	// Remove 任务完成
+	Remove(id string) error
+	// DeadLetter 超过最大重试次数，转入死信
+	DeadLetter(task *Task) error
+	// Load 启动时加载未完成的任务
+	Load() ([]*Task, error)
+	// DeadLetters 全部死信
+	DeadLetters() ([]*Task, error)
+}
+
+type options struct {
+	backend     Backend
+	workers     int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+type Option func(opts *options)
+
+// WithBackend 任务存储，默认内存
+func WithBackend(backend Backend) Option {
+	return func(opts *options) {
+		opts.backend = backend
+	}
+}
+
+// Workers 并发处理数
+func Workers(workers int) Option {
+	return func(opts *options) {
+		opts.workers = workers
+	}
+}
+
+// MaxAttempts 默认最大尝试次数
+func MaxAttempts(maxAttempts int) Option {
+	return func(opts *options) {
+		opts.maxAttempts = maxAttempts
+	}
+}
+
+// Backoff 重试退避时间，每次翻倍，不超过max
+func Backoff(min, max time.Duration) Option {
+	return func(opts *options) {
+		opts.minBackoff = min
+		opts.maxBackoff = max
+	}
+}
+
+type enqueueOptions struct {
+	eta         time.Time
+	maxAttempts int
+}
+
+type EnqueueOption func(opts *enqueueOptions)
+
+// Delay 延迟执行
+func Delay(delay time.Duration) EnqueueOption {
+	return func(opts *enqueueOptions) {
+		opts.eta = time.Now().Add(delay)
+	}
+}
+
+// ETA 指定时间执行
+func ETA(eta time.Time) EnqueueOption {
+	return func(opts *enqueueOptions) {
+		opts.eta = eta
+	}
+}
+
+// Attempts 本任务的最大尝试次数
+func Attempts(maxAttempts int) EnqueueOption {
+	return func(opts *enqueueOptions) {
+		opts.maxAttempts = maxAttempts
+	}
+}
+
+// Queue 延迟和重试任务队列
+type Queue struct {
+	opts *options
+
+	handlers  map[string]Handler
+	handlersM sync.RWMutex
+
+	// 按执行时间排序的待执行任务
+	pending  taskHeap
+	pendingM sync.Mutex
+	wake     chan struct{}
+
+	ready   chan *Task
+	stopCh  chan struct{}
+	stopped bool
+
+	dispatcherDone chan struct{}
+	workers        *gosafe.Group
+
+	// 处理函数的ctx，Stop超时后取消
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger *minilog.MiniLog
+
+	enqueuedMetric  *prometheus.CounterVec
+	processedMetric *prometheus.CounterVec
+	pendingMetric   prometheus.Gauge
+	durationMetric  *prometheus.HistogramVec
+}
+
+func New(applyOptions ...Option) *Queue {
+	queue := &Queue{
+		opts: &options{
+			workers:     4,
+			maxAttempts: 5,
+			minBackoff:  time.Second,
+			maxBackoff:  10 * time.Minute,
+		},
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+
+	for _, applyOption := range applyOptions {
+		applyOption(queue.opts)
+	}
+
+	if queue.opts.backend == nil {
+		queue.opts.backend = NewMemoryBackend()
+	}
+
+	if queue.opts.workers <= 0 {
+		queue.opts.workers = 1
+	}
+
+	return queue
+}
+
+// Register 注册任务处理函数
+func (queue *Queue) Register(name string, handler Handler) {
+	queue.handlersM.Lock()
+	defer queue.handlersM.Unlock()
+
+	if _, ok := queue.handlers[name]; ok {
+		panic(fmt.Sprintf("task: %s already be registered", name))
+	}
+
+	queue.handlers[name] = handler
+}
+
+// Enqueue 投递任务，payload会被json序列化
+func (queue *Queue) Enqueue(name string, payload any, applyOptions ...EnqueueOption) (string, error) {
+	opts := &enqueueOptions{
+		maxAttempts: queue.opts.maxAttempts,
+	}
+	for _, applyOption := range applyOptions {
+		applyOption(opts)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	task := &Task{
+		Id:          newTaskId(),
+		Name:        name,
+		Payload:     data,
+		ETA:         opts.eta,
+		MaxAttempts: opts.maxAttempts,
+		CreatedAt:   now,
+	}
+	if task.ETA.IsZero() {
+		task.ETA = now
+	}
+
+	queue.pendingM.Lock()
+	defer queue.pendingM.Unlock()
+
+	if queue.stopped {
+		return "", ErrorTaskQueueStopped
+	}
+
+	if err := queue.opts.backend.Save(task); err != nil {
+		return "", err
+	}
+
+	queue.push(task)
+	if queue.enqueuedMetric != nil {
+		queue.enqueuedMetric.WithLabelValues(name).Inc()
+	}
+
+	return task.Id, nil
+}
+
+// DeadLetters 全部死信
+func (queue *Queue) DeadLetters() ([]*Task, error) {
+	return queue.opts.backend.DeadLetters()
+}
+
+// Start 恢复未完成的任务并开始处理
+func (queue *Queue) Start(ctx context.Context) error {
+	queue.ctx, queue.cancel = context.WithCancel(detach(ctx))
+	queue.logger = ctxhelper.FetchLogger(ctx)
+
+	// metric注册失败不影响处理任务
+	if err := queue.initMetric(ctx); err != nil {
+		queue.logger.Error("init metric", zap.Error(err), zap.String("service", "tasks"), zap.Bool("result", false))
+	}
+
+	tasks, err := queue.opts.backend.Load()
+	if err != nil {
+		queue.logger.Error("service run", zap.Error(err), zap.String("service", "tasks"), zap.Bool("result", false))
+		return err
+	}
+
+	// 启动前投递的任务已经在队列里
+	queue.pendingM.Lock()
+	queued := make(map[string]struct{}, queue.pending.Len())
+	for _, task := range queue.pending {
+		queued[task.Id] = struct{}{}
+	}
+	for _, task := range tasks {
+		if _, ok := queued[task.Id]; ok {
+			continue
+		}
+		queue.push(task)
+	}
+	queue.pendingM.Unlock()
+
+	queue.ready = make(chan *Task)
+	queue.stopCh = make(chan struct{})
+	queue.dispatcherDone = make(chan struct{})
+
+	queue.workers, _ = gosafe.NewGroup(context.Background(), gosafe.Name("tasks"), gosafe.KeepGoing())
+	for i := 0; i < queue.opts.workers; i++ {
+		queue.workers.Go("worker", queue.work)
+	}
+	go queue.dispatch()
+
+	queue.logger.Info("service run", zap.Int("workers", queue.opts.workers), zap.Int("recovered", len(tasks)), zap.Bool("result", true), zap.String("service", "tasks"))
+	return nil
+}
+
+// Stop 不再接收新任务，处理完已经到期的任务后退出，没到期的任务留在存储里；
+// ctx超时后取消处理函数的ctx，不再分发，剩下的任务留在存储里
+func (queue *Queue) Stop(ctx context.Context) error {
+	if queue.stopCh == nil {
+		return nil
+	}
+
+	queue.pendingM.Lock()
+	if queue.stopped {
+		queue.pendingM.Unlock()
+		return nil
+	}
+	queue.stopped = true
+	queue.pendingM.Unlock()
+
+	close(queue.stopCh)
+	<-queue.dispatcherDone
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// 处理已经到期的任务
+	drain:
+		for {
+			queue.pendingM.Lock()
+			task := queue.popReady(time.Now())
+			queue.pendingM.Unlock()
+			if task == nil {
+				break
+			}
+
+			select {
+			case queue.ready <- task:
+			case <-queue.ctx.Done():
+				// 超时了，放回去，任务还在存储里
+				queue.pendingM.Lock()
+				queue.push(task)
+				queue.pendingM.Unlock()
+				break drain
+			}
+		}
+
+		close(queue.ready)
+		_ = queue.workers.Wait()
+	}()
+
+	select {
+	case <-done:
+		queue.cancel()
+		queue.pendingM.Lock()
+		left := queue.pending.Len()
+		queue.pendingM.Unlock()
+		queue.logger.Info("service shutdown", zap.Int("delayed", left), zap.String("service", "tasks"), zap.Bool("result", true))
+		return nil
+	case <-ctx.Done():
+		queue.cancel()
+		queue.logger.Error("service shutdown", zap.Error(ctx.Err()), zap.String("service", "tasks"), zap.Bool("result", false))
+		return ctx.Err()
+	}
+}
+
+// dispatch 到期的任务交给worker
+func (queue *Queue) dispatch() {
+	defer close(queue.dispatcherDone)
+
+	for {
+		queue.pendingM.Lock()
+		now := time.Now()
+		task := queue.popReady(now)
+		wait := time.Minute
+		if task == nil && queue.pending.Len() != 0 {
+			wait = queue.pending[0].ETA.Sub(now)
+		}
+		queue.pendingM.Unlock()
+
+		if task != nil {
+			select {
+			case queue.ready <- task:
+			case <-queue.stopCh:
+				queue.pendingM.Lock()
+				queue.push(task)
+				queue.pendingM.Unlock()
+				return
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-queue.wake:
+		case <-timer.C:
+		case <-queue.stopCh:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+func (queue *Queue) work(ctx context.Context) error {
+	for task := range queue.ready {
+		queue.process(task)
+	}
+	return nil
+}
+
+// process 处理任务，失败按指数退避重试，超过次数转入死信
+func (queue *Queue) process(task *Task) {
+	queue.handlersM.RLock()
+	handler, ok := queue.handlers[task.Name]
+	queue.handlersM.RUnlock()
+
+	startTime := time.Now()
+	var err error
+	if !ok {
+		err = ErrorNoSuchTaskHandler
+	} else {
+		ctx, end := tracing.Start(queue.ctx, "task "+task.Name,
+			attribute.String("id", task.Id),
+			attribute.Int("attempts", task.Attempts),
+		)
+		err = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("task panic: %w", panics.FromContext(ctx).Capture(panics.SourceTask, r, "", task.Name))
+				}
+			}()
+
+			return handler(ctx, task)
+		}()
+		end(err)
+	}
+
+	if queue.durationMetric != nil {
+		queue.durationMetric.WithLabelValues(task.Name).Observe(time.Since(startTime).Seconds())
+	}
+
+	// 退出时被取消，不算一次尝试，任务留在存储里下次启动再处理
+	if err != nil && queue.ctx.Err() != nil {
+		queue.logger.Warn("task canceled", zap.String("task", task.Name), zap.String("id", task.Id), zap.Error(err))
+		queue.metricProcessed(task.Name, "canceled")
+		return
+	}
+
+	// 成功
+	if err == nil {
+		if err := queue.opts.backend.Remove(task.Id); err != nil {
+			queue.logger.Error("remove task", zap.String("task", task.Name), zap.String("id", task.Id), zap.Error(err))
+		}
+		queue.metricProcessed(task.Name, "success")
+		return
+	}
+
+	task.Attempts++
+	task.LastError = err.Error()
+
+	// 死信
+	if !ok || task.Attempts >= task.MaxAttempts {
+		queue.logger.Error("task dead", zap.String("task", task.Name), zap.String("id", task.Id), zap.Int("attempts", task.Attempts), zap.Error(err))
+		if err := queue.opts.backend.DeadLetter(task); err != nil {
+			queue.logger.Error("dead letter task", zap.String("task", task.Name), zap.String("id", task.Id), zap.Error(err))
+		}
+		queue.metricProcessed(task.Name, "dead")
+		return
+	}
+
+	// 重试
+	task.ETA = time.Now().Add(queue.backoff(task.Attempts))
+	queue.logger.Warn("task retry", zap.String("task", task.Name), zap.String("id", task.Id), zap.Int("attempts", task.Attempts), zap.Time("eta", task.ETA), zap.Error(err))
+	if err := queue.opts.backend.Save(task); err != nil {
+		queue.logger.Error("save task", zap.String("task", task.Name), zap.String("id", task.Id), zap.Error(err))
+	}
+
+	queue.pendingM.Lock()
+	queue.push(task)
+	queue.pendingM.Unlock()
+	queue.metricProcessed(task.Name, "retry")
+}
+
+func (queue *Queue) backoff(attempts int) time.Duration {
+	backoff := queue.opts.minBackoff
+	for i := 1; i < attempts && backoff < queue.opts.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > queue.opts.maxBackoff {
+		backoff = queue.opts.maxBackoff
+	}
+
+	return backoff
+}
+
+// push 需要持有pendingM
+func (queue *Queue) push(task *Task) {
+	heap.Push(&queue.pending, task)
+	if queue.pendingMetric != nil {
+		queue.pendingMetric.Inc()
+	}
+
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// popReady 需要持有pendingM
+func (queue *Queue) popReady(now time.Time) *Task {
+	if queue.pending.Len() == 0 || queue.pending[0].ETA.After(now) {
+		return nil
+	}
+
+	task := heap.Pop(&queue.pending).(*Task)
+	if queue.pendingMetric != nil {
+		queue.pendingMetric.Dec()
+	}
+
+	return task
+}
+
+func (queue *Queue) initMetric(ctx context.Context) error {
+	registry := ctxhelper.FetchRegistry(ctx)
+	if registry == nil {
+		return nil
+	}
+
+	enqueuedMetric := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tasks",
+		Name:      "enqueued",
+		Help:      "投递任务数",
+	}, []string{"task"})
+
+	processedMetric := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tasks",
+		Name:      "processed",
+		Help:      "处理任务数",
+	}, []string{"task", "result"})
+
+	pendingMetric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tasks",
+		Name:      "pending",
+		Help:      "待处理任务数",
+	})
+
+	durationMetric := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tasks",
+		Name:      "duration_seconds",
+		Help:      "任务处理耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"task"})
+
+	// 同一个registry上的多个队列共用metric
+	var err error
+	if enqueuedMetric, err = registerCollector(registry, enqueuedMetric); err != nil {
+		return err
+	}
+	if processedMetric, err = registerCollector(registry, processedMetric); err != nil {
+		return err
+	}
+	if pendingMetric, err = registerCollector(registry, pendingMetric); err != nil {
+		return err
+	}
+	if durationMetric, err = registerCollector(registry, durationMetric); err != nil {
+		return err
+	}
+
+	queue.pendingM.Lock()
+	defer queue.pendingM.Unlock()
+	queue.enqueuedMetric = enqueuedMetric
+	queue.processedMetric = processedMetric
+	queue.pendingMetric = pendingMetric
+	queue.durationMetric = durationMetric
+	// 启动前投递的任务
+	queue.pendingMetric.Add(float64(queue.pending.Len()))
+
+	return nil
+}
+
+// registerCollector 注册metric，已经注册过则返回已有的
+func registerCollector[T prometheus.Collector](registry *prometheus.Registry, collector T) (T, error) {
+	if err := registry.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return collector, err
+	}
+
+	return collector, nil
+}
+
+func (queue *Queue) metricProcessed(name, result string) {
+	if queue.processedMetric == nil {
+		return
+	}
+	queue.processedMetric.WithLabelValues(name, result).Inc()
+}
+
+func newTaskId() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// taskHeap 按执行时间排序
+type taskHeap []*Task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].ETA.Before(h[j].ETA) }
+func (h taskHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x any)        { *h = append(*h, x.(*Task)) }
+func (h *taskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return task
+}
+
+// detachedContext 保留ctx里的值，但不跟着ctx取消，退出时还要处理完剩余任务
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"espresso/pkg/ctxhelper"
+	"github.com/dan-and-dna/minilog"
+	"go.uber.org/zap/zaptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startQueue(t *testing.T, applyOptions ...Option) *Queue {
+	t.Helper()
+
+	queue := New(applyOptions...)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = queue.Stop(ctx)
+	})
+
+	return queue
+}
+
+func testContext(t *testing.T) context.Context {
+	return ctxhelper.InjectLogger(context.Background(), &minilog.MiniLog{Logger: zaptest.NewLogger(t)})
+}
+
+func TestBackoff(t *testing.T) {
+	queue := New(Backoff(time.Second, 10*time.Second))
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := queue.backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	backend := NewMemoryBackend()
+	queue := startQueue(t, WithBackend(backend), Backoff(time.Millisecond, 5*time.Millisecond), MaxAttempts(3))
+
+	var calls atomic.Int32
+	queue.Register("fail", func(ctx context.Context, task *Task) error {
+		calls.Add(1)
+		return errors.New("always fail")
+	})
+
+	if err := queue.Start(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	id, err := queue.Enqueue("fail", map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var deadLetters []*Task
+	for time.Now().Before(deadline) {
+		if deadLetters, _ = backend.DeadLetters(); len(deadLetters) != 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if len(deadLetters) != 1 || deadLetters[0].Id != id || deadLetters[0].Attempts != 3 || deadLetters[0].LastError != "always fail" {
+		t.Fatalf("dead letters = %+v", deadLetters)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("calls = %d", calls.Load())
+	}
+	if left, _ := backend.Load(); len(left) != 0 {
+		t.Fatalf("left = %+v", left)
+	}
+}
+
+func TestRetrySucceed(t *testing.T) {
+	backend := NewMemoryBackend()
+	queue := startQueue(t, WithBackend(backend), Backoff(time.Millisecond, time.Millisecond))
+
+	done := make(chan *Task, 1)
+	queue.Register("flaky", func(ctx context.Context, task *Task) error {
+		if task.Attempts < 2 {
+			return errors.New("not yet")
+		}
+		done <- task
+		return nil
+	})
+
+	if err := queue.Start(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Enqueue("flaky", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case task := <-done:
+		if task.Attempts != 2 {
+			t.Fatalf("attempts = %d", task.Attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task not done")
+	}
+}
+
+func TestUnknownTaskDeadLetter(t *testing.T) {
+	backend := NewMemoryBackend()
+	queue := startQueue(t, WithBackend(backend))
+
+	if err := queue.Start(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Enqueue("unknown", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if deadLetters, _ := backend.DeadLetters(); len(deadLetters) == 1 {
+			if deadLetters[0].LastError != ErrorNoSuchTaskHandler.Error() {
+				t.Fatalf("last error = %q", deadLetters[0].LastError)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no dead letter")
+}
+
+func TestStopTimeout(t *testing.T) {
+	backend := NewMemoryBackend()
+	queue := New(WithBackend(backend), Workers(1))
+
+	started := make(chan struct{}, 3)
+	queue.Register("stuck", func(ctx context.Context, task *Task) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := queue.Start(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := queue.Enqueue("stuck", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+
+	// 超时后取消处理函数，剩下的任务留在存储里，不算尝试次数
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := queue.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop err = %v", err)
+	}
+
+	left, _ := backend.Load()
+	if len(left) != 3 {
+		t.Fatalf("left = %d", len(left))
+	}
+	for _, task := range left {
+		if task.Attempts != 0 {
+			t.Fatalf("attempts = %d", task.Attempts)
+		}
+	}
+	if deadLetters, _ := backend.DeadLetters(); len(deadLetters) != 0 {
+		t.Fatalf("dead letters = %+v", deadLetters)
+	}
+}
+
+func TestMemoryBackendCopies(t *testing.T) {
+	backend := NewMemoryBackend()
+	task := &Task{Id: "1", Name: "a"}
+	_ = backend.Save(task)
+
+	// 修改保存后的任务不影响存储
+	task.Attempts = 5
+	loaded, _ := backend.Load()
+	if loaded[0].Attempts != 0 {
+		t.Fatalf("attempts = %d", loaded[0].Attempts)
+	}
+
+	loaded[0].Attempts = 7
+	loaded, _ = backend.Load()
+	if loaded[0].Attempts != 0 {
+		t.Fatalf("attempts = %d", loaded[0].Attempts)
+	}
+}
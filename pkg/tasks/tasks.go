@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"espresso/pkg/tasks/internal"
+	"time"
+)
+
+type Queue = internal.Queue
+type Task = internal.Task
+type Handler = internal.Handler
+type Backend = internal.Backend
+type Option = internal.Option
+type EnqueueOption = internal.EnqueueOption
+
+func WithBackend(backend Backend) Option {
+	return internal.WithBackend(backend)
+}
+
+func Workers(workers int) Option {
+	return internal.Workers(workers)
+}
+
+func MaxAttempts(maxAttempts int) Option {
+	return internal.MaxAttempts(maxAttempts)
+}
+
+func Backoff(min, max time.Duration) Option {
+	return internal.Backoff(min, max)
+}
+
+func Delay(delay time.Duration) EnqueueOption {
+	return internal.Delay(delay)
+}
+
+func ETA(eta time.Time) EnqueueOption {
+	return internal.ETA(eta)
+}
+
+func Attempts(maxAttempts int) EnqueueOption {
+	return internal.Attempts(maxAttempts)
+}
+
+// MemoryBackend 内存存储
+func MemoryBackend() Backend {
+	return internal.NewMemoryBackend()
+}
+
+// FileBackend 本地文件存储
+func FileBackend(dir string) (Backend, error) {
+	return internal.NewFileBackend(dir)
+}
+
+func New(options ...Option) *Queue {
+	return internal.New(options...)
+}
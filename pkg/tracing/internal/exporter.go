@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"os"
+)
+
+// OTLPExporter 通过http把span发送到OTLP collector，endpoint比如 localhost:4318
+func OTLPExporter(ctx context.Context, endpoint string, insecure bool) (Exporter, error) {
+	clientOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+	}
+
+	return otlptracehttp.New(ctx, clientOptions...)
+}
+
+// StdoutExporter span打印到标准输出，本地调试用
+func StdoutExporter() (Exporter, error) {
+	return stdouttrace.New(stdouttrace.WithPrettyPrint())
+}
+
+// FileExporter span按行写到文件，json格式
+func FileExporter(path string) (Exporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &fileExporter{Exporter: exporter, file: file}, nil
+}
+
+// fileExporter 退出时关闭文件
+type fileExporter struct {
+	Exporter
+	file *os.File
+}
+
+func (exporter *fileExporter) Shutdown(ctx context.Context) error {
+	err := exporter.Exporter.Shutdown(ctx)
+	if closeErr := exporter.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
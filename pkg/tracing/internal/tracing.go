@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"context"
+	"espresso/pkg/ctxhelper"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const instrumentationName = "espresso"
+
+// propagator Extract和Inject总是用W3C traceparent，不依赖全局的，没有开启tracing也传递
+var propagator = propagation.TraceContext{}
+
+// SetPropagator 开启tracing时调用，全局的propagator没有设置过才设置为W3C traceparent，让其他用otel的库也能传递
+func SetPropagator() {
+	if len(otel.GetTextMapPropagator().Fields()) == 0 {
+		otel.SetTextMapPropagator(propagator)
+	}
+}
+
+type Exporter = sdktrace.SpanExporter
+
+type options struct {
+	serviceName string
+	exporters   []Exporter
+	sampleRatio float64
+}
+
+type Option func(opts *options)
+
+// ServiceName 服务名，默认espresso
+func ServiceName(name string) Option {
+	return func(opts *options) {
+		opts.serviceName = name
+	}
+}
+
+// WithExporter 导出span，可以多个
+func WithExporter(exporter Exporter) Option {
+	return func(opts *options) {
+		if exporter != nil {
+			opts.exporters = append(opts.exporters, exporter)
+		}
+	}
+}
+
+// SampleRatio 采样率 0~1，上游已经采样的请求跟随上游，默认1
+func SampleRatio(ratio float64) Option {
+	return func(opts *options) {
+		opts.sampleRatio = ratio
+	}
+}
+
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// contextKey App注入自己的Tracing，多个App的span互不影响
+var contextKey = ctxhelper.NewKey[*Tracing]("core_tracing")
+
+// New 创建TracerProvider，注入到ctx后生效，不修改全局的
+func New(applyOptions ...Option) *Tracing {
+	opts := &options{
+		serviceName: "espresso",
+		sampleRatio: 1,
+	}
+	for _, applyOption := range applyOptions {
+		applyOption(opts)
+	}
+
+	providerOptions := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(opts.serviceName))),
+	}
+	for _, exporter := range opts.exporters {
+		providerOptions = append(providerOptions, sdktrace.WithBatcher(exporter))
+	}
+
+	return &Tracing{provider: sdktrace.NewTracerProvider(providerOptions...)}
+}
+
+// SetGlobal 设置为全局的TracerProvider，没有注入Tracing的ctx和直接用otel的库都用它
+func (tracing *Tracing) SetGlobal() {
+	otel.SetTracerProvider(tracing.provider)
+}
+
+// InjectTracing 注入Tracing，之后用这个ctx开始的span都用它
+func InjectTracing(ctx context.Context, tracing *Tracing) context.Context {
+	return contextKey.Inject(ctx, tracing)
+}
+
+// tracer ctx里注入的Tracing，其次是父span所属的，最后是全局的
+func tracer(ctx context.Context) trace.Tracer {
+	if tracing, ok := contextKey.Fetch(ctx); ok && tracing != nil {
+		return tracing.provider.Tracer(instrumentationName)
+	}
+	if span := trace.SpanFromContext(parentContext(ctx)); span.IsRecording() {
+		return span.TracerProvider().Tracer(instrumentationName)
+	}
+
+	return otel.Tracer(instrumentationName)
+}
+
+// Shutdown 导出剩下的span
+func (tracing *Tracing) Shutdown(ctx context.Context) error {
+	if tracing == nil {
+		return nil
+	}
+
+	return tracing.provider.Shutdown(ctx)
+}
+
+// parentContext *gin.Context 的span放在Request的context里
+func parentContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		return c.Request.Context()
+	}
+
+	return ctx
+}
+
+// Start 开始一个span，返回带span的context和结束函数，err不为空时记录错误；
+// *gin.Context 把span放到Request的context里，返回的还是原来的 *gin.Context，结束时恢复
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	spanCtx, span := tracer(ctx).Start(parentContext(ctx), name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+
+	end := func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		request := c.Request
+		c.Request = request.WithContext(spanCtx)
+		return c, func(err error) {
+			end(err)
+			c.Request = request
+		}
+	}
+
+	return spanCtx, end
+}
+
+// SpanFromContext 当前的span，支持 *gin.Context
+func SpanFromContext(ctx context.Context) trace.Span {
+	return trace.SpanFromContext(parentContext(ctx))
+}
+
+// TraceId 当前的trace id，没有则返回空
+func TraceId(ctx context.Context) string {
+	spanContext := SpanFromContext(ctx).SpanContext()
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
+
+// Extract 从header取出W3C traceparent
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject 把W3C traceparent写到header，比如调用其他服务时
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(parentContext(ctx), propagation.HeaderCarrier(header))
+}
+
+// InjectMetadata 把W3C traceparent写到grpc metadata
+func InjectMetadata(ctx context.Context, metadata map[string][]string) {
+	propagator.Inject(parentContext(ctx), metadataCarrier(metadata))
+}
+
+// metadataCarrier grpc metadata的key都是小写
+type metadataCarrier map[string][]string
+
+func (carrier metadataCarrier) Get(key string) string {
+	values := carrier[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (carrier metadataCarrier) Set(key, value string) {
+	carrier[key] = []string{value}
+}
+
+func (carrier metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Transport 调用其他http服务时创建span并传递traceparent
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripper(func(request *http.Request) (*http.Response, error) {
+		ctx, end := Start(request.Context(), "HTTP "+request.Method, trace.SpanKindClient,
+			semconv.HTTPMethod(request.Method),
+			semconv.HTTPURL(request.URL.String()),
+		)
+
+		request = request.Clone(ctx)
+		Inject(ctx, request.Header)
+
+		response, err := base.RoundTrip(request)
+		if err == nil {
+			SpanFromContext(ctx).SetAttributes(semconv.HTTPStatusCode(response.StatusCode))
+		}
+		end(err)
+
+		return response, err
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+	"testing"
+)
+
+func TestPropagator(t *testing.T) {
+	// 导入包不修改全局的propagator
+	if fields := otel.GetTextMapPropagator().Fields(); len(fields) != 0 {
+		t.Fatalf("global propagator fields = %v", fields)
+	}
+
+	// 没有开启tracing也传递traceparent
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	inbound := http.Header{}
+	inbound.Set("traceparent", traceparent)
+	ctx := Extract(context.Background(), inbound)
+
+	outbound := http.Header{}
+	Inject(ctx, outbound)
+	if got := outbound.Get("traceparent"); got != traceparent {
+		t.Fatalf("traceparent = %q", got)
+	}
+	if TraceId(ctx) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace id = %q", TraceId(ctx))
+	}
+
+	// 调用者设置过的不覆盖
+	baggage := propagation.Baggage{}
+	otel.SetTextMapPropagator(baggage)
+	SetPropagator()
+	if _, ok := otel.GetTextMapPropagator().(propagation.Baggage); !ok {
+		t.Fatalf("global propagator = %T", otel.GetTextMapPropagator())
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"espresso/pkg/tracing/internal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+type Tracing = internal.Tracing
+type Option = internal.Option
+type Exporter = internal.Exporter
+
+func ServiceName(name string) Option {
+	return internal.ServiceName(name)
+}
+
+func WithExporter(exporter Exporter) Option {
+	return internal.WithExporter(exporter)
+}
+
+// SampleRatio 采样率 0~1，上游已经采样的请求跟随上游
+func SampleRatio(ratio float64) Option {
+	return internal.SampleRatio(ratio)
+}
+
+// New 创建TracerProvider，用 InjectTracing 注入到ctx或者 SetGlobal 设为全局的才生效，否则span都是空操作
+func New(options ...Option) *Tracing {
+	return internal.New(options...)
+}
+
+// SetPropagator 全局的propagator没有设置过才设置为W3C traceparent，不覆盖调用者设置的；espresso.Tracing 开启时会调用
+func SetPropagator() {
+	internal.SetPropagator()
+}
+
+// InjectTracing 注入Tracing，之后用这个ctx开始的span和它们的子span都用它
+func InjectTracing(ctx context.Context, tracing *Tracing) context.Context {
+	return internal.InjectTracing(ctx, tracing)
+}
+
+// OTLPExporter 通过http发送到OTLP collector，endpoint比如 localhost:4318
+func OTLPExporter(ctx context.Context, endpoint string, insecure bool) (Exporter, error) {
+	return internal.OTLPExporter(ctx, endpoint, insecure)
+}
+
+// StdoutExporter 打印到标准输出
+func StdoutExporter() (Exporter, error) {
+	return internal.StdoutExporter()
+}
+
+// FileExporter 按行写到文件
+func FileExporter(path string) (Exporter, error) {
+	return internal.FileExporter(path)
+}
+
+// Start 开始一个span，*gin.Context 返回的还是原来的 *gin.Context
+//
+//	ctx, end := tracing.Start(ctx, "load words")
+//	defer end(err)
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	return internal.Start(ctx, name, trace.SpanKindInternal, attrs...)
+}
+
+func SpanFromContext(ctx context.Context) trace.Span {
+	return internal.SpanFromContext(ctx)
+}
+
+func TraceId(ctx context.Context) string {
+	return internal.TraceId(ctx)
+}
+
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return internal.Extract(ctx, header)
+}
+
+func Inject(ctx context.Context, header http.Header) {
+	internal.Inject(ctx, header)
+}
+
+func InjectMetadata(ctx context.Context, metadata map[string][]string) {
+	internal.InjectMetadata(ctx, metadata)
+}
+
+// Transport 调用其他http服务时创建span并传递traceparent
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return internal.Transport(base)
+}
+
+// StartWithKind 开始一个指定类型的span，比如入口请求是 trace.SpanKindServer
+func StartWithKind(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	return internal.Start(ctx, name, kind, attrs...)
+}